@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/web"
+)
+
+type APIKeyService interface {
+	Create(ctx context.Context, merchantID string, key *model.APIKey) (*model.APIKey, error)
+	List(ctx context.Context, merchantID string) ([]model.Object, error)
+	Revoke(ctx context.Context, merchantID, keyID string) error
+}
+
+type APIKeyController struct {
+	apiKeyService APIKeyService
+}
+
+func NewAPIKeyController(apiKeyService APIKeyService) web.Controller {
+	return &APIKeyController{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// create returns the new key of the merchant. The key is not shown again.
+func (c *APIKeyController) create(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeAPIKeyError)
+	if !ok {
+		return
+	}
+	result, err := c.apiKeyService.Create(req.Request.Context(), merchantID, req.Model.(*model.APIKey))
+	if err != nil {
+		writeAPIKeyError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusCreated, result)
+}
+
+func (c *APIKeyController) list(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeAPIKeyError)
+	if !ok {
+		return
+	}
+	result, err := c.apiKeyService.List(req.Request.Context(), merchantID)
+	if err != nil {
+		writeAPIKeyError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *APIKeyController) revoke(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeAPIKeyError)
+	if !ok {
+		return
+	}
+	if err := c.apiKeyService.Revoke(req.Request.Context(), merchantID, mux.Vars(req.Request)["key"]); err != nil {
+		writeAPIKeyError(req.Request.Context(), rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func writeAPIKeyError(ctx context.Context, rw http.ResponseWriter, err error) {
+	switch err {
+	case storage.ErrNotFound:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusNotFound,
+			Description: err.Error(),
+		})
+	default:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		})
+	}
+}
+
+func (c *APIKeyController) Routes() []web.Route {
+	return []web.Route{
+		{
+			ModelBlueprint: func() model.Object {
+				return &model.APIKey{}
+			},
+			Endpoint: web.Endpoint{
+				Method: http.MethodPost,
+				Path:   "/merchant/{uuid}/api_key",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"api_key.write"}
+			},
+			Handler: c.create,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant/{uuid}/api_key",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"api_key.read"}
+			},
+			Handler: c.list,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodDelete,
+				Path:   "/merchant/{uuid}/api_key/{key}",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"api_key.write"}
+			},
+			Handler: c.revoke,
+		},
+	}
+}
@@ -1,18 +1,19 @@
 package filter
 
 import (
-	"log"
 	"net/http"
 
 	"github.com/pankrator/payment/auth"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/web"
+	"github.com/sirupsen/logrus"
 )
 
 type Auth struct {
-	authenticator *auth.TokenAuthenticator
+	authenticator auth.Authenticator
 }
 
-func NewAuthFilter(authenticator *auth.TokenAuthenticator) *Auth {
+func NewAuthFilter(authenticator auth.Authenticator) *Auth {
 	return &Auth{
 		authenticator: authenticator,
 	}
@@ -21,33 +22,16 @@ func NewAuthFilter(authenticator *auth.TokenAuthenticator) *Auth {
 func (m *Auth) Execute(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 	user, err := m.authenticator.Authenticate(req)
 	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusUnauthorized,
 			Description: err.Error(),
 		})
 		return
 	}
-	log.Printf("Logged in user is: %s", user.Email)
-	ctx := req.Context()
+	ctx := log.ContextWithFields(req.Context(), logrus.Fields{"user": user.Name})
+	log.C(ctx).Debugf("Logged in user is: %s", user.Email)
 	ctx = web.ContextWithUser(ctx, user)
 	req = req.WithContext(ctx)
 
 	next.ServeHTTP(rw, req)
 }
-
-func (m *Auth) Matchers() []web.Endpoint {
-	return []web.Endpoint{
-		{
-			Path:   "/payment",
-			Method: http.MethodPost,
-		},
-		{
-			Path:   "/payment",
-			Method: http.MethodGet,
-		},
-		{
-			Path:   "/transactions",
-			Method: http.MethodGet,
-		},
-	}
-}
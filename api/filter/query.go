@@ -1,14 +1,16 @@
 package filter
 
 import (
-	"log"
 	"net/http"
 
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/query"
 
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/storage"
 	"github.com/pankrator/payment/web"
+	"github.com/sirupsen/logrus"
 )
 
 type Query struct {
@@ -25,46 +27,30 @@ func (q *Query) Execute(rw http.ResponseWriter, req *http.Request, next http.Han
 	ctx := req.Context()
 	user, found := web.UserFromContext(ctx)
 	if !found {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusUnauthorized,
 			Description: "No user found",
 		})
 		return
 	}
-	object, err := q.repository.GetBy(model.MerchantType, "email = ?", user.Email)
+	object, err := q.repository.GetBy(ctx, model.MerchantType, criteria.Eq("email", user.Email))
 	if err != nil {
 		if err == storage.ErrNotFound {
-			log.Printf("Merchant with email %s not found. Proceed without merchant id filter", user.Email)
+			log.C(ctx).Debugf("Merchant with email %s not found. Proceed without merchant id filter", user.Email)
 			next.ServeHTTP(rw, req)
 			return
 		} else {
-			web.WriteError(rw, err)
+			web.WriteError(req.Context(), rw, err)
 			return
 		}
 	}
 	merchant := object.(*model.Merchant)
 
-	log.Printf("Adding query on transaction for merchant %s", user.Email)
-	ctx = query.AddQuery(ctx, query.Query{
-		Type:      model.TransactionObjectType,
-		Key:       "merchant_id",
-		Operation: "=",
-		Value:     merchant.UUID,
-	})
+	ctx = log.ContextWithFields(ctx, logrus.Fields{"merchant_id": merchant.UUID})
+	log.C(ctx).Debugf("Adding query on transactions and merchants for merchant %s", user.Email)
+	ctx = query.AddCriterion(ctx, model.TransactionObjectType, criteria.Eq("merchant_id", merchant.UUID))
+	ctx = query.AddCriterion(ctx, model.MerchantType, criteria.Eq("uuid", merchant.UUID))
 	req = req.WithContext(ctx)
 
 	next.ServeHTTP(rw, req)
 }
-
-func (q *Query) Matchers() []web.Endpoint {
-	return []web.Endpoint{
-		{
-			Path:   "/payment",
-			Method: http.MethodGet,
-		},
-		{
-			Path:   "/transactions",
-			Method: http.MethodGet,
-		},
-	}
-}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/web"
+)
+
+type HealthRegistry interface {
+	Liveness(ctx context.Context) *health.Report
+	Readiness(ctx context.Context) *health.Report
+}
+
+type HealthController struct {
+	registry HealthRegistry
+}
+
+func NewHealthController(registry HealthRegistry) web.Controller {
+	return &HealthController{
+		registry: registry,
+	}
+}
+
+func (c *HealthController) liveness(rw http.ResponseWriter, req *web.Request) {
+	writeReport(rw, c.registry.Liveness(req.Request.Context()))
+}
+
+func (c *HealthController) readiness(rw http.ResponseWriter, req *web.Request) {
+	writeReport(rw, c.registry.Readiness(req.Request.Context()))
+}
+
+func writeReport(rw http.ResponseWriter, report *health.Report) {
+	status := http.StatusOK
+	if !report.Up() {
+		status = http.StatusServiceUnavailable
+	}
+	web.WriteJSON(rw, status, report)
+}
+
+func (c *HealthController) Routes() []web.Route {
+	return []web.Route{
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/healthz",
+			},
+			Public:  true,
+			Handler: c.liveness,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/readyz",
+			},
+			Public:  true,
+			Handler: c.readiness,
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/web"
+)
+
+type LedgerService interface {
+	Statement(ctx context.Context, merchantID string, from, to time.Time) (*model.Statement, error)
+	Verify(ctx context.Context, merchantID string) (*model.BalanceVerification, error)
+}
+
+type LedgerController struct {
+	ledgerService LedgerService
+}
+
+func NewLedgerController(ledgerService LedgerService) web.Controller {
+	return &LedgerController{
+		ledgerService: ledgerService,
+	}
+}
+
+// statement returns the statement of the merchant for the period given with the from and to query parameters.
+// The period ends now when to is not given.
+func (c *LedgerController) statement(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeLedgerError)
+	if !ok {
+		return
+	}
+	values := req.Request.URL.Query()
+	from, err := parseStatementTime(values.Get("from"), "from")
+	if err != nil {
+		web.WriteError(req.Request.Context(), rw, err)
+		return
+	}
+	to := time.Now()
+	if values.Get("to") != "" {
+		if to, err = parseStatementTime(values.Get("to"), "to"); err != nil {
+			web.WriteError(req.Request.Context(), rw, err)
+			return
+		}
+	}
+
+	result, err := c.ledgerService.Statement(req.Request.Context(), merchantID, from, to)
+	if err != nil {
+		writeLedgerError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+// balance compares the total transaction sum of the merchant with its balance in the ledger
+func (c *LedgerController) balance(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeLedgerError)
+	if !ok {
+		return
+	}
+	result, err := c.ledgerService.Verify(req.Request.Context(), merchantID)
+	if err != nil {
+		writeLedgerError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func parseStatementTime(value, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: fmt.Sprintf("%s is required", name),
+		}
+	}
+	result, err := query.ParseTime(value)
+	if err != nil {
+		return time.Time{}, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: fmt.Sprintf("%s should be a time in RFC 3339 format or a date", name),
+		}
+	}
+	return result, nil
+}
+
+func writeLedgerError(ctx context.Context, rw http.ResponseWriter, err error) {
+	switch err {
+	case storage.ErrNotFound:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusNotFound,
+			Description: "merchant not found",
+		})
+	case services.ErrInvalidStatementPeriod:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		})
+	default:
+		web.WriteError(ctx, rw, err)
+	}
+}
+
+func (c *LedgerController) Routes() []web.Route {
+	return []web.Route{
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant/{uuid}/statement",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.read"}
+			},
+			Handler: c.statement,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant/{uuid}/balance",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.read"}
+			},
+			Handler: c.balance,
+		},
+	}
+}
@@ -3,12 +3,12 @@ package api
 import (
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"path"
 	"time"
 
 	"github.com/pankrator/payment/auth"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/web"
 	"golang.org/x/oauth2"
 )
@@ -27,7 +27,7 @@ func (c *LoginController) loginPage(rw http.ResponseWriter, req *web.Request) {
 	fp := path.Join("templates", "login.html")
 	tmpl, err := template.ParseFiles(fp)
 	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "could not load view",
 		})
@@ -35,7 +35,7 @@ func (c *LoginController) loginPage(rw http.ResponseWriter, req *web.Request) {
 	}
 
 	if err := tmpl.Execute(rw, nil); err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "could not load view",
 		})
@@ -62,7 +62,7 @@ func (c *LoginController) login(rw http.ResponseWriter, req *web.Request) {
 
 	token, err := client.Token(ctx)
 	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusForbidden,
 			Description: fmt.Sprintf("Could not get token: %s", err),
 		})
@@ -81,7 +81,7 @@ func (c *LoginController) login(rw http.ResponseWriter, req *web.Request) {
 }
 
 func (c *LoginController) refresh(rw http.ResponseWriter, req *web.Request) {
-	log.Printf("Refresh token endpoint called")
+	log.C(req.Request.Context()).Infof("Refresh token endpoint called")
 	ctx := req.Request.Context()
 	cookie, err := req.Request.Cookie("refresh_token")
 	if err == http.ErrNoCookie {
@@ -103,7 +103,7 @@ func (c *LoginController) refresh(rw http.ResponseWriter, req *web.Request) {
 	})
 	token, err := client.Token(ctx)
 	if err != nil {
-		web.WriteError(rw, fmt.Errorf("could not refresh token: %s", err))
+		web.WriteError(req.Request.Context(), rw, fmt.Errorf("could not refresh token: %s", err))
 		return
 	}
 	http.SetCookie(rw, &http.Cookie{
@@ -118,7 +118,7 @@ func (c *LoginController) refresh(rw http.ResponseWriter, req *web.Request) {
 }
 
 func (c *LoginController) logout(rw http.ResponseWriter, req *web.Request) {
-	log.Printf("Logout endpoint called")
+	log.C(req.Request.Context()).Infof("Logout endpoint called")
 
 	http.SetCookie(rw, &http.Cookie{
 		Expires:  time.Now().Add(time.Minute * 60),
@@ -138,6 +138,7 @@ func (c *LoginController) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/login",
 			},
+			Public:  true,
 			Handler: c.loginPage,
 		},
 		{
@@ -145,6 +146,7 @@ func (c *LoginController) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/refresh",
 			},
+			Public:  true,
 			Handler: c.refresh,
 		},
 		{
@@ -152,6 +154,7 @@ func (c *LoginController) Routes() []web.Route {
 				Method: http.MethodPost,
 				Path:   "/login",
 			},
+			Public:  true,
 			Handler: c.login,
 		},
 		{
@@ -159,6 +162,7 @@ func (c *LoginController) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/logout",
 			},
+			Public:  true,
 			Handler: c.logout,
 		},
 	}
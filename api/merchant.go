@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/web"
+)
+
+type MerchantService interface {
+	Create(ctx context.Context, merchant *model.Merchant) (model.Object, error)
+	Get(ctx context.Context, uuid string) (*model.Merchant, error)
+	List(ctx context.Context, c criteria.Criterion) ([]model.Object, error)
+	Update(ctx context.Context, uuid string, merchant *model.Merchant) (*model.Merchant, error)
+	Deactivate(ctx context.Context, uuid string) (*model.Merchant, error)
+	Delete(ctx context.Context, uuid string) error
+}
+
+type MerchantController struct {
+	merchantService MerchantService
+}
+
+func NewMerchantController(merchantService MerchantService) web.Controller {
+	return &MerchantController{
+		merchantService: merchantService,
+	}
+}
+
+// list lists all merchants to admins and only their own merchant to merchants
+func (c *MerchantController) list(rw http.ResponseWriter, req *web.Request) {
+	criterion := query.CriterionFromContext(req.Request.Context(), model.MerchantType)
+	result, err := c.merchantService.List(req.Request.Context(), criterion)
+	if err != nil {
+		web.WriteError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *MerchantController) get(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeMerchantError)
+	if !ok {
+		return
+	}
+	result, err := c.merchantService.Get(req.Request.Context(), merchantID)
+	if err != nil {
+		writeMerchantError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *MerchantController) create(rw http.ResponseWriter, req *web.Request) {
+	merchant := req.Model.(*model.Merchant)
+	log.C(req.Request.Context()).Infof("Creating merchant %s", merchant.Name)
+
+	result, err := c.merchantService.Create(req.Request.Context(), merchant)
+	if err != nil {
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		})
+		return
+	}
+	web.WriteJSON(rw, http.StatusCreated, result)
+}
+
+func (c *MerchantController) update(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeMerchantError)
+	if !ok {
+		return
+	}
+	merchant := req.Model.(*model.Merchant)
+	result, err := c.merchantService.Update(req.Request.Context(), merchantID, merchant)
+	if err != nil {
+		writeMerchantError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *MerchantController) deactivate(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeMerchantError)
+	if !ok {
+		return
+	}
+	result, err := c.merchantService.Deactivate(req.Request.Context(), merchantID)
+	if err != nil {
+		writeMerchantError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *MerchantController) delete(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeMerchantError)
+	if !ok {
+		return
+	}
+	if err := c.merchantService.Delete(req.Request.Context(), merchantID); err != nil {
+		writeMerchantError(req.Request.Context(), rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// ownMerchantID returns the merchant of the path. Merchants cannot see the other merchants, only admins can.
+// The other merchants are reported as not found with the given error writer.
+func ownMerchantID(rw http.ResponseWriter, req *web.Request, writeError func(context.Context, http.ResponseWriter, error)) (string, bool) {
+	merchantID := mux.Vars(req.Request)["uuid"]
+	criterion := query.CriterionFromContext(req.Request.Context(), model.MerchantType)
+	if value, found := criteria.EqualValue(criterion, "uuid"); found && value != merchantID {
+		writeError(req.Request.Context(), rw, storage.ErrNotFound)
+		return "", false
+	}
+	return merchantID, true
+}
+
+func writeMerchantError(ctx context.Context, rw http.ResponseWriter, err error) {
+	switch err {
+	case storage.ErrNotFound:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusNotFound,
+			Description: "merchant not found",
+		})
+	case services.ErrMerchantHasTransactions, services.ErrMerchantHasLedgerEntries:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusConflict,
+			Description: err.Error(),
+		})
+	default:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		})
+	}
+}
+
+func (c *MerchantController) Routes() []web.Route {
+	return []web.Route{
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.read"}
+			},
+			Handler: c.list,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant/{uuid}",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.read"}
+			},
+			Handler: c.get,
+		},
+		{
+			ModelBlueprint: func() model.Object {
+				return &model.Merchant{}
+			},
+			Endpoint: web.Endpoint{
+				Method: http.MethodPost,
+				Path:   "/merchant",
+			},
+			Auth: true,
+			Scopes: func() []string {
+				return []string{"merchant.write"}
+			},
+			Handler: c.create,
+		},
+		{
+			ModelBlueprint: func() model.Object {
+				return &model.Merchant{}
+			},
+			Endpoint: web.Endpoint{
+				Method: http.MethodPut,
+				Path:   "/merchant/{uuid}",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.write"}
+			},
+			Handler: c.update,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodPost,
+				Path:   "/merchant/{uuid}/deactivate",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.write"}
+			},
+			Handler: c.deactivate,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodDelete,
+				Path:   "/merchant/{uuid}",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"merchant.delete"}
+			},
+			Handler: c.delete,
+		},
+	}
+}
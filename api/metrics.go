@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pankrator/payment/web"
+)
+
+type MetricsController struct {
+	path    string
+	handler http.Handler
+}
+
+// NewMetricsController exposes the metrics written by the handler on the path
+func NewMetricsController(path string, handler http.Handler) web.Controller {
+	return &MetricsController{
+		path:    path,
+		handler: handler,
+	}
+}
+
+func (c *MetricsController) metrics(rw http.ResponseWriter, req *web.Request) {
+	c.handler.ServeHTTP(rw, req.Request)
+}
+
+func (c *MetricsController) Routes() []web.Route {
+	return []web.Route{
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   c.path,
+			},
+			Public:  true,
+			Handler: c.metrics,
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/web"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePage reads the limit, cursor and order_by query parameters. A leading minus in order_by
+// orders descending, e.g. order_by=-created_at lists the newest transactions first.
+// Only the given fields can be used in order_by.
+func parsePage(values url.Values, defaultLimit int, orderFields []string) (query.Page, error) {
+	page := query.Page{
+		Limit:  defaultLimit,
+		Cursor: values.Get("cursor"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxPageLimit {
+			return page, &web.HTTPError{
+				StatusCode:  http.StatusBadRequest,
+				Description: fmt.Sprintf("limit should be a number between 1 and %d", maxPageLimit),
+			}
+		}
+		page.Limit = l
+	}
+
+	orderBy := values.Get("order_by")
+	if strings.HasPrefix(orderBy, "-") {
+		page.Descending = true
+		orderBy = orderBy[1:]
+	}
+	if orderBy != "" && !contains(orderFields, orderBy) {
+		return page, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: fmt.Sprintf("ordering by %s is not supported, order_by should be one of %s", orderBy, strings.Join(orderFields, ", ")),
+		}
+	}
+	page.OrderBy = orderBy
+	return page, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// nextPageURL returns the requested URL with the cursor of the next page
+func nextPageURL(requestURL *url.URL, cursor string) string {
+	values := requestURL.Query()
+	values.Set("cursor", cursor)
+	next := url.URL{
+		Path:     requestURL.Path,
+		RawQuery: values.Encode(),
+	}
+	return next.String()
+}
@@ -4,22 +4,19 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"path"
 	"time"
 
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/query"
 	"github.com/pankrator/payment/storage"
 	"github.com/pankrator/payment/web"
 )
 
-type MerchantService interface {
-	Create(*model.Merchant) (model.Object, error)
-	Get(string) (*model.Merchant, error)
-	List(query []query.Query) ([]model.Object, error)
-}
+const transactionsPageLimit = 20
 
 type PagesController struct {
 	paymentService  PaymentService
@@ -34,7 +31,7 @@ func NewPagesController(paymentService PaymentService, merchantService MerchantS
 }
 
 func (c *PagesController) showTransactions(rw http.ResponseWriter, req *web.Request) {
-	log.Printf("Requesting transactions page")
+	log.C(req.Request.Context()).Infof("Requesting transactions page")
 
 	fp := path.Join("templates", "transactions.html")
 	funcs := template.FuncMap{
@@ -44,50 +41,66 @@ func (c *PagesController) showTransactions(rw http.ResponseWriter, req *web.Requ
 	}
 	tmpl, err := template.New("transactions.html").Funcs(funcs).ParseFiles(fp)
 	if err != nil {
-		log.Printf("Could not load view: %s", err)
-		web.WriteError(rw, &web.HTTPError{
+		log.C(req.Request.Context()).Infof("Could not load view: %s", err)
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "could not load view",
 		})
 		return
 	}
-	ctx := req.Request.Context()
-	ctxQuery := query.QueryFromContext(ctx)
-	transactions, err := c.paymentService.List(ctxQuery)
+	page, err := parsePage(req.Request.URL.Query(), transactionsPageLimit, transactionOrderFields)
 	if err != nil {
-		web.WriteError(rw, err)
+		web.WriteError(req.Request.Context(), rw, err)
 		return
 	}
+	if page.OrderBy == "" {
+		page.OrderBy = "created_at"
+		page.Descending = true
+	}
 
-	var merchantID string
-	for _, cq := range ctxQuery {
-		if cq.Type == model.TransactionObjectType && cq.Key == "merchant_id" {
-			merchantID = cq.Value
-		}
+	ctx := req.Request.Context()
+	ctxCriterion := query.CriterionFromContext(ctx, model.TransactionObjectType)
+	// The page contains authorizations, each shown together with all transactions depending on it
+	authorizations, next, err := c.paymentService.ListPage(req.Request.Context(), page, criteria.And(ctxCriterion, criteria.Eq("type", model.Authorize)))
+	if err != nil {
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		})
+		return
 	}
-	merchant, err := c.merchantService.Get(merchantID)
+	descendants, err := c.paymentService.ListDescendants(req.Request.Context(), authorizations, ctxCriterion)
+	if err != nil {
+		web.WriteError(req.Request.Context(), rw, err)
+		return
+	}
+	transactions := append(authorizations, descendants...)
+
+	value, _ := criteria.EqualValue(ctxCriterion, "merchant_id")
+	merchantID, _ := value.(string)
+	merchant, err := c.merchantService.Get(req.Request.Context(), merchantID)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			merchant = nil
 		} else {
-			web.WriteError(rw, fmt.Errorf("could not get merchant: %s", err))
+			web.WriteError(req.Request.Context(), rw, fmt.Errorf("could not get merchant: %s", err))
 			return
 		}
 	}
 
-	transactionPageModel := mapTransactionByParents(transactions)
+	transactionPageModel := model.GroupChains(transactions)
 	user, found := web.UserFromContext(ctx)
 	if !found {
-		web.WriteError(rw, errors.New("user not found"))
+		web.WriteError(req.Request.Context(), rw, errors.New("user not found"))
 		return
 	}
 
 	merchants := make([]model.Object, 0)
 	matched, _ := web.HasScopes(user.Scopes, []string{"merchant.read"})
 	if matched {
-		merchants, err = c.merchantService.List(nil)
+		merchants, err = c.merchantService.List(req.Request.Context(), nil)
 		if err != nil {
-			web.WriteError(rw, err)
+			web.WriteError(req.Request.Context(), rw, err)
 			return
 		}
 	}
@@ -97,9 +110,10 @@ func (c *PagesController) showTransactions(rw http.ResponseWriter, req *web.Requ
 		"merchant":     merchant,
 		"merchants":    merchants,
 		"transactions": transactionPageModel,
+		"next":         next,
 	}); err != nil {
-		log.Printf("Could not execute view: %s", err)
-		web.WriteError(rw, &web.HTTPError{
+		log.C(req.Request.Context()).Infof("Could not execute view: %s", err)
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "could not execute view",
 		})
@@ -107,36 +121,6 @@ func (c *PagesController) showTransactions(rw http.ResponseWriter, req *web.Requ
 	}
 }
 
-func mapTransactionByParents(transactions []model.Object) map[string][]*model.Transaction {
-	children := make(map[string]*model.Transaction)
-
-	for _, t := range transactions {
-		tt := t.(*model.Transaction)
-		for _, i := range transactions {
-			ii := i.(*model.Transaction)
-			if ii.DependsOnUUID == tt.UUID {
-				children[tt.UUID] = ii
-			}
-		}
-	}
-
-	result := make(map[string][]*model.Transaction)
-	for _, t := range transactions {
-		tt := t.(*model.Transaction)
-		if tt.Type == model.Authorize {
-			result[tt.UUID] = make([]*model.Transaction, 0)
-			result[tt.UUID] = append(result[tt.UUID], tt)
-			currentChild, found := children[tt.UUID]
-			for found {
-				result[tt.UUID] = append(result[tt.UUID], currentChild)
-				currentChild, found = children[currentChild.UUID]
-			}
-		}
-	}
-
-	return result
-}
-
 func (c *PagesController) Routes() []web.Route {
 	return []web.Route{
 		{
@@ -144,7 +128,9 @@ func (c *PagesController) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/transactions",
 			},
-			Handler: c.showTransactions,
+			Auth:           true,
+			MerchantScoped: true,
+			Handler:        c.showTransactions,
 			Scopes: func() []string {
 				return []string{
 					"transaction.read",
@@ -156,6 +142,7 @@ func (c *PagesController) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/",
 			},
+			Public: true,
 			Handler: func(rw http.ResponseWriter, req *web.Request) {
 				http.Redirect(rw, req.Request, "/templates/resources", http.StatusPermanentRedirect)
 			},
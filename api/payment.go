@@ -1,76 +1,299 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"path"
 
+	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
 
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/web"
 )
 
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	comparisonOperators = []criteria.Operator{criteria.Equal, criteria.NotEqual, criteria.GreaterThan, criteria.GreaterOrEqual, criteria.LessThan, criteria.LessOrEqual, criteria.In, criteria.Between}
+	textOperators       = []criteria.Operator{criteria.Equal, criteria.NotEqual, criteria.Like, criteria.In}
+	enumOperators       = []criteria.Operator{criteria.Equal, criteria.NotEqual, criteria.In}
+
+	// transactionFilterFields are the fields of transactions which can be used in the filter query parameter
+	transactionFilterFields = map[string]query.Field{
+		"uuid":            {Kind: query.String, Operators: []criteria.Operator{criteria.Equal, criteria.In}},
+		"type":            {Kind: query.String, Operators: enumOperators},
+		"status":          {Kind: query.String, Operators: enumOperators},
+		"currency":        {Kind: query.String, Operators: enumOperators},
+		"merchant_id":     {Kind: query.String, Operators: []criteria.Operator{criteria.Equal, criteria.In}},
+		"amount":          {Kind: query.Int, Operators: comparisonOperators},
+		"captured_amount": {Kind: query.Int, Operators: comparisonOperators},
+		"refunded_amount": {Kind: query.Int, Operators: comparisonOperators},
+		"customer_email":  {Kind: query.String, Operators: textOperators},
+		"customer_phone":  {Kind: query.String, Operators: textOperators},
+		"created_at":      {Kind: query.Time, Operators: comparisonOperators},
+		"updated_at":      {Kind: query.Time, Operators: comparisonOperators},
+		"depends_on_uuid": {Kind: query.String, Operators: []criteria.Operator{criteria.Equal, criteria.In, criteria.IsNull, criteria.IsNotNull}},
+	}
+
+	// transactionOrderFields are the fields of transactions which can be used in the order_by query parameter.
+	// The cursors of the pages continue after the value of the last transaction, so the fields are never null.
+	transactionOrderFields = []string{"created_at", "updated_at", "amount", "captured_amount", "refunded_amount"}
+)
+
 type PaymentService interface {
-	Create(*model.Transaction) (model.Object, error)
-	List(query []query.Query) ([]model.Object, error)
+	Create(ctx context.Context, transaction *model.Transaction, actor string) (model.Object, error)
+	List(ctx context.Context, c criteria.Criterion) ([]model.Object, error)
+	ListPage(ctx context.Context, page query.Page, c criteria.Criterion) ([]model.Object, string, error)
+	ListDescendants(ctx context.Context, transactions []model.Object, c criteria.Criterion) ([]model.Object, error)
+	Chain(ctx context.Context, transactionID string, c criteria.Criterion) (*model.TransactionChain, error)
+	History(ctx context.Context, transactionID string, c criteria.Criterion) ([]*model.TransactionStatusChange, error)
+}
+
+type IdempotencyService interface {
+	Begin(ctx context.Context, merchantID, key, requestHash string) (*model.IdempotencyKey, error)
+	Complete(ctx context.Context, record *model.IdempotencyKey, statusCode int, body []byte) error
+	Release(ctx context.Context, record *model.IdempotencyKey) error
 }
 
 type PaymentController struct {
-	paymentService PaymentService
+	paymentService     PaymentService
+	idempotencyService IdempotencyService
 }
 
-func NewPaymentController(paymentService PaymentService) web.Controller {
+func NewPaymentController(paymentService PaymentService, idempotencyService IdempotencyService) web.Controller {
 	return &PaymentController{
-		paymentService: paymentService,
+		paymentService:     paymentService,
+		idempotencyService: idempotencyService,
 	}
 }
 
 func (c *PaymentController) payment(rw http.ResponseWriter, req *web.Request) {
-	log.Printf("Received payment transaction %+v", req.Model)
+	log.C(req.Request.Context()).Infof("Received payment transaction %+v", req.Model)
 
 	transaction := req.Model.(*model.Transaction)
 
-	result, err := c.paymentService.Create(transaction)
-	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+	// Merchants create transactions only for themselves
+	criterion := query.CriterionFromContext(req.Request.Context(), model.TransactionObjectType)
+	if merchantID, found := criteria.EqualValue(criterion, "merchant_id"); found {
+		if transaction.MerchantID == "" {
+			transaction.MerchantID = merchantID.(string)
+		}
+		if transaction.MerchantID != merchantID {
+			web.WriteError(req.Request.Context(), rw, &web.HTTPError{
+				StatusCode:  http.StatusForbidden,
+				Description: "transactions can be created only for the authenticated merchant",
+			})
+			return
+		}
+	}
+
+	ctx := req.Request.Context()
+	key := req.Request.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		status, response, err := c.create(ctx, transaction, actor(req))
+		if err != nil {
+			web.WriteError(ctx, rw, err)
+			return
+		}
+		body, err := json.Marshal(response)
+		if err != nil {
+			web.WriteError(ctx, rw, fmt.Errorf("could not marshal response: %s", err))
+			return
+		}
+		web.WriteBytes(rw, status, "application/json", body)
+		return
+	}
+	if len(key) > 255 {
+		web.WriteError(ctx, rw, &web.HTTPError{
 			StatusCode:  http.StatusBadRequest,
+			Description: "Idempotency key should not be longer than 255 characters",
+		})
+		return
+	}
+
+	hash, err := hashTransaction(transaction)
+	if err != nil {
+		web.WriteError(ctx, rw, err)
+		return
+	}
+	record, err := c.idempotencyService.Begin(ctx, transaction.MerchantID, key, hash)
+	switch err {
+	case nil:
+	case services.ErrIdempotencyKeyReused:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusUnprocessableEntity,
 			Description: err.Error(),
 		})
 		return
+	case services.ErrIdempotencyKeyInProgress:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusConflict,
+			Description: err.Error(),
+		})
+		return
+	default:
+		web.WriteError(ctx, rw, err)
+		return
 	}
 
-	web.WriteJSON(rw, http.StatusCreated, result)
+	if record.Completed() {
+		log.C(ctx).Infof("Replaying stored response for idempotency key %s", key)
+		rw.Header().Set("Idempotent-Replayed", "true")
+		web.WriteBytes(rw, record.StatusCode, "application/json", record.Body)
+		return
+	}
+
+	status, response, err := c.create(ctx, transaction, actor(req))
+	if err != nil {
+		// Only final outcomes are stored, so that the request can be sent again with the same key
+		if err := c.idempotencyService.Release(ctx, record); err != nil {
+			log.C(ctx).Errorf("Could not release idempotency key %s: %s", key, err)
+		}
+		web.WriteError(ctx, rw, err)
+		return
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		web.WriteError(ctx, rw, fmt.Errorf("could not marshal response: %s", err))
+		return
+	}
+	if err := c.idempotencyService.Complete(ctx, record, status, body); err != nil {
+		log.C(ctx).Errorf("Could not store response for idempotency key %s: %s", key, err)
+	}
+	web.WriteBytes(rw, status, "application/json", body)
+}
+
+// create creates the transaction and returns the status and the response. A rejected transaction is a final outcome
+// and is answered with a bad request. Other errors are returned, as the same request can still succeed.
+func (c *PaymentController) create(ctx context.Context, transaction *model.Transaction, actor string) (int, interface{}, error) {
+	result, err := c.paymentService.Create(ctx, transaction, actor)
+	switch err.(type) {
+	case nil:
+		return http.StatusCreated, result, nil
+	case *services.RejectedError:
+		log.C(ctx).Infof("Could not create transaction: %s", err)
+		return http.StatusBadRequest, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		}, nil
+	default:
+		return 0, nil, fmt.Errorf("could not create transaction: %s", err)
+	}
+}
+
+// actor returns the name of the authenticated user of the request, or its email when it has no name
+func actor(req *web.Request) string {
+	user, found := web.UserFromContext(req.Request.Context())
+	if !found {
+		return ""
+	}
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Email
+}
+
+// hashTransaction fingerprints the requested transaction, so that a retry can be told apart from a different request
+func hashTransaction(transaction *model.Transaction) (string, error) {
+	data, err := json.Marshal(transaction)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal transaction: %s", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
 
 func (c *PaymentController) list(rw http.ResponseWriter, req *web.Request) {
-	q := query.QueryFromContext(req.Request.Context())
-	result, err := c.paymentService.List(q)
+	page, err := parsePage(req.Request.URL.Query(), defaultPageLimit, transactionOrderFields)
+	if err != nil {
+		web.WriteError(req.Request.Context(), rw, err)
+		return
+	}
+
+	criterion := query.CriterionFromContext(req.Request.Context(), model.TransactionObjectType)
+	if filter := req.Request.URL.Query().Get("filter"); filter != "" {
+		filterCriterion, err := query.ParseFilter(filter, transactionFilterFields)
+		if err != nil {
+			web.WriteError(req.Request.Context(), rw, &web.HTTPError{
+				StatusCode:  http.StatusBadRequest,
+				Description: fmt.Sprintf("invalid filter: %s", err),
+			})
+			return
+		}
+		criterion = criteria.And(criterion, filterCriterion)
+	}
+
+	result, next, err := c.paymentService.ListPage(req.Request.Context(), page, criterion)
 	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusBadRequest,
 			Description: err.Error(),
 		})
 		return
 	}
+	if next != "" {
+		rw.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL(req.Request.URL, next)))
+	}
 	web.WriteJSON(rw, http.StatusCreated, result)
 }
 
+// get returns the transaction with the transactions it depends on and the ones depending on it.
+// Transactions of other merchants are not found.
+func (c *PaymentController) get(rw http.ResponseWriter, req *web.Request) {
+	criterion := query.CriterionFromContext(req.Request.Context(), model.TransactionObjectType)
+	result, err := c.paymentService.Chain(req.Request.Context(), mux.Vars(req.Request)["uuid"], criterion)
+	if err != nil {
+		writeTransactionError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+// history returns the status changes of the transaction, oldest first
+func (c *PaymentController) history(rw http.ResponseWriter, req *web.Request) {
+	criterion := query.CriterionFromContext(req.Request.Context(), model.TransactionObjectType)
+	result, err := c.paymentService.History(req.Request.Context(), mux.Vars(req.Request)["uuid"], criterion)
+	if err != nil {
+		writeTransactionError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func writeTransactionError(ctx context.Context, rw http.ResponseWriter, err error) {
+	if err == storage.ErrNotFound {
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusNotFound,
+			Description: "transaction not found",
+		})
+		return
+	}
+	web.WriteError(ctx, rw, err)
+}
+
 func (c *PaymentController) view(rw http.ResponseWriter, req *web.Request) {
 	fp := path.Join("templates", "payments.html")
 	tmpl, err := template.ParseFiles(fp)
 	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "could not load view",
 		})
 		return
 	}
-	q := query.QueryFromContext(req.Request.Context())
-	result, err := c.paymentService.List(q)
+	result, err := c.paymentService.List(req.Request.Context(), query.CriterionFromContext(req.Request.Context(), model.TransactionObjectType))
 	if err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusBadRequest,
 			Description: err.Error(),
 		})
@@ -78,7 +301,7 @@ func (c *PaymentController) view(rw http.ResponseWriter, req *web.Request) {
 	}
 
 	if err := tmpl.Execute(rw, result); err != nil {
-		web.WriteError(rw, &web.HTTPError{
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "could not load view",
 		})
@@ -96,6 +319,8 @@ func (c *PaymentController) Routes() []web.Route {
 				Method: http.MethodPost,
 				Path:   "/payment",
 			},
+			Auth:           true,
+			MerchantScoped: true,
 			Scopes: func() []string {
 				return []string{"transaction.write"}
 			},
@@ -106,20 +331,49 @@ func (c *PaymentController) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/payment",
 			},
+			Auth:           true,
+			MerchantScoped: true,
 			Scopes: func() []string {
 				return []string{"transaction.read"}
 			},
 			Handler: c.list,
 		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/payment/{uuid}/history",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"transaction.read"}
+			},
+			Handler: c.history,
+		},
 		{
 			Endpoint: web.Endpoint{
 				Method: http.MethodGet,
 				Path:   "/payment/view",
 			},
-			Handler: c.view,
+			Auth:           true,
+			MerchantScoped: true,
+			Handler:        c.view,
+			Scopes: func() []string {
+				return []string{"transaction.read"}
+			},
+		},
+		{
+			// Registered after the view, so that it does not match /payment/view
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/payment/{uuid}",
+			},
+			Auth:           true,
+			MerchantScoped: true,
 			Scopes: func() []string {
 				return []string{"transaction.read"}
 			},
+			Handler: c.get,
 		},
 	}
 }
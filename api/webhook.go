@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/web"
+)
+
+type WebhookService interface {
+	CreateEndpoint(ctx context.Context, merchantID string, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error)
+	ListEndpoints(ctx context.Context, merchantID string) ([]model.Object, error)
+	DeleteEndpoint(ctx context.Context, merchantID, endpointID string) error
+	ListEvents(ctx context.Context, merchantID string, status model.WebhookEventState) ([]model.Object, error)
+	Redeliver(ctx context.Context, merchantID, eventID string) (*model.WebhookEvent, error)
+}
+
+type WebhookController struct {
+	webhookService WebhookService
+}
+
+func NewWebhookController(webhookService WebhookService) web.Controller {
+	return &WebhookController{
+		webhookService: webhookService,
+	}
+}
+
+func (c *WebhookController) createEndpoint(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeWebhookError)
+	if !ok {
+		return
+	}
+	endpoint := req.Model.(*model.WebhookEndpoint)
+	result, err := c.webhookService.CreateEndpoint(req.Request.Context(), merchantID, endpoint)
+	if err != nil {
+		writeWebhookError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusCreated, result)
+}
+
+func (c *WebhookController) listEndpoints(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeWebhookError)
+	if !ok {
+		return
+	}
+	result, err := c.webhookService.ListEndpoints(req.Request.Context(), merchantID)
+	if err != nil {
+		writeWebhookError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *WebhookController) deleteEndpoint(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeWebhookError)
+	if !ok {
+		return
+	}
+	if err := c.webhookService.DeleteEndpoint(req.Request.Context(), merchantID, mux.Vars(req.Request)["endpoint"]); err != nil {
+		writeWebhookError(req.Request.Context(), rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// listEvents lists the failed events of the merchant, or the events in the status given with the status query parameter
+func (c *WebhookController) listEvents(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeWebhookError)
+	if !ok {
+		return
+	}
+	status := model.WebhookEventState(req.Request.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = model.WebhookEventFailed
+	case model.WebhookEventPending, model.WebhookEventDelivered, model.WebhookEventFailed:
+	default:
+		web.WriteError(req.Request.Context(), rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: "unknown webhook event status " + string(status),
+		})
+		return
+	}
+
+	result, err := c.webhookService.ListEvents(req.Request.Context(), merchantID, status)
+	if err != nil {
+		writeWebhookError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusOK, result)
+}
+
+func (c *WebhookController) redeliver(rw http.ResponseWriter, req *web.Request) {
+	merchantID, ok := ownMerchantID(rw, req, writeWebhookError)
+	if !ok {
+		return
+	}
+	result, err := c.webhookService.Redeliver(req.Request.Context(), merchantID, mux.Vars(req.Request)["event"])
+	if err != nil {
+		writeWebhookError(req.Request.Context(), rw, err)
+		return
+	}
+	web.WriteJSON(rw, http.StatusAccepted, result)
+}
+
+func writeWebhookError(ctx context.Context, rw http.ResponseWriter, err error) {
+	switch err {
+	case storage.ErrNotFound:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusNotFound,
+			Description: err.Error(),
+		})
+	case services.ErrWebhookEventNotFailed:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusConflict,
+			Description: err.Error(),
+		})
+	default:
+		web.WriteError(ctx, rw, &web.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: err.Error(),
+		})
+	}
+}
+
+func (c *WebhookController) Routes() []web.Route {
+	return []web.Route{
+		{
+			ModelBlueprint: func() model.Object {
+				return &model.WebhookEndpoint{}
+			},
+			Endpoint: web.Endpoint{
+				Method: http.MethodPost,
+				Path:   "/merchant/{uuid}/webhook",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"webhook.write"}
+			},
+			Handler: c.createEndpoint,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant/{uuid}/webhook",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"webhook.read"}
+			},
+			Handler: c.listEndpoints,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodDelete,
+				Path:   "/merchant/{uuid}/webhook/{endpoint}",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"webhook.write"}
+			},
+			Handler: c.deleteEndpoint,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/merchant/{uuid}/webhook_event",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"webhook.read"}
+			},
+			Handler: c.listEvents,
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodPost,
+				Path:   "/merchant/{uuid}/webhook_event/{event}/redeliver",
+			},
+			Auth:           true,
+			MerchantScoped: true,
+			Scopes: func() []string {
+				return []string{"webhook.write"}
+			},
+			Handler: c.redeliver,
+		},
+	}
+}
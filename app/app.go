@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +11,9 @@ import (
 	"time"
 
 	"github.com/pankrator/payment/auth"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/metrics"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/uaa"
 	"github.com/pankrator/payment/users"
@@ -23,9 +25,12 @@ import (
 	"github.com/spf13/afero"
 
 	"github.com/pankrator/payment/api"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/services"
 	"github.com/pankrator/payment/storage"
 	"github.com/pankrator/payment/storage/gormdb"
+	"github.com/pankrator/payment/storage/memory"
+	"github.com/pankrator/payment/tracing"
 	"github.com/pankrator/payment/web"
 )
 
@@ -35,8 +40,13 @@ type App struct {
 	UaaClient  *uaa.UAAClient
 	Settings   *config.Settings
 
-	transactionCleaner *services.TransactionClenaer
-	MerchantService    api.MerchantService
+	retentionService     *services.RetentionService
+	webhookDispatcher    *services.WebhookDispatcher
+	authorizationExpirer *services.AuthorizationExpirer
+	ledgerService        *services.LedgerService
+	healthRegistry       *health.Registry
+	tracing              *tracing.Tracing
+	MerchantService      api.MerchantService
 }
 
 func New(configFileLocation string) *App {
@@ -50,57 +60,111 @@ func New(configFileLocation string) *App {
 		panic(err)
 	}
 	settings := config.Load(cfg)
+	if err := log.Configure(settings.Log); err != nil {
+		panic(fmt.Errorf("could not configure logging: %s", err))
+	}
 
-	uaaClient, err := uaa.NewClient(&uaa.UAAConfig{
-		Auth: &oauth.Config{
-			ClientID:          settings.Auth.AdminClientID,
-			ClientSecret:      settings.Auth.AdminClientSecret,
-			SkipSSLValidation: false,
-			Timeout:           time.Second * 10,
-		},
-		URL: settings.Auth.OauthServerURL,
-	})
-	if err != nil {
-		panic(fmt.Errorf("could not build uaa client: %s", err))
+	// Without an oauth server the users are not created in it and the tokens are verified only by the other authenticators
+	var uaaClient *uaa.UAAClient
+	if settings.Auth.OauthServerURL != "" {
+		uaaClient, err = uaa.NewClient(&uaa.UAAConfig{
+			Auth: &oauth.Config{
+				ClientID:          settings.Auth.AdminClientID,
+				ClientSecret:      settings.Auth.AdminClientSecret,
+				SkipSSLValidation: false,
+				Timeout:           time.Second * 10,
+			},
+			URL: settings.Auth.OauthServerURL,
+		})
+		if err != nil {
+			panic(fmt.Errorf("could not build uaa client: %s", err))
+		}
 	}
 
-	authenticator, err := auth.NewTokenAuthenticator(ctx, settings.Auth)
+	authenticators, err := auth.NewChain(ctx, settings.Auth)
 	if err != nil {
-		panic(fmt.Errorf("could not build authenticator: %s", err))
+		panic(fmt.Errorf("could not build authenticators: %s", err))
 	}
-	authFilter := filter.NewAuthFilter(authenticator)
+	appTracing, err := tracing.New(ctx, settings.Tracing)
+	if err != nil {
+		panic(fmt.Errorf("could not configure tracing: %s", err))
+	}
+	appMetrics := metrics.New(settings.Metrics)
+	repository := tracing.NewStorage(metrics.NewStorage(newRepository(settings.Storage), appMetrics))
+	apiKeyService := services.NewAPIKeyService(repository)
+	authFilter := filter.NewAuthFilter(append(auth.Chain{auth.NewAPIKeyAuthenticator(apiKeyService)}, authenticators...))
 
-	repository := gormdb.New(settings.Storage)
-	paymentService := services.NewPaymentService(repository)
+	paymentService := services.NewPaymentService(repository, settings.Ledger, settings.Authorizations, appMetrics)
 	merchantService := services.NewMerchantService(repository)
+	ledgerService := services.NewLedgerService(repository)
+	idempotencyService := services.NewIdempotencyService(settings.Idempotency, repository)
+	webhookService := services.NewWebhookService(repository)
+
+	retentionService := services.NewRetentionService(settings.Retention, repository, appMetrics)
+	webhookDispatcher := services.NewWebhookDispatcher(settings.Webhooks, repository, appMetrics)
+	authorizationExpirer := services.NewAuthorizationExpirer(settings.Authorizations, paymentService, appMetrics)
 
-	transactionCleaner := services.NewTransactionCleaner(settings.Cleaner, repository)
+	healthRegistry := health.NewRegistry(settings.Health)
+	healthRegistry.RegisterReadiness("storage", health.CheckerFunc(func(ctx context.Context) error {
+		return repository.Ping(ctx)
+	}))
+	healthRegistry.RegisterReadiness("auth", authenticators)
+	healthRegistry.RegisterLiveness("retention", retentionService.Heartbeat())
+	healthRegistry.RegisterLiveness("webhook_dispatcher", webhookDispatcher.Heartbeat())
+	healthRegistry.RegisterLiveness("authorization_expirer", authorizationExpirer.Heartbeat())
 
 	api := &web.Api{
 		Controllers: []web.Controller{
-			api.NewPaymentController(paymentService),
+			api.NewPaymentController(paymentService, idempotencyService),
 			api.NewLoginController(settings.Auth),
 			api.NewPagesController(paymentService, merchantService),
+			api.NewMerchantController(merchantService),
+			api.NewWebhookController(webhookService),
+			api.NewLedgerController(ledgerService),
+			api.NewAPIKeyController(apiKeyService),
+			api.NewMetricsController(settings.Metrics.Path, appMetrics.Handler()),
+			api.NewHealthController(healthRegistry),
 		},
-		Filters: []web.Filter{
-			authFilter,
-			filter.NewQueryFilter(repository),
-		},
+		AuthFilter:     authFilter,
+		MerchantFilter: filter.NewQueryFilter(repository),
+		Wrappers:       []web.RouteWrapper{appMetrics.WrapRoute, tracing.WrapRoute},
 	}
 
 	staticDir := "/templates/resources"
-	server := web.NewServer(settings.Server, api)
+	server, err := web.NewServer(settings.Server, api)
+	if err != nil {
+		panic(fmt.Errorf("could not build server: %s", err))
+	}
 	server.Router.
 		PathPrefix(staticDir).
 		Handler(http.StripPrefix(staticDir, http.FileServer(http.Dir("."+staticDir))))
+	server.OnShutdown(func() {
+		healthRegistry.SetReady(false)
+	})
 
 	return &App{
-		Server:             server,
-		Repository:         repository,
-		UaaClient:          uaaClient,
-		Settings:           settings,
-		MerchantService:    merchantService,
-		transactionCleaner: transactionCleaner,
+		Server:               server,
+		Repository:           repository,
+		UaaClient:            uaaClient,
+		Settings:             settings,
+		MerchantService:      merchantService,
+		retentionService:     retentionService,
+		webhookDispatcher:    webhookDispatcher,
+		authorizationExpirer: authorizationExpirer,
+		ledgerService:        ledgerService,
+		healthRegistry:       healthRegistry,
+		tracing:              appTracing,
+	}
+}
+
+func newRepository(settings *storage.Settings) storage.Storage {
+	switch settings.Driver {
+	case storage.PostgresDriver, storage.SQLiteDriver:
+		return gormdb.New(settings)
+	case storage.MemoryDriver:
+		return memory.New()
+	default:
+		panic(fmt.Errorf("unknown storage driver %s", settings.Driver))
 	}
 }
 
@@ -116,17 +180,17 @@ func (a *App) initUsers(ctx context.Context, usersData []users.User, groupNames
 
 	for _, user := range usersData {
 		if user.Type == users.Merchant {
-			count, err := a.Repository.Count(model.MerchantType, "email = ?", user.Email)
+			count, err := a.Repository.Count(ctx, model.MerchantType, criteria.Eq("email", user.Email))
 			if err != nil {
 				panic(err)
 			}
 			if count < 1 {
-				_, err = a.MerchantService.Create(model.MerchantFromUser(user))
+				_, err = a.MerchantService.Create(ctx, model.MerchantFromUser(user))
 				if err != nil {
 					panic(fmt.Errorf("could not create merchant: %s", err))
 				}
 			} else {
-				log.Println("Merchant already created")
+				log.C(ctx).Infof("Merchant already created")
 			}
 		}
 
@@ -139,7 +203,7 @@ func (a *App) initUsers(ctx context.Context, usersData []users.User, groupNames
 				if added, err := a.UaaClient.AddUserToGroup(ctx, userID, group); err != nil {
 					panic(fmt.Errorf("could not add user to group: %s", err))
 				} else if added {
-					log.Printf("User %s added to group %s", user.Name, group.DisplayName)
+					log.C(ctx).Infof("User %s added to group %s", user.Name, group.DisplayName)
 				}
 			}
 		}
@@ -154,12 +218,15 @@ func (a *App) Start(ctx context.Context, wg *sync.WaitGroup, cancel context.Canc
 	}); err != nil {
 		panic(err)
 	}
+	if err := a.ledgerService.PostOpeningBalances(ctx); err != nil {
+		panic(err)
+	}
 
 	userInitiator := NewUserInitiator(a.UaaClient, a.Repository, a.MerchantService)
 	usersByType := userInitiator.LoadUsers(a.Settings.Users)
 
-	adminGroups := []string{"merchant.read", "merchant.write", "merchant.delete", "transaction.write", "transaction.read"}
-	merchantGroups := []string{"transaction.read"}
+	adminGroups := []string{"merchant.read", "merchant.write", "merchant.delete", "transaction.write", "transaction.read", "api_key.read", "api_key.write", "webhook.read", "webhook.write"}
+	merchantGroups := []string{"transaction.read", "api_key.read", "api_key.write", "webhook.read", "webhook.write"}
 
 	userInitiator.InitUsers(
 		ctx,
@@ -172,16 +239,26 @@ func (a *App) Start(ctx context.Context, wg *sync.WaitGroup, cancel context.Canc
 		merchantGroups,
 	)
 
-	a.transactionCleaner.Start(ctx)
+	a.retentionService.Start(ctx)
+	a.webhookDispatcher.Start(ctx)
+	a.authorizationExpirer.Start(ctx)
+	a.healthRegistry.SetReady(true)
 	a.Server.Run(ctx, wg)
 }
 
+// Close exports the spans which are not exported yet. It should be called after the server has stopped.
+func (a *App) Close(ctx context.Context) {
+	if err := a.tracing.Shutdown(ctx); err != nil {
+		log.C(ctx).Errorf("could not export the remaining spans: %s", err)
+	}
+}
+
 func handleInterrupts(ctx context.Context, cancel context.CancelFunc) {
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, os.Interrupt)
 	select {
 	case <-s:
-		log.Printf("Received interrupt signal")
+		log.C(ctx).Infof("Received interrupt signal")
 		cancel()
 	case <-ctx.Done():
 		return
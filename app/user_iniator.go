@@ -3,9 +3,10 @@ package app
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/pankrator/payment/api"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/storage"
 	"github.com/pankrator/payment/uaa"
@@ -38,9 +39,13 @@ func (ui *UserIniator) LoadUsers(settings *users.Settings) map[users.UserType][]
 	return splitUsersByType(reader)
 }
 
+// InitUsers creates the merchants of the users and the users with their groups in the oauth server, when there is one
 func (ui *UserIniator) InitUsers(ctx context.Context, usersData []users.User, groupNames []string) {
 	groups := make([]*uaa.Group, 0)
 	for _, groupName := range groupNames {
+		if ui.UaaClient == nil {
+			continue
+		}
 		g, err := ui.UaaClient.GetGroup(ctx, groupName)
 		if err != nil {
 			panic(fmt.Errorf("could not get uaa groups: %s", err))
@@ -50,19 +55,22 @@ func (ui *UserIniator) InitUsers(ctx context.Context, usersData []users.User, gr
 
 	for _, user := range usersData {
 		if user.Type == users.Merchant {
-			count, err := ui.Repository.Count(model.MerchantType, "email = ?", user.Email)
+			count, err := ui.Repository.Count(ctx, model.MerchantType, criteria.Eq("email", user.Email))
 			if err != nil {
 				panic(err)
 			}
 			if count < 1 {
-				_, err = ui.MerchantService.Create(model.MerchantFromUser(user))
+				_, err = ui.MerchantService.Create(ctx, model.MerchantFromUser(user))
 				if err != nil {
 					panic(fmt.Errorf("could not create merchant: %s", err))
 				}
 			} else {
-				log.Println("Merchant already created")
+				log.C(ctx).Infof("Merchant already created")
 			}
 		}
+		if ui.UaaClient == nil {
+			continue
+		}
 
 		userID, err := ui.UaaClient.CreateUser(ctx, user.Name, user.Email, user.Password)
 		if err != nil {
@@ -73,7 +81,7 @@ func (ui *UserIniator) InitUsers(ctx context.Context, usersData []users.User, gr
 				if added, err := ui.UaaClient.AddUserToGroup(ctx, userID, group); err != nil {
 					panic(fmt.Errorf("could not add user to group: %s", err))
 				} else if added {
-					log.Printf("User %s added to group %s", user.Name, group.DisplayName)
+					log.C(ctx).Infof("User %s added to group %s", user.Name, group.DisplayName)
 				}
 			}
 		}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/web"
+)
+
+// APIKeyHeader carries an API key, as an alternative to sending it as the user name of basic authentication
+const APIKeyHeader = "X-API-Key"
+
+type APIKeyVerifier interface {
+	Verify(ctx context.Context, key string) (*model.APIKey, *model.Merchant, error)
+}
+
+// APIKeyAuthenticator authenticates merchants with their API keys. The user of a key has the email of its merchant
+// and the scopes of the key.
+type APIKeyAuthenticator struct {
+	verifier APIKeyVerifier
+}
+
+func NewAPIKeyAuthenticator(verifier APIKeyVerifier) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		verifier: verifier,
+	}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(req *http.Request) (*web.UserData, error) {
+	text := apiKeyOf(req)
+	if text == "" {
+		return nil, NoTokenProvidedErr
+	}
+	key, merchant, err := a.verifier.Verify(req.Context(), text)
+	if err != nil {
+		log.C(req.Context()).Infof("Could not verify api key: %s", err)
+		return nil, VerificationFailedErr
+	}
+	return &web.UserData{
+		Name:   key.Prefix,
+		Email:  merchant.Email,
+		Scopes: key.Scopes,
+	}, nil
+}
+
+func apiKeyOf(req *http.Request) string {
+	if key := req.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if key, _, ok := req.BasicAuth(); ok {
+		return key
+	}
+	return ""
+}
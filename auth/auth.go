@@ -2,81 +2,167 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
 
 	"github.com/coreos/go-oidc"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/tracing"
 	"github.com/pankrator/payment/web"
 )
 
 var NoTokenProvidedErr = errors.New("no token provided")
 var VerificationFailedErr = errors.New("could not verify token")
 
+// The strategies which can be listed in the authenticators setting
+const (
+	// OIDCStrategy verifies tokens of the oauth server and the other OIDC providers with the keys they publish
+	OIDCStrategy = "oidc"
+	// JWKSStrategy verifies tokens with the keys of a JWKS file
+	JWKSStrategy = "jwks"
+	// HMACStrategy verifies tokens signed with a shared secret
+	HMACStrategy = "hmac"
+	// CertificateStrategy authenticates the subjects of verified TLS client certificates
+	CertificateStrategy = "certificate"
+)
+
 type Settings struct {
 	OauthServerURL    string `mapstructure:"oauth_server_url"`
 	AdminClientID     string `mapstructure:"admin_client_id"`
 	AdminClientSecret string `mapstructure:"admin_client_secret"`
 	ClientID          string `mapstructure:"client_id"`
 	ClientSecret      string `mapstructure:"client_secret"`
+
+	// Authenticators are the strategies tried in order for every request
+	Authenticators []string `mapstructure:"authenticators"`
+	// OIDCProviders are verified together with the oauth server, e.g. Keycloak or Dex
+	OIDCProviders []*OIDCProviderSettings `mapstructure:"oidc_providers"`
+	JWKSFile      string                  `mapstructure:"jwks_file"`
+	HMACSecret    string                  `mapstructure:"hmac_secret"`
+	// TokenIssuer and TokenAudience are checked in the tokens verified with the JWKS file or the HMAC secret,
+	// when they are set
+	TokenIssuer   string `mapstructure:"token_issuer"`
+	TokenAudience string `mapstructure:"token_audience"`
+	// Certificates are the users of the TLS client certificates
+	Certificates []*CertificateSettings `mapstructure:"certificates"`
+}
+
+type OIDCProviderSettings struct {
+	IssuerURL string `mapstructure:"issuer_url"`
+	ClientID  string `mapstructure:"client_id"`
 }
 
 func (s *Settings) Keys() []string {
 	return []string{
 		"oauth_server_url", "client_id", "client_secret",
 		"admin_client_id", "admin_client_secret",
+		"authenticators", "jwks_file", "hmac_secret",
+		"token_issuer", "token_audience",
 	}
 }
 
 func DefaultSettings() *Settings {
-	return &Settings{}
+	return &Settings{
+		Authenticators: []string{OIDCStrategy},
+	}
+}
+
+// Authenticator returns the user of the request. It returns NoTokenProvidedErr when the request has no credentials
+// which it can verify, so that the next authenticator of a chain is tried.
+type Authenticator interface {
+	Authenticate(req *http.Request) (*web.UserData, error)
 }
 
+// TokenAuthenticator authenticates requests with JWT bearer tokens
 type TokenAuthenticator struct {
-	settings *Settings
 	verifier *oidc.IDTokenVerifier
+	// providerURL and keysURL are where the keys are discovered, when they are not static
+	providerURL string
+	keysURL     string
 }
 
-func NewTokenAuthenticator(ctx context.Context, settings *Settings) (*TokenAuthenticator, error) {
-	info, err := GetInfo(settings.OauthServerURL)
+// NewOIDCAuthenticator discovers the keys of the provider, which it verifies the tokens with
+func NewOIDCAuthenticator(ctx context.Context, provider *OIDCProviderSettings) (*TokenAuthenticator, error) {
+	info, err := GetInfo(provider.IssuerURL)
 	if err != nil {
 		return nil, fmt.Errorf("could not get oauth server info: %s", err)
 	}
 
-	keySet := oidc.NewRemoteKeySet(ctx, info.JWKsURI)
+	keySet := oidc.NewRemoteKeySet(oidc.ClientContext(ctx, httpClient), info.JWKsURI)
 	return &TokenAuthenticator{
 		verifier: oidc.NewVerifier(info.IssuerURL, keySet, &oidc.Config{
-			ClientID: settings.ClientID,
+			ClientID: provider.ClientID,
 		}),
-		settings: settings,
+		providerURL: provider.IssuerURL,
+		keysURL:     info.JWKsURI,
 	}, nil
 }
 
+// Check checks that the discovery document and the keys of the provider are reachable.
+// Authenticators with static keys have nothing to check.
+func (ta *TokenAuthenticator) Check(ctx context.Context) error {
+	if ta.providerURL == "" {
+		return nil
+	}
+	for _, url := range []string{ta.providerURL + "/.well-known/openid-configuration", ta.keysURL} {
+		if err := checkReachable(ctx, url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ta *TokenAuthenticator) Authenticate(req *http.Request) (*web.UserData, error) {
 	ctx := req.Context()
 	authHeader := req.Header.Get("Authorization")
-	if len(authHeader) < 7 {
+	if !strings.HasPrefix(authHeader, "Bearer ") {
 		return nil, NoTokenProvidedErr
 	}
 	tokenText := authHeader[len("Bearer "):]
 
-	token, err := ta.verifier.Verify(ctx, tokenText)
+	verifyCtx, span := tracing.Start(ctx, "TokenAuthenticator.Verify")
+	token, err := ta.verifier.Verify(verifyCtx, tokenText)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("could not verify token: %s", err)
+		log.C(ctx).Infof("Could not verify token: %s", err)
 		return nil, VerificationFailedErr
 	}
 	claims := &struct {
-		Email    string   `json:"email"`
-		Scopes   []string `json:"scope"`
-		UserName string   `json:"user_name"`
+		Email             string     `json:"email"`
+		Scopes            scopeClaim `json:"scope"`
+		UserName          string     `json:"user_name"`
+		PreferredUsername string     `json:"preferred_username"`
 	}{}
 	if err := token.Claims(claims); err != nil {
 		return nil, err
 	}
+	name := claims.UserName
+	if name == "" {
+		name = claims.PreferredUsername
+	}
 	return &web.UserData{
 		Email:  claims.Email,
 		Scopes: claims.Scopes,
-		Name:   claims.UserName,
+		Name:   name,
 	}, nil
 }
+
+// scopeClaim is a list of scopes, which providers send either as an array or as a string separated with spaces
+type scopeClaim []string
+
+func (c *scopeClaim) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = strings.Fields(text)
+		return nil
+	}
+	var scopes []string
+	if err := json.Unmarshal(data, &scopes); err != nil {
+		return err
+	}
+	*c = scopes
+	return nil
+}
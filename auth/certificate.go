@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/web"
+)
+
+// CertificateSettings describes the user of the TLS client certificates with the subject,
+// e.g. CN=merchant,O=Company
+type CertificateSettings struct {
+	Subject string   `mapstructure:"subject"`
+	Email   string   `mapstructure:"email"`
+	Scopes  []string `mapstructure:"scopes"`
+}
+
+// CertificateAuthenticator authenticates the subjects of the client certificates which the server verified
+type CertificateAuthenticator struct {
+	users map[string]*CertificateSettings
+}
+
+func NewCertificateAuthenticator(settings *Settings) *CertificateAuthenticator {
+	users := make(map[string]*CertificateSettings)
+	for _, certificate := range settings.Certificates {
+		users[certificate.Subject] = certificate
+	}
+	return &CertificateAuthenticator{
+		users: users,
+	}
+}
+
+func (ca *CertificateAuthenticator) Authenticate(req *http.Request) (*web.UserData, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, NoTokenProvidedErr
+	}
+	subject := req.TLS.VerifiedChains[0][0].Subject.String()
+	user, found := ca.users[subject]
+	if !found {
+		log.C(req.Context()).Infof("Could not find user of certificate subject %s", subject)
+		return nil, VerificationFailedErr
+	}
+	return &web.UserData{
+		Name:   subject,
+		Email:  user.Email,
+		Scopes: user.Scopes,
+	}, nil
+}
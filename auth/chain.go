@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pankrator/payment/web"
+)
+
+// Chain tries the authenticators in order until one of them authenticates the request
+type Chain []Authenticator
+
+// NewChain builds the authenticators listed in the settings
+func NewChain(ctx context.Context, settings *Settings) (Chain, error) {
+	chain := make(Chain, 0, len(settings.Authenticators))
+	for _, strategy := range settings.Authenticators {
+		switch strategy {
+		case OIDCStrategy:
+			providers := settings.OIDCProviders
+			if settings.OauthServerURL != "" {
+				providers = append([]*OIDCProviderSettings{{
+					IssuerURL: settings.OauthServerURL,
+					ClientID:  settings.ClientID,
+				}}, providers...)
+			}
+			for _, provider := range providers {
+				authenticator, err := NewOIDCAuthenticator(ctx, provider)
+				if err != nil {
+					return nil, fmt.Errorf("could not build authenticator of %s: %s", provider.IssuerURL, err)
+				}
+				chain = append(chain, authenticator)
+			}
+		case JWKSStrategy:
+			authenticator, err := NewJWKSAuthenticator(settings)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, authenticator)
+		case HMACStrategy:
+			authenticator, err := NewHMACAuthenticator(settings)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, authenticator)
+		case CertificateStrategy:
+			chain = append(chain, NewCertificateAuthenticator(settings))
+		default:
+			return nil, fmt.Errorf("unknown authenticator %s", strategy)
+		}
+	}
+	return chain, nil
+}
+
+// Check checks the authenticators which depend on other services, e.g. the oauth server
+func (c Chain) Check(ctx context.Context) error {
+	for _, authenticator := range c {
+		checker, ok := authenticator.(interface {
+			Check(ctx context.Context) error
+		})
+		if !ok {
+			continue
+		}
+		if err := checker.Check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the user of the first authenticator which verifies the request. When the request has
+// credentials, but none of the authenticators verifies them, the verification failure is returned.
+func (c Chain) Authenticate(req *http.Request) (*web.UserData, error) {
+	result := NoTokenProvidedErr
+	for _, authenticator := range c {
+		user, err := authenticator.Authenticate(req)
+		if err == nil {
+			return user, nil
+		}
+		if result == NoTokenProvidedErr {
+			result = err
+		}
+	}
+	return nil, result
+}
@@ -0,0 +1,108 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/auth"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var _ = Describe("Authenticator chain", func() {
+	var settings *auth.Settings
+	var chain auth.Chain
+
+	sign := func(secret string, claims map[string]interface{}) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(secret)}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		payload, err := json.Marshal(claims)
+		Expect(err).ShouldNot(HaveOccurred())
+		object, err := signer.Sign(payload)
+		Expect(err).ShouldNot(HaveOccurred())
+		token, err := object.CompactSerialize()
+		Expect(err).ShouldNot(HaveOccurred())
+		return token
+	}
+
+	request := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/payment", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	BeforeEach(func() {
+		settings = auth.DefaultSettings()
+		settings.Authenticators = []string{auth.CertificateStrategy, auth.HMACStrategy}
+		settings.HMACSecret = "secret"
+		settings.TokenIssuer = "staging"
+		settings.Certificates = []*auth.CertificateSettings{{
+			Subject: "CN=merchant",
+			Email:   "merchant@mail.com",
+			Scopes:  []string{"transaction.read"},
+		}}
+
+		var err error
+		chain, err = auth.NewChain(context.Background(), settings)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(chain).To(HaveLen(2))
+	})
+
+	It("should authenticate tokens signed with the shared secret", func() {
+		user, err := chain.Authenticate(request(sign("secret", map[string]interface{}{
+			"iss":                "staging",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"email":              "admin@mail.com",
+			"preferred_username": "admin",
+			"scope":              "transaction.read transaction.write",
+		})))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(user.Name).To(Equal("admin"))
+		Expect(user.Email).To(Equal("admin@mail.com"))
+		Expect(user.Scopes).To(ConsistOf("transaction.read", "transaction.write"))
+	})
+
+	It("should reject tokens of other issuers or secrets", func() {
+		claims := map[string]interface{}{
+			"iss": "production",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		_, err := chain.Authenticate(request(sign("secret", claims)))
+		Expect(err).To(Equal(auth.VerificationFailedErr))
+
+		claims["iss"] = "staging"
+		_, err = chain.Authenticate(request(sign("other", claims)))
+		Expect(err).To(Equal(auth.VerificationFailedErr))
+	})
+
+	It("should authenticate the subjects of verified client certificates", func() {
+		req := request("")
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "merchant"}}}},
+		}
+		user, err := chain.Authenticate(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(user.Email).To(Equal("merchant@mail.com"))
+		Expect(user.Scopes).To(ConsistOf("transaction.read"))
+	})
+
+	It("should report requests without credentials", func() {
+		_, err := chain.Authenticate(request(""))
+		Expect(err).To(Equal(auth.NoTokenProvidedErr))
+	})
+
+	It("should fail on unknown strategies", func() {
+		settings.Authenticators = []string{"ldap"}
+		_, err := auth.NewChain(context.Background(), settings)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -65,7 +65,7 @@ func New(config *Config) *OAuthClient {
 		TokenURL:     config.TokenEndpoint,
 	}
 
-	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, http.DefaultClient)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
 	authClient := clientCredentialsConfig.Client(ctx)
 
 	if config.Flow == ClientCredentialsFlow {
@@ -1,20 +1,41 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
+	"github.com/pankrator/payment/tracing"
 	"github.com/pankrator/payment/web"
 )
 
+// httpClient traces the requests to the oauth servers and sends the trace context with them
+var httpClient = &http.Client{Transport: tracing.Transport(http.DefaultTransport)}
+
 type AuthInfo struct {
 	IssuerURL     string `json:"issuer"`
 	TokenEndpoint string `json:"token_endpoint"`
 	JWKsURI       string `json:"jwks_uri"`
 }
 
+func checkReachable(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status returned from %s: %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
 func GetInfo(oauthUrl string) (*AuthInfo, error) {
-	resp, err := http.DefaultClient.Get(oauthUrl + "/.well-known/openid-configuration")
+	resp, err := httpClient.Get(oauthUrl + "/.well-known/openid-configuration")
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/coreos/go-oidc"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var errSignatureVerification = errors.New("failed to verify token signature")
+
+// NewJWKSAuthenticator verifies tokens with the keys of the JWKS file, e.g. one exported from the identity provider
+func NewJWKSAuthenticator(settings *Settings) (*TokenAuthenticator, error) {
+	data, err := ioutil.ReadFile(settings.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read jwks file: %s", err)
+	}
+	keySet := &staticKeySet{}
+	if err := json.Unmarshal(data, &keySet.keys); err != nil {
+		return nil, fmt.Errorf("could not parse jwks file: %s", err)
+	}
+	return newStaticTokenAuthenticator(settings, keySet, []string{
+		oidc.RS256, oidc.RS384, oidc.RS512,
+		oidc.ES256, oidc.ES384, oidc.ES512,
+		oidc.PS256, oidc.PS384, oidc.PS512,
+	}), nil
+}
+
+// NewHMACAuthenticator verifies tokens signed with the shared secret
+func NewHMACAuthenticator(settings *Settings) (*TokenAuthenticator, error) {
+	if settings.HMACSecret == "" {
+		return nil, errors.New("hmac secret is required")
+	}
+	keySet := &hmacKeySet{
+		secret: []byte(settings.HMACSecret),
+	}
+	return newStaticTokenAuthenticator(settings, keySet, []string{
+		string(jose.HS256), string(jose.HS384), string(jose.HS512),
+	}), nil
+}
+
+func newStaticTokenAuthenticator(settings *Settings, keySet oidc.KeySet, algorithms []string) *TokenAuthenticator {
+	return &TokenAuthenticator{
+		verifier: oidc.NewVerifier(settings.TokenIssuer, keySet, &oidc.Config{
+			ClientID:             settings.TokenAudience,
+			SkipClientIDCheck:    settings.TokenAudience == "",
+			SkipIssuerCheck:      settings.TokenIssuer == "",
+			SupportedSigningAlgs: algorithms,
+		}),
+	}
+}
+
+// staticKeySet verifies signatures with a fixed set of public keys
+type staticKeySet struct {
+	keys jose.JSONWebKeySet
+}
+
+func (s *staticKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %s", err)
+	}
+	keyID := ""
+	if len(jws.Signatures) > 0 {
+		keyID = jws.Signatures[0].Header.KeyID
+	}
+	for _, key := range s.keys.Keys {
+		if keyID != "" && key.KeyID != keyID {
+			continue
+		}
+		if payload, err := jws.Verify(key); err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errSignatureVerification
+}
+
+// hmacKeySet verifies signatures with a shared secret
+type hmacKeySet struct {
+	secret []byte
+}
+
+func (s *hmacKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %s", err)
+	}
+	payload, err := jws.Verify(s.secret)
+	if err != nil {
+		return nil, errSignatureVerification
+	}
+	return payload, nil
+}
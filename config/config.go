@@ -7,7 +7,11 @@ import (
 	"github.com/pankrator/payment/services"
 
 	"github.com/pankrator/payment/auth"
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/metrics"
 	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
 	"github.com/pankrator/payment/users"
 	"github.com/pankrator/payment/web"
 	"github.com/spf13/afero"
@@ -15,11 +19,19 @@ import (
 )
 
 type Settings struct {
-	Storage *storage.Settings         `mapstructure:"storage"`
-	Server  *web.Settings             `mapstructure:"server"`
-	Auth    *auth.Settings            `mapstructure:"auth"`
-	Users   *users.Settings           `mapstructure:"users"`
-	Cleaner *services.CleanerSettings `mapstructure:"cleaner"`
+	Storage        *storage.Settings               `mapstructure:"storage"`
+	Server         *web.Settings                   `mapstructure:"server"`
+	Auth           *auth.Settings                  `mapstructure:"auth"`
+	Users          *users.Settings                 `mapstructure:"users"`
+	Retention      *services.RetentionSettings     `mapstructure:"retention"`
+	Webhooks       *services.WebhookSettings       `mapstructure:"webhooks"`
+	Ledger         *services.LedgerSettings        `mapstructure:"ledger"`
+	Authorizations *services.AuthorizationSettings `mapstructure:"authorizations"`
+	Idempotency    *services.IdempotencySettings   `mapstructure:"idempotency"`
+	Metrics        *metrics.Settings               `mapstructure:"metrics"`
+	Health         *health.Settings                `mapstructure:"health"`
+	Log            *log.Settings                   `mapstructure:"log"`
+	Tracing        *tracing.Settings               `mapstructure:"tracing"`
 }
 
 type KeyableSetting interface {
@@ -44,8 +56,33 @@ func (s *Settings) Keys() []string {
 		keys = append(keys, "users."+k)
 	}
 
-	for _, k := range s.Cleaner.Keys() {
-		keys = append(keys, "cleaner."+k)
+	for _, k := range s.Retention.Keys() {
+		keys = append(keys, "retention."+k)
+	}
+
+	for _, k := range s.Webhooks.Keys() {
+		keys = append(keys, "webhooks."+k)
+	}
+	for _, k := range s.Ledger.Keys() {
+		keys = append(keys, "ledger."+k)
+	}
+	for _, k := range s.Authorizations.Keys() {
+		keys = append(keys, "authorizations."+k)
+	}
+	for _, k := range s.Idempotency.Keys() {
+		keys = append(keys, "idempotency."+k)
+	}
+	for _, k := range s.Metrics.Keys() {
+		keys = append(keys, "metrics."+k)
+	}
+	for _, k := range s.Health.Keys() {
+		keys = append(keys, "health."+k)
+	}
+	for _, k := range s.Log.Keys() {
+		keys = append(keys, "log."+k)
+	}
+	for _, k := range s.Tracing.Keys() {
+		keys = append(keys, "tracing."+k)
 	}
 
 	return keys
@@ -53,9 +90,19 @@ func (s *Settings) Keys() []string {
 
 func Load(config *Config) *Settings {
 	settings := &Settings{
-		Storage: storage.DefaultSettings(),
-		Server:  web.DefaultSettings(),
-		Auth:    auth.DefaultSettings(),
+		Storage:   storage.DefaultSettings(),
+		Server:    web.DefaultSettings(),
+		Auth:      auth.DefaultSettings(),
+		Webhooks:  services.DefaultWebhookSettings(),
+		Ledger:    services.DefaultLedgerSettings(),
+		Retention: services.DefaultRetentionSettings(),
+
+		Authorizations: services.DefaultAuthorizationSettings(),
+		Idempotency:    services.DefaultIdempotencySettings(),
+		Metrics:        metrics.DefaultSettings(),
+		Health:         health.DefaultSettings(),
+		Log:            log.DefaultSettings(),
+		Tracing:        tracing.DefaultSettings(),
 	}
 
 	if err := config.Unmarshal(settings); err != nil {
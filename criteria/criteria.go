@@ -0,0 +1,186 @@
+// Package criteria describes conditions on stored objects without binding them to a storage.
+// The fields in the conditions are the fields of the model objects in snake case, e.g. merchant_id or depends_on_uuid.
+package criteria
+
+import "fmt"
+
+// Operator compares a field with the values of a comparison
+type Operator string
+
+// Operators supported in comparisons
+const (
+	Equal          Operator = "="
+	NotEqual       Operator = "!="
+	GreaterThan    Operator = ">"
+	GreaterOrEqual Operator = ">="
+	LessThan       Operator = "<"
+	LessOrEqual    Operator = "<="
+	Like           Operator = "like"
+	In             Operator = "in"
+	Between        Operator = "between"
+	IsNull         Operator = "is null"
+	IsNotNull      Operator = "is not null"
+)
+
+// Criterion is a condition which objects either match or not.
+// It is a Comparison, a Group of criteria or a Negation of a criterion.
+type Criterion interface {
+	// Validate checks that the criterion is well formed
+	Validate() error
+}
+
+// Comparison compares a field of the objects with typed values.
+// The values are strings, booleans, numbers, times or types based on them.
+type Comparison struct {
+	Field    string
+	Operator Operator
+	Values   []interface{}
+}
+
+func (c *Comparison) Validate() error {
+	if c.Field == "" {
+		return fmt.Errorf("field is required for %s", c.Operator)
+	}
+	expected := 1
+	switch c.Operator {
+	case Equal, NotEqual, GreaterThan, GreaterOrEqual, LessThan, LessOrEqual, Like:
+	case In:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("no values given for %s in", c.Field)
+		}
+		expected = len(c.Values)
+	case Between:
+		expected = 2
+	case IsNull, IsNotNull:
+		expected = 0
+	default:
+		return fmt.Errorf("unknown operator %s", c.Operator)
+	}
+	if len(c.Values) != expected {
+		return fmt.Errorf("%d values should be given for %s %s", expected, c.Field, c.Operator)
+	}
+	for _, v := range c.Values {
+		if _, ok := kindOf(v); !ok {
+			return fmt.Errorf("unsupported value %v of type %T for %s", v, v, c.Field)
+		}
+	}
+	return nil
+}
+
+// Conjunction joins the criteria of a group
+type Conjunction string
+
+const (
+	AndConjunction Conjunction = "and"
+	OrConjunction  Conjunction = "or"
+)
+
+// Group joins criteria with and or or. An empty group matches all objects.
+type Group struct {
+	Conjunction Conjunction
+	Criteria    []Criterion
+}
+
+func (g *Group) Validate() error {
+	if g.Conjunction != AndConjunction && g.Conjunction != OrConjunction {
+		return fmt.Errorf("unknown conjunction %s", g.Conjunction)
+	}
+	for _, c := range g.Criteria {
+		if c == nil {
+			return fmt.Errorf("nil criterion in %s group", g.Conjunction)
+		}
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Negation matches the objects which do not match the criterion
+type Negation struct {
+	Criterion Criterion
+}
+
+func (n *Negation) Validate() error {
+	if n.Criterion == nil {
+		return fmt.Errorf("nil criterion in negation")
+	}
+	return n.Criterion.Validate()
+}
+
+func compare(field string, operator Operator, values ...interface{}) *Comparison {
+	return &Comparison{
+		Field:    field,
+		Operator: operator,
+		Values:   values,
+	}
+}
+
+func Eq(field string, value interface{}) *Comparison { return compare(field, Equal, value) }
+
+func NotEq(field string, value interface{}) *Comparison { return compare(field, NotEqual, value) }
+
+func Gt(field string, value interface{}) *Comparison { return compare(field, GreaterThan, value) }
+
+func Ge(field string, value interface{}) *Comparison { return compare(field, GreaterOrEqual, value) }
+
+func Lt(field string, value interface{}) *Comparison { return compare(field, LessThan, value) }
+
+func Le(field string, value interface{}) *Comparison { return compare(field, LessOrEqual, value) }
+
+// LikePattern is a like comparison with a pattern in which % matches any characters and _ a single one
+func LikePattern(field string, pattern string) *Comparison { return compare(field, Like, pattern) }
+
+// OneOf is an in comparison
+func OneOf(field string, values ...interface{}) *Comparison { return compare(field, In, values...) }
+
+// InRange is a between comparison, which includes both ends of the range
+func InRange(field string, from, to interface{}) *Comparison {
+	return compare(field, Between, from, to)
+}
+
+func Null(field string) *Comparison { return compare(field, IsNull) }
+
+func NotNull(field string) *Comparison { return compare(field, IsNotNull) }
+
+// And matches the objects which match all criteria. Nil criteria are skipped.
+func And(criteria ...Criterion) *Group { return group(AndConjunction, criteria) }
+
+// Or matches the objects which match any of the criteria. Nil criteria are skipped.
+func Or(criteria ...Criterion) *Group { return group(OrConjunction, criteria) }
+
+func Not(criterion Criterion) *Negation { return &Negation{Criterion: criterion} }
+
+func group(conjunction Conjunction, criteria []Criterion) *Group {
+	result := &Group{
+		Conjunction: conjunction,
+		Criteria:    make([]Criterion, 0, len(criteria)),
+	}
+	for _, c := range criteria {
+		if c != nil {
+			result.Criteria = append(result.Criteria, c)
+		}
+	}
+	return result
+}
+
+// EqualValue returns the value which the field should be equal to in order to match the criterion,
+// when the criterion is such a comparison or a group of criteria joined with and which contains one
+func EqualValue(c Criterion, field string) (interface{}, bool) {
+	switch c := c.(type) {
+	case *Comparison:
+		if c.Field == field && c.Operator == Equal && len(c.Values) == 1 {
+			return c.Values[0], true
+		}
+	case *Group:
+		if c.Conjunction != AndConjunction {
+			return nil, false
+		}
+		for _, member := range c.Criteria {
+			if value, ok := EqualValue(member, field); ok {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
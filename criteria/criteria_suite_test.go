@@ -0,0 +1,13 @@
+package criteria_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCriteria(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Criteria Suite")
+}
@@ -0,0 +1,270 @@
+package criteria
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pankrator/payment/model"
+)
+
+type kind int
+
+const (
+	stringKind kind = iota
+	boolKind
+	numberKind
+	timeKind
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func kindOf(value interface{}) (kind, bool) {
+	if value == nil {
+		return 0, false
+	}
+	v := reflect.ValueOf(value)
+	if v.Type() == timeType {
+		return timeKind, true
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return stringKind, true
+	case reflect.Bool:
+		return boolKind, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return numberKind, true
+	}
+	return 0, false
+}
+
+// Filter returns the objects which match the criterion. A nil criterion matches all objects.
+func Filter(objects []model.Object, c Criterion) ([]model.Object, error) {
+	result := make([]model.Object, 0, len(objects))
+	for _, object := range objects {
+		matched, err := Match(object, c)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			result = append(result, object)
+		}
+	}
+	return result, nil
+}
+
+// Match evaluates the criterion against the fields of the object. A nil criterion matches all objects.
+// Zero values of the fields are treated as null.
+func Match(object model.Object, c Criterion) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	if err := c.Validate(); err != nil {
+		return false, err
+	}
+	return match(reflect.Indirect(reflect.ValueOf(object)), c)
+}
+
+func match(object reflect.Value, c Criterion) (bool, error) {
+	switch c := c.(type) {
+	case *Comparison:
+		return matchComparison(object, c)
+	case *Group:
+		for _, member := range c.Criteria {
+			matched, err := match(object, member)
+			if err != nil {
+				return false, err
+			}
+			if matched && c.Conjunction == OrConjunction {
+				return true, nil
+			}
+			if !matched && c.Conjunction == AndConjunction {
+				return false, nil
+			}
+		}
+		return c.Conjunction == AndConjunction, nil
+	case *Negation:
+		matched, err := match(object, c.Criterion)
+		return !matched, err
+	}
+	return false, fmt.Errorf("unknown criterion %T", c)
+}
+
+func matchComparison(object reflect.Value, c *Comparison) (bool, error) {
+	field, ok := FieldByName(object.Type(), c.Field)
+	if !ok {
+		return false, fmt.Errorf("%s has no field %s", object.Type().Name(), c.Field)
+	}
+	value := object.FieldByIndex(field.Index)
+
+	switch c.Operator {
+	case IsNull:
+		return value.IsZero(), nil
+	case IsNotNull:
+		return !value.IsZero(), nil
+	case In:
+		for _, v := range c.Values {
+			result, err := compareValues(value, v)
+			if err != nil {
+				return false, err
+			}
+			if result == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case Between:
+		from, err := compareValues(value, c.Values[0])
+		if err != nil {
+			return false, err
+		}
+		to, err := compareValues(value, c.Values[1])
+		if err != nil {
+			return false, err
+		}
+		return from >= 0 && to <= 0, nil
+	case Like:
+		pattern, ok := c.Values[0].(string)
+		if !ok || value.Kind() != reflect.String {
+			return false, fmt.Errorf("like is supported only for text fields and patterns")
+		}
+		return likeToRegexp(pattern).MatchString(value.String()), nil
+	}
+
+	result, err := compareValues(value, c.Values[0])
+	if err != nil {
+		return false, err
+	}
+	switch c.Operator {
+	case Equal:
+		return result == 0, nil
+	case NotEqual:
+		return result != 0, nil
+	case GreaterThan:
+		return result > 0, nil
+	case GreaterOrEqual:
+		return result >= 0, nil
+	case LessThan:
+		return result < 0, nil
+	default:
+		return result <= 0, nil
+	}
+}
+
+// Compare compares two values of the same kind, e.g. the values of a field in two objects.
+// It returns a negative number when a is less than b, zero when they are equal and a positive number otherwise.
+func Compare(a, b interface{}) (int, error) {
+	if _, ok := kindOf(a); !ok {
+		return 0, fmt.Errorf("cannot compare %T", a)
+	}
+	return compareValues(reflect.ValueOf(a), b)
+}
+
+// compareValues returns a negative number when the field value is less than the criterion value,
+// zero when they are equal and a positive number otherwise
+func compareValues(fieldValue reflect.Value, criterionValue interface{}) (int, error) {
+	fieldKind, ok := kindOf(fieldValue.Interface())
+	criterionKind, _ := kindOf(criterionValue)
+	if !ok || fieldKind != criterionKind {
+		return 0, fmt.Errorf("cannot compare %s with %v", fieldValue.Type(), criterionValue)
+	}
+
+	v := reflect.ValueOf(criterionValue)
+	switch fieldKind {
+	case stringKind:
+		return strings.Compare(fieldValue.String(), v.String()), nil
+	case boolKind:
+		a, b := fieldValue.Bool(), v.Bool()
+		switch {
+		case a == b:
+			return 0, nil
+		case b:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	case timeKind:
+		a, b := fieldValue.Interface().(time.Time), criterionValue.(time.Time)
+		switch {
+		case a.Equal(b):
+			return 0, nil
+		case a.Before(b):
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	default:
+		a, b := toFloat(fieldValue), toFloat(v)
+		switch {
+		case a == b:
+			return 0, nil
+		case a < b:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+func likeToRegexp(pattern string) *regexp.Regexp {
+	result := strings.Builder{}
+	result.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			result.WriteString(".*")
+		case '_':
+			result.WriteString(".")
+		default:
+			result.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	result.WriteString("$")
+	return regexp.MustCompile(result.String())
+}
+
+// FieldByName finds the field of a struct type which is named in criteria with the given name
+func FieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath == "" && FieldName(field.Name) == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// FieldName converts the name of a Go field to its name in criteria, e.g. DependsOnUUID to depends_on_uuid
+func FieldName(name string) string {
+	runes := []rune(name)
+	result := strings.Builder{}
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			previousLower := i > 0 && !unicode.IsUpper(runes[i-1])
+			// A word may follow an abbreviation, e.g. Server in HTTPServer
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || nextLower {
+				result.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
@@ -0,0 +1,109 @@
+package criteria_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+)
+
+var _ = Describe("Match", func() {
+	var transaction *model.Transaction
+
+	BeforeEach(func() {
+		transaction = &model.Transaction{
+			UUID:          "uuid",
+			Type:          model.Charge,
+			Amount:        100,
+			Currency:      model.EUR,
+			CustomerEmail: "user@mail.com",
+			FinalCapture:  true,
+			DependsOnUUID: "parent-uuid",
+			MerchantID:    "1",
+			CreatedAt:     time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC),
+		}
+	})
+
+	It("should evaluate the criteria against the fields", func() {
+		cases := []struct {
+			name     string
+			c        criteria.Criterion
+			expected bool
+		}{
+			{"equal", criteria.Eq("merchant_id", "1"), true},
+			{"equal typed value", criteria.Eq("type", model.Charge), true},
+			{"not equal", criteria.NotEq("currency", "EUR"), false},
+			{"greater than", criteria.Gt("amount", 99), true},
+			{"less or equal", criteria.Le("amount", int64(99)), false},
+			{"bool", criteria.Eq("final_capture", true), true},
+			{"time", criteria.Lt("created_at", time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)), true},
+			{"like", criteria.LikePattern("customer_email", "%@mail.com"), true},
+			{"like single character", criteria.LikePattern("customer_email", "_@mail.com"), false},
+			{"in", criteria.OneOf("type", "refund", "charge"), true},
+			{"between", criteria.InRange("amount", 100, 200), true},
+			{"is null", criteria.Null("depends_on_uuid"), false},
+			{"is not null", criteria.NotNull("depends_on_uuid"), true},
+			{"and", criteria.And(criteria.Eq("merchant_id", "1"), criteria.Eq("type", "refund")), false},
+			{"or", criteria.Or(criteria.Eq("merchant_id", "2"), criteria.Eq("type", "charge")), true},
+			{"not", criteria.Not(criteria.Eq("merchant_id", "1")), false},
+			{"empty and", criteria.And(), true},
+			{"empty or", criteria.Or(), false},
+			{"nil", nil, true},
+		}
+		for _, tc := range cases {
+			matched, err := criteria.Match(transaction, tc.c)
+			Expect(err).ShouldNot(HaveOccurred(), tc.name)
+			Expect(matched).To(Equal(tc.expected), tc.name)
+		}
+	})
+
+	It("should not match by unknown fields", func() {
+		_, err := criteria.Match(transaction, criteria.Eq("transaction_id", "parent-uuid"))
+		Expect(err).To(MatchError("Transaction has no field transaction_id"))
+	})
+
+	It("should not compare values of different kinds", func() {
+		_, err := criteria.Match(transaction, criteria.Eq("amount", "100"))
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should not accept malformed criteria", func() {
+		_, err := criteria.Match(transaction, &criteria.Comparison{Field: "amount", Operator: criteria.Between, Values: []interface{}{1}})
+		Expect(err).To(MatchError("2 values should be given for amount between"))
+
+		_, err = criteria.Match(transaction, criteria.Eq("amount", []int{1}))
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should filter objects", func() {
+		other := &model.Transaction{UUID: "other", MerchantID: "2"}
+		result, err := criteria.Filter([]model.Object{transaction, other}, criteria.Eq("merchant_id", "2"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).To(Equal([]model.Object{other}))
+	})
+
+	It("should name fields in snake case", func() {
+		Expect(criteria.FieldName("DependsOnUUID")).To(Equal("depends_on_uuid"))
+		Expect(criteria.FieldName("MerchantID")).To(Equal("merchant_id"))
+		Expect(criteria.FieldName("UUID")).To(Equal("uuid"))
+		Expect(criteria.FieldName("HTTPServer")).To(Equal("http_server"))
+	})
+})
+
+var _ = Describe("EqualValue", func() {
+	It("should find the value in groups joined with and", func() {
+		value, ok := criteria.EqualValue(criteria.And(criteria.Eq("type", "charge"), criteria.And(criteria.Eq("merchant_id", "1"))), "merchant_id")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("1"))
+	})
+
+	It("should not find the value in other groups", func() {
+		_, ok := criteria.EqualValue(criteria.Or(criteria.Eq("merchant_id", "1")), "merchant_id")
+		Expect(ok).To(BeFalse())
+
+		_, ok = criteria.EqualValue(nil, "merchant_id")
+		Expect(ok).To(BeFalse())
+	})
+})
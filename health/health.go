@@ -0,0 +1,177 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Statuses of the components and of the whole service
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+type Settings struct {
+	// Timeout is how long each component is checked before it is reported down
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Timeout: time.Second * 5,
+	}
+}
+
+func (s *Settings) Keys() []string {
+	return []string{
+		"timeout",
+	}
+}
+
+// Checker checks whether a component works
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a function which is a Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     string                      `json:"status"`
+	Components map[string]*ComponentStatus `json:"components"`
+}
+
+// Up tells whether all components are up
+func (r *Report) Up() bool {
+	return r.Status == StatusUp
+}
+
+// Registry keeps the checkers of the components, which the liveness and the readiness of the service depend on.
+// The service is not ready until it is marked ready and again when it is shutting down.
+type Registry struct {
+	settings *Settings
+
+	mutex     sync.RWMutex
+	liveness  map[string]Checker
+	readiness map[string]Checker
+	ready     bool
+}
+
+func NewRegistry(settings *Settings) *Registry {
+	return &Registry{
+		settings:  settings,
+		liveness:  make(map[string]Checker),
+		readiness: make(map[string]Checker),
+	}
+}
+
+// RegisterLiveness registers a component which the service should be restarted for when it is down,
+// e.g. a stuck background worker
+func (r *Registry) RegisterLiveness(name string, checker Checker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.liveness[name] = checker
+}
+
+// RegisterReadiness registers a component without which the service should not receive traffic, e.g. the database
+func (r *Registry) RegisterReadiness(name string, checker Checker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.readiness[name] = checker
+}
+
+func (r *Registry) SetReady(ready bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ready = ready
+}
+
+func (r *Registry) Liveness(ctx context.Context) *Report {
+	r.mutex.RLock()
+	checkers := copyCheckers(r.liveness)
+	r.mutex.RUnlock()
+	return r.check(ctx, checkers)
+}
+
+func (r *Registry) Readiness(ctx context.Context) *Report {
+	r.mutex.RLock()
+	checkers := copyCheckers(r.readiness)
+	ready := r.ready
+	r.mutex.RUnlock()
+
+	report := r.check(ctx, checkers)
+	if !ready {
+		report.Status = StatusDown
+	}
+	return report
+}
+
+// check runs the checkers concurrently, each with the configured timeout
+func (r *Registry) check(ctx context.Context, checkers map[string]Checker) *Report {
+	report := &Report{
+		Status:     StatusUp,
+		Components: make(map[string]*ComponentStatus),
+	}
+	names := make([]string, 0, len(checkers))
+	for name := range checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]error, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, checker Checker) {
+			defer wg.Done()
+			results[i] = r.checkWithTimeout(ctx, checker)
+		}(i, checkers[name])
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		if results[i] != nil {
+			report.Status = StatusDown
+			report.Components[name] = &ComponentStatus{Status: StatusDown, Error: results[i].Error()}
+			continue
+		}
+		report.Components[name] = &ComponentStatus{Status: StatusUp}
+	}
+	return report
+}
+
+func (r *Registry) checkWithTimeout(ctx context.Context, checker Checker) error {
+	ctx, cancel := context.WithTimeout(ctx, r.settings.Timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- checker.Check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check did not finish: %s", ctx.Err())
+	}
+}
+
+func copyCheckers(checkers map[string]Checker) map[string]Checker {
+	result := make(map[string]Checker, len(checkers))
+	for name, checker := range checkers {
+		result[name] = checker
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/health"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *health.Registry
+
+	BeforeEach(func() {
+		registry = health.NewRegistry(&health.Settings{Timeout: time.Millisecond * 50})
+		registry.RegisterReadiness("storage", health.CheckerFunc(func(ctx context.Context) error {
+			return nil
+		}))
+	})
+
+	It("should be ready only while marked ready", func() {
+		report := registry.Readiness(context.Background())
+		Expect(report.Up()).To(BeFalse())
+		Expect(report.Components["storage"].Status).To(Equal(health.StatusUp))
+
+		registry.SetReady(true)
+		Expect(registry.Readiness(context.Background()).Up()).To(BeTrue())
+
+		registry.SetReady(false)
+		Expect(registry.Readiness(context.Background()).Up()).To(BeFalse())
+	})
+
+	It("should report the failing and the slow components", func() {
+		registry.SetReady(true)
+		registry.RegisterReadiness("auth", health.CheckerFunc(func(ctx context.Context) error {
+			return errors.New("unreachable")
+		}))
+		registry.RegisterReadiness("slow", health.CheckerFunc(func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}))
+
+		report := registry.Readiness(context.Background())
+		Expect(report.Status).To(Equal(health.StatusDown))
+		Expect(report.Components["storage"].Status).To(Equal(health.StatusUp))
+		Expect(report.Components["auth"]).To(Equal(&health.ComponentStatus{Status: health.StatusDown, Error: "unreachable"}))
+		Expect(report.Components["slow"].Status).To(Equal(health.StatusDown))
+	})
+
+	It("should report stale heartbeats in the liveness", func() {
+		heartbeat := health.NewHeartbeat(time.Millisecond * 20)
+		registry.RegisterLiveness("worker", heartbeat)
+		Expect(registry.Liveness(context.Background()).Up()).To(BeFalse())
+
+		heartbeat.Beat()
+		Expect(registry.Liveness(context.Background()).Up()).To(BeTrue())
+
+		time.Sleep(time.Millisecond * 30)
+		Expect(registry.Liveness(context.Background()).Components["worker"].Status).To(Equal(health.StatusDown))
+	})
+})
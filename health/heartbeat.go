@@ -0,0 +1,41 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Heartbeat is beaten by a background worker on every iteration of its loop. It is down when the worker
+// has not beaten it for longer than the tolerance, e.g. because the worker is stuck.
+type Heartbeat struct {
+	tolerance time.Duration
+
+	mutex sync.RWMutex
+	last  time.Time
+}
+
+func NewHeartbeat(tolerance time.Duration) *Heartbeat {
+	return &Heartbeat{
+		tolerance: tolerance,
+	}
+}
+
+func (h *Heartbeat) Beat() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.last = time.Now()
+}
+
+func (h *Heartbeat) Check(ctx context.Context) error {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.last.IsZero() {
+		return fmt.Errorf("not started")
+	}
+	if since := time.Since(h.last); since > h.tolerance {
+		return fmt.Errorf("last beat %s ago", since.Round(time.Second))
+	}
+	return nil
+}
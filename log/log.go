@@ -0,0 +1,84 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	stdlog "log"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of the log entries
+const (
+	JSONFormat = "json"
+	TextFormat = "text"
+)
+
+type Settings struct {
+	// Level is the least severe level which is logged: trace, debug, info, warning, error, fatal or panic
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Level:  "info",
+		Format: JSONFormat,
+	}
+}
+
+func (s *Settings) Keys() []string {
+	return []string{
+		"level",
+		"format",
+	}
+}
+
+type loggerKey struct{}
+
+// Configure configures the logger which all loggers in the contexts derive from. The standard library logger,
+// which some dependencies use, writes through it as well.
+func Configure(settings *Settings) error {
+	level, err := logrus.ParseLevel(settings.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %s", err)
+	}
+	logger := logrus.StandardLogger()
+	logger.SetLevel(level)
+	switch settings.Format {
+	case JSONFormat:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case TextFormat:
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("unknown log format %s", settings.Format)
+	}
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(logger.WriterLevel(logrus.InfoLevel))
+	return nil
+}
+
+// C returns the logger of the context, which carries the fields of the request, e.g. its id.
+// Without one the default logger is returned.
+func C(ctx context.Context) *logrus.Entry {
+	if entry, found := ctx.Value(loggerKey{}).(*logrus.Entry); found {
+		return entry
+	}
+	return D()
+}
+
+// D returns the default logger, for the code which does not run for a request, e.g. the startup
+func D() *logrus.Entry {
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// ContextWithLogger returns a context carrying the logger
+func ContextWithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// ContextWithFields returns a context whose logger adds the fields
+func ContextWithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return ContextWithLogger(ctx, C(ctx).WithFields(fields))
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
-	"log"
 	"sync"
+	"time"
 
 	"github.com/pankrator/payment/app"
+	"github.com/pankrator/payment/log"
 )
 
 func main() {
@@ -14,5 +15,8 @@ func main() {
 	application := app.New(".")
 	application.Start(ctx, wg, cancel)
 	wg.Wait()
-	log.Printf("Everything closed. Closing the process")
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelClose()
+	application.Close(closeCtx)
+	log.D().Infof("Everything closed. Closing the process")
 }
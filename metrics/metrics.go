@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "payment"
+
+type Settings struct {
+	// Path is where the metrics are exposed in the Prometheus text format
+	Path string `mapstructure:"path"`
+	// MerchantLabel labels the transactions with the merchant id. Every merchant adds its own series.
+	MerchantLabel bool `mapstructure:"merchant_label"`
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Path: "/metrics",
+	}
+}
+
+func (s *Settings) Keys() []string {
+	return []string{
+		"path",
+		"merchant_label",
+	}
+}
+
+// Metrics collects the metrics of the http routes, the payments, the storage and the background jobs.
+// The methods of a nil Metrics do nothing, so that it is optional for the services.
+type Metrics struct {
+	settings *Settings
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	transactions    *prometheus.CounterVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+	jobRuns         *prometheus.CounterVec
+	jobDuration     *prometheus.HistogramVec
+	jobLastSuccess  *prometheus.GaugeVec
+}
+
+func New(settings *Settings) *Metrics {
+	transactionLabels := []string{"type", "status"}
+	if settings.MerchantLabel {
+		transactionLabels = append(transactionLabels, "merchant_id")
+	}
+	m := &Metrics{
+		settings: settings,
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of http requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of http requests by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transactions_total",
+			Help:      "Number of created transactions by type and resulting status.",
+		}, transactionLabels),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Duration of storage operations by operation and object type.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "type"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_errors_total",
+			Help:      "Number of failed storage operations, not counting objects which are not found.",
+		}, []string{"operation", "type"}),
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_runs_total",
+			Help:      "Number of runs of the background jobs by result.",
+		}, []string{"job", "result"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_run_duration_seconds",
+			Help:      "Duration of the runs of the background jobs.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"job"}),
+		jobLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "job_last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful run of the background jobs.",
+		}, []string{"job"}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.transactions,
+		m.storageDuration,
+		m.storageErrors,
+		m.jobRuns,
+		m.jobDuration,
+		m.jobLastSuccess,
+	)
+	return m
+}
+
+// Handler writes the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WrapRoute observes the requests of the route. The route is labeled with its path template, e.g. /payment/{uuid}.
+func (m *Metrics) WrapRoute(route web.Route, handler http.HandlerFunc) http.HandlerFunc {
+	if m == nil {
+		return handler
+	}
+	method, path := route.Endpoint.Method, route.Endpoint.Path
+	return func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		handler(recorder, req)
+		m.requests.WithLabelValues(method, path, strconv.Itoa(recorder.status)).Inc()
+		m.requestDuration.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveTransaction counts the created transaction
+func (m *Metrics) ObserveTransaction(transaction *model.Transaction) {
+	if m == nil {
+		return
+	}
+	labels := []string{string(transaction.Type), string(transaction.Status)}
+	if m.settings.MerchantLabel {
+		labels = append(labels, transaction.MerchantID)
+	}
+	m.transactions.WithLabelValues(labels...).Inc()
+}
+
+// ObserveJob records a run of the background job which started at the given time
+func (m *Metrics) ObserveJob(job string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.jobDuration.WithLabelValues(job).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.jobRuns.WithLabelValues(job, "error").Inc()
+		return
+	}
+	m.jobRuns.WithLabelValues(job, "success").Inc()
+	m.jobLastSuccess.WithLabelValues(job).SetToCurrentTime()
+}
+
+func (m *Metrics) observeStorage(operation, typee string, start time.Time, err error) {
+	m.storageDuration.WithLabelValues(operation, typee).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues(operation, typee).Inc()
+	}
+}
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
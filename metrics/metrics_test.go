@@ -0,0 +1,84 @@
+package metrics_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/metrics"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage/memory"
+	"github.com/pankrator/payment/web"
+)
+
+var _ = Describe("Metrics", func() {
+	var settings *metrics.Settings
+
+	scrape := func(m *metrics.Metrics) string {
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		body, err := ioutil.ReadAll(recorder.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(body)
+	}
+
+	BeforeEach(func() {
+		settings = metrics.DefaultSettings()
+	})
+
+	It("should count the transactions and time the storage operations", func() {
+		m := metrics.New(settings)
+		repository := metrics.NewStorage(memory.New(), m)
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		paymentService := services.NewPaymentService(repository, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), m)
+		_, err = paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Authorize,
+			Currency:      model.EUR,
+			Amount:        10,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    object.(*model.Merchant).UUID,
+		}, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		body := scrape(m)
+		Expect(body).To(ContainSubstring(`payment_transactions_total{status="approved",type="authorize"} 1`))
+		Expect(body).To(ContainSubstring(`payment_storage_operation_duration_seconds_count{operation="get_for_update",type="Merchant"} 1`))
+		Expect(body).To(ContainSubstring(`payment_storage_operation_duration_seconds_count{operation="transaction",type=""} 1`))
+		Expect(body).NotTo(ContainSubstring("merchant_id"))
+	})
+
+	It("should label the transactions with the merchant when enabled", func() {
+		settings.MerchantLabel = true
+		m := metrics.New(settings)
+		m.ObserveTransaction(&model.Transaction{Type: model.Charge, Status: model.Captured, MerchantID: "merchant"})
+		Expect(scrape(m)).To(ContainSubstring(`payment_transactions_total{merchant_id="merchant",status="captured",type="charge"} 1`))
+	})
+
+	It("should observe the routes by their path template", func() {
+		m := metrics.New(settings)
+		route := web.Route{Endpoint: web.Endpoint{Method: http.MethodGet, Path: "/payment/{uuid}"}}
+		handler := m.WrapRoute(route, func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusNotFound)
+		})
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/payment/1", nil))
+		Expect(scrape(m)).To(ContainSubstring(`payment_http_requests_total{code="404",method="GET",route="/payment/{uuid}"} 1`))
+	})
+
+	It("should do nothing without metrics", func() {
+		var m *metrics.Metrics
+		m.ObserveTransaction(&model.Transaction{})
+		repository := memory.New()
+		Expect(metrics.NewStorage(repository, m)).To(BeIdenticalTo(repository))
+	})
+})
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/storage"
+)
+
+// Storage times the operations of the decorated storage. Opening and closing it are not timed.
+type Storage struct {
+	storage.Storage
+	metrics *Metrics
+}
+
+// NewStorage decorates the repository. Without metrics the repository is returned as it is.
+func NewStorage(repository storage.Storage, metrics *Metrics) storage.Storage {
+	if metrics == nil {
+		return repository
+	}
+	return &Storage{
+		Storage: repository,
+		metrics: metrics,
+	}
+}
+
+func (s *Storage) Create(ctx context.Context, object model.Object) (model.Object, error) {
+	start := time.Now()
+	result, err := s.Storage.Create(ctx, object)
+	s.observe("create", object.GetType(), start, err)
+	return result, err
+}
+
+func (s *Storage) Save(ctx context.Context, object model.Object) error {
+	start := time.Now()
+	err := s.Storage.Save(ctx, object)
+	s.observe("save", object.GetType(), start, err)
+	return err
+}
+
+func (s *Storage) DeleteAll(ctx context.Context, typee string) error {
+	start := time.Now()
+	err := s.Storage.DeleteAll(ctx, typee)
+	s.observe("delete_all", typee, start, err)
+	return err
+}
+
+func (s *Storage) Delete(ctx context.Context, typee string, c criteria.Criterion) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, typee, c)
+	s.observe("delete", typee, start, err)
+	return err
+}
+
+func (s *Storage) Get(ctx context.Context, typee string, id string) (model.Object, error) {
+	start := time.Now()
+	result, err := s.Storage.Get(ctx, typee, id)
+	s.observe("get", typee, start, err)
+	return result, err
+}
+
+func (s *Storage) GetForUpdate(ctx context.Context, typee string, id string) (model.Object, error) {
+	start := time.Now()
+	result, err := s.Storage.GetForUpdate(ctx, typee, id)
+	s.observe("get_for_update", typee, start, err)
+	return result, err
+}
+
+func (s *Storage) GetBy(ctx context.Context, typee string, c criteria.Criterion) (model.Object, error) {
+	start := time.Now()
+	result, err := s.Storage.GetBy(ctx, typee, c)
+	s.observe("get_by", typee, start, err)
+	return result, err
+}
+
+func (s *Storage) List(ctx context.Context, typee string, c criteria.Criterion) ([]model.Object, error) {
+	start := time.Now()
+	result, err := s.Storage.List(ctx, typee, c)
+	s.observe("list", typee, start, err)
+	return result, err
+}
+
+func (s *Storage) ListPage(ctx context.Context, typee string, page query.Page, c criteria.Criterion) ([]model.Object, string, error) {
+	start := time.Now()
+	result, next, err := s.Storage.ListPage(ctx, typee, page, c)
+	s.observe("list_page", typee, start, err)
+	return result, next, err
+}
+
+func (s *Storage) Count(ctx context.Context, typee string, c criteria.Criterion) (int, error) {
+	start := time.Now()
+	result, err := s.Storage.Count(ctx, typee, c)
+	s.observe("count", typee, start, err)
+	return result, err
+}
+
+// Transaction times the whole transaction and the operations in it
+func (s *Storage) Transaction(ctx context.Context, f func(s storage.Storage) error) error {
+	start := time.Now()
+	err := s.Storage.Transaction(ctx, func(tx storage.Storage) error {
+		return f(NewStorage(tx, s.metrics))
+	})
+	s.observe("transaction", "", start, err)
+	return err
+}
+
+func (s *Storage) observe(operation, typee string, start time.Time, err error) {
+	if err == storage.ErrNotFound {
+		err = nil
+	}
+	s.metrics.observeStorage(operation, typee, start, err)
+}
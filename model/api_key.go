@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const APIKeyType string = "APIKey"
+
+// APIKeyMode tells whether a key is used for live payments or for testing an integration
+type APIKeyMode string
+
+const (
+	LiveAPIKey APIKeyMode = "live"
+	TestAPIKey APIKeyMode = "test"
+)
+
+// APIKeyScopes are the scopes which can be given to API keys
+var APIKeyScopes = []string{"transaction.read", "transaction.write"}
+
+// APIKey authenticates the requests of a merchant instead of a bearer token.
+// The keys start with pay_live_ or pay_test_ followed by their identifying prefix.
+type APIKey struct {
+	UUID       string     `json:"uuid"`
+	MerchantID string     `json:"merchant_id"`
+	Name       string     `json:"name"`
+	Mode       APIKeyMode `json:"mode"`
+	// Prefix is the beginning of the key, which identifies it
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// Key is shown only when the key is created. Only its hash is stored.
+	Key       string    `json:"key,omitempty"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// RevokedAt is zero for active keys
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func (k *APIKey) GetType() string {
+	return APIKeyType
+}
+
+func (k *APIKey) Validate() error {
+	if k.Name == "" {
+		return errors.New("api key name is required")
+	}
+	if k.Mode != LiveAPIKey && k.Mode != TestAPIKey {
+		return fmt.Errorf("api key mode should be %s or %s", LiveAPIKey, TestAPIKey)
+	}
+	if len(k.Scopes) == 0 {
+		return errors.New("api key scopes are required")
+	}
+	for _, scope := range k.Scopes {
+		allowed := false
+		for _, s := range APIKeyScopes {
+			if s == scope {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("scope %s cannot be given to api keys", scope)
+		}
+	}
+	if k.Key != "" || k.Prefix != "" {
+		return errors.New("key should not be provided")
+	}
+	return nil
+}
+
+// Revoked tells whether the key can no longer be used
+func (k *APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
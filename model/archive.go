@@ -0,0 +1,35 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const ArchivedTransactionType string = "ArchivedTransaction"
+
+// ArchivedTransaction keeps a transaction and its status history after it is removed from the transactions.
+// Transactions are archived together with the whole chain of their root authorization.
+type ArchivedTransaction struct {
+	// UUID is the uuid of the archived transaction
+	UUID string `json:"uuid"`
+	// RootUUID is the uuid of the authorization which the chain of the transaction starts with
+	RootUUID    string                     `json:"root_uuid"`
+	MerchantID  string                     `json:"merchant_id"`
+	Transaction *Transaction               `json:"transaction"`
+	History     []*TransactionStatusChange `json:"history"`
+	ArchivedAt  time.Time                  `json:"archived_at"`
+}
+
+func (a *ArchivedTransaction) GetType() string {
+	return ArchivedTransactionType
+}
+
+func (a *ArchivedTransaction) Validate() error {
+	if a.Transaction == nil || a.Transaction.UUID != a.UUID {
+		return fmt.Errorf("transaction %s is required for archived transaction", a.UUID)
+	}
+	if a.RootUUID == "" {
+		return fmt.Errorf("root uuid is required for archived transaction")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package model
+
+// TransactionChain is a transaction together with the transactions it depends on and the ones depending on it
+type TransactionChain struct {
+	Transaction *Transaction `json:"transaction"`
+	// Ancestors start with the authorization of the chain and end with the parent of the transaction
+	Ancestors []*Transaction `json:"ancestors"`
+	// Descendants are ordered depth first, each followed by the transactions depending on it
+	Descendants []*Transaction `json:"descendants"`
+}
+
+// GroupChains groups the transactions in chains starting with an authorization, in the order of the authorizations.
+// Every chain is ordered depth first.
+func GroupChains(transactions []Object) [][]*Transaction {
+	children := childrenOf(transactions)
+	result := make([][]*Transaction, 0)
+	for _, t := range transactions {
+		tt := t.(*Transaction)
+		if tt.Type == Authorize {
+			result = append(result, appendWithDescendants(make([]*Transaction, 0), tt, children))
+		}
+	}
+	return result
+}
+
+// OrderDescendants orders the descendants of the transaction depth first
+func OrderDescendants(transaction *Transaction, descendants []Object) []*Transaction {
+	return appendWithDescendants(make([]*Transaction, 0), transaction, childrenOf(descendants))[1:]
+}
+
+func childrenOf(transactions []Object) map[string][]*Transaction {
+	children := make(map[string][]*Transaction)
+	for _, t := range transactions {
+		tt := t.(*Transaction)
+		if tt.DependsOnUUID != "" {
+			children[tt.DependsOnUUID] = append(children[tt.DependsOnUUID], tt)
+		}
+	}
+	return children
+}
+
+// appendWithDescendants appends the transaction followed by all transactions depending on it, depth first
+func appendWithDescendants(chain []*Transaction, transaction *Transaction, children map[string][]*Transaction) []*Transaction {
+	chain = append(chain, transaction)
+	for _, child := range children[transaction.UUID] {
+		chain = appendWithDescendants(chain, child, children)
+	}
+	return chain
+}
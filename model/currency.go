@@ -0,0 +1,69 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Currency is an ISO 4217 alphabetic currency code
+type Currency string
+
+const (
+	EUR Currency = "EUR"
+	USD Currency = "USD"
+	BGN Currency = "BGN"
+)
+
+// minorUnits holds the number of digits after the decimal separator of each supported currency.
+// Amounts are always kept as integers in the minor unit of their currency.
+var minorUnits = map[Currency]int{
+	EUR: 2,
+	USD: 2,
+	BGN: 2,
+}
+
+func (c Currency) Validate() error {
+	if c == "" {
+		return fmt.Errorf("currency is required")
+	}
+	if _, ok := minorUnits[c]; !ok {
+		return fmt.Errorf("currency %s is not supported", c)
+	}
+	return nil
+}
+
+// MinorUnits returns the number of decimal digits of the currency
+func (c Currency) MinorUnits() int {
+	return minorUnits[c]
+}
+
+// FormatAmount formats an amount given in minor units, e.g. 1050 EUR as 10.50 EUR
+func (c Currency) FormatAmount(amount int64) string {
+	digits := c.MinorUnits()
+	if digits == 0 {
+		return fmt.Sprintf("%d %s", amount, c)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	divisor := int64(1)
+	for i := 0; i < digits; i++ {
+		divisor *= 10
+	}
+	fraction := fmt.Sprintf("%d", amount%divisor)
+	return fmt.Sprintf("%s%d.%s%s %s", sign, amount/divisor, strings.Repeat("0", digits-len(fraction)), fraction, c)
+}
+
+// Balance keeps a sum of amounts per currency
+type Balance map[Currency]int64
+
+// Add adds the amount to the sum kept for the currency
+func (b *Balance) Add(currency Currency, amount int64) {
+	if *b == nil {
+		*b = Balance{}
+	}
+	(*b)[currency] += amount
+}
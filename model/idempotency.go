@@ -0,0 +1,43 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+const IdempotencyKeyType string = "IdempotencyKey"
+
+// IdempotencyKey keeps the response to the first request sent by a merchant with a given key,
+// so that retries of the same request can be answered without processing it again
+type IdempotencyKey struct {
+	UUID        string
+	Key         string
+	MerchantID  string
+	RequestHash string
+	// StatusCode is zero while the first request is still being processed
+	StatusCode int
+	Body       []byte
+	CreatedAt  time.Time
+}
+
+func (k *IdempotencyKey) GetType() string {
+	return IdempotencyKeyType
+}
+
+func (k *IdempotencyKey) Validate() error {
+	if k.Key == "" {
+		return errors.New("idempotency key is required")
+	}
+	if len(k.Key) > 255 {
+		return errors.New("idempotency key should not be longer than 255 characters")
+	}
+	if k.MerchantID == "" {
+		return errors.New("merchant id is required for idempotency key")
+	}
+	return nil
+}
+
+// Completed reports whether the response to the first request is already stored
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const LedgerEntryType string = "LedgerEntry"
+
+// Account is a ledger account which the entries of every merchant are posted to
+type Account string
+
+const (
+	// MerchantAvailableAccount holds the charged amounts which belong to the merchant
+	MerchantAvailableAccount Account = "merchant_available"
+	// MerchantPendingAccount holds the authorized amounts which are not charged yet
+	MerchantPendingAccount Account = "merchant_pending"
+	// CustomerFundsAccount is where the authorized amounts come from and where the released and refunded ones go
+	CustomerFundsAccount Account = "customer_funds"
+	// FeesAccount holds the fees taken from the charges
+	FeesAccount Account = "fees"
+	// OpeningBalanceAccount is where the total transaction sums of the merchants created before the ledger come from
+	OpeningBalanceAccount Account = "opening_balance"
+)
+
+// Accounts lists the ledger accounts in the order in which they are shown in statements
+var Accounts = []Account{
+	MerchantAvailableAccount,
+	MerchantPendingAccount,
+	CustomerFundsAccount,
+	FeesAccount,
+	OpeningBalanceAccount,
+}
+
+type EntryDirection string
+
+const (
+	Debit  EntryDirection = "debit"
+	Credit EntryDirection = "credit"
+)
+
+// LedgerEntry is a debit or a credit of an account. Entries are never changed once posted.
+// The entries of a posting share its id and their debits and credits are equal in every currency.
+type LedgerEntry struct {
+	UUID          string         `json:"uuid"`
+	PostingID     string         `json:"posting_id"`
+	TransactionID string         `json:"transaction_id"`
+	MerchantID    string         `json:"merchant_id"`
+	Account       Account        `json:"account"`
+	Direction     EntryDirection `json:"direction"`
+	Amount        int64          `json:"amount"`
+	Currency      Currency       `json:"currency"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+func (e *LedgerEntry) GetType() string {
+	return LedgerEntryType
+}
+
+func (e *LedgerEntry) Validate() error {
+	if e.PostingID == "" {
+		return errors.New("posting id is required for ledger entry")
+	}
+	if e.MerchantID == "" {
+		return errors.New("merchant id is required for ledger entry")
+	}
+	known := false
+	for _, account := range Accounts {
+		known = known || e.Account == account
+	}
+	if !known {
+		return fmt.Errorf("unknown ledger account %s", e.Account)
+	}
+	if e.Direction != Debit && e.Direction != Credit {
+		return fmt.Errorf("unknown entry direction %s", e.Direction)
+	}
+	if e.Amount < 1 {
+		return errors.New("ledger entry amount should be greater than 0")
+	}
+	return e.Currency.Validate()
+}
+
+// Change returns the amount by which the entry changes the balance of its account.
+// Credits increase the balances and debits decrease them.
+func (e *LedgerEntry) Change() int64 {
+	if e.Direction == Debit {
+		return -e.Amount
+	}
+	return e.Amount
+}
+
+// AccountStatement sums the entries of an account in a currency for the period of a statement
+type AccountStatement struct {
+	Account  Account  `json:"account"`
+	Currency Currency `json:"currency"`
+	Opening  int64    `json:"opening_balance"`
+	Debits   int64    `json:"debits"`
+	Credits  int64    `json:"credits"`
+	Closing  int64    `json:"closing_balance"`
+}
+
+// Statement lists the ledger entries of a merchant posted from the start of the period until its end,
+// together with the balances of the accounts before and after them
+type Statement struct {
+	MerchantID string              `json:"merchant_id"`
+	From       time.Time           `json:"from"`
+	To         time.Time           `json:"to"`
+	Accounts   []*AccountStatement `json:"accounts"`
+	Entries    []*LedgerEntry      `json:"entries"`
+}
+
+// BalanceVerification compares the total transaction sum of a merchant with the balance derived from its ledger
+type BalanceVerification struct {
+	MerchantID          string  `json:"merchant_id"`
+	TotalTransactionSum Balance `json:"total_transaction_sum"`
+	LedgerBalance       Balance `json:"ledger_balance"`
+	Verified            bool    `json:"verified"`
+}
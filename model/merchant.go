@@ -1,16 +1,28 @@
 package model
 
-import "github.com/pankrator/payment/users"
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"github.com/pankrator/payment/users"
+)
 
 const MerchantType string = "Merchant"
 
 type Merchant struct {
-	UUID                string `json:"uuid"`
-	Name                string `json:"name"`
-	Description         string `json:"description"`
-	Email               string `json:"email"`
-	Status              bool   `json:"status"`
-	TotalTransactionSum int64  `json:"total_transaction_sum"`
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Email       string `json:"email"`
+	Status      bool   `json:"status"`
+	// TotalTransactionSum is the balance of the available ledger account of the merchant, i.e. the charged
+	// amounts net of the fees and the refunds. The sums of merchants created before the ledger were not net of
+	// the fees taken until then.
+	TotalTransactionSum Balance `json:"total_transaction_sum"`
+	// AuthorizationValidity is how long the authorizations of the merchant can be charged.
+	// The default validity is used when it is zero.
+	AuthorizationValidity Duration `json:"authorization_validity"`
 }
 
 func (m *Merchant) GetType() string {
@@ -18,6 +30,18 @@ func (m *Merchant) GetType() string {
 }
 
 func (m *Merchant) Validate() error {
+	if m.Name == "" {
+		return errors.New("merchant name is required")
+	}
+	if _, err := mail.ParseAddress(m.Email); err != nil {
+		return fmt.Errorf("merchant email is invalid: %s", err)
+	}
+	if m.AuthorizationValidity < 0 {
+		return errors.New("authorization validity should not be negative")
+	}
+	if len(m.TotalTransactionSum) > 0 {
+		return errors.New("total transaction sum should not be provided")
+	}
 	return nil
 }
 
@@ -27,6 +51,6 @@ func MerchantFromUser(user users.User) *Merchant {
 		Email:               user.Email,
 		Description:         user.Description,
 		Status:              user.Status == "active",
-		TotalTransactionSum: 0,
+		TotalTransactionSum: Balance{},
 	}
 }
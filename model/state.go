@@ -0,0 +1,108 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const TransactionStatusChangeType string = "TransactionStatusChange"
+
+// Trigger is what changes the status of a transaction
+type Trigger string
+
+const (
+	// CreationTrigger gives a transaction its first status
+	CreationTrigger Trigger = "creation"
+	// ExpiryTrigger changes authorizations whose validity has passed
+	ExpiryTrigger Trigger = "expiry"
+)
+
+// TriggerOf returns the trigger of creating a child transaction of the given type, which changes its parent
+func TriggerOf(childType TransactionType) Trigger {
+	return Trigger(childType)
+}
+
+// Transition allows the transactions of a type to change from a status to one of the given ones because of a trigger
+type Transition struct {
+	Type    TransactionType
+	From    TransactionState
+	Trigger Trigger
+	To      []TransactionState
+}
+
+// Transitions is the state machine of the transactions. A transition to the same status keeps the status,
+// e.g. when a partial charge leaves its authorization approved.
+var Transitions = []Transition{
+	{Type: Authorize, From: Approved, Trigger: TriggerOf(Charge), To: []TransactionState{Approved, Captured}},
+	{Type: Authorize, From: Approved, Trigger: TriggerOf(Reversal), To: []TransactionState{Reversed}},
+	{Type: Authorize, From: Approved, Trigger: ExpiryTrigger, To: []TransactionState{Expired}},
+	{Type: Charge, From: Approved, Trigger: TriggerOf(Refund), To: []TransactionState{PartiallyRefunded, Refunded}},
+	{Type: Charge, From: PartiallyRefunded, Trigger: TriggerOf(Refund), To: []TransactionState{PartiallyRefunded, Refunded}},
+}
+
+// ParentType returns the type of the transactions which the transactions of the given type depend on
+func ParentType(childType TransactionType) (TransactionType, bool) {
+	for _, transition := range Transitions {
+		if transition.Trigger == TriggerOf(childType) {
+			return transition.Type, true
+		}
+	}
+	return "", false
+}
+
+// Accepts tells whether the trigger can change the transaction in its current status.
+// Children whose creation the parent does not accept are created as errored.
+func (t *Transaction) Accepts(trigger Trigger) bool {
+	return t.transition(trigger) != nil
+}
+
+// TransitionTo changes the status of the transaction, if the state machine allows it for the trigger
+func (t *Transaction) TransitionTo(status TransactionState, trigger Trigger) error {
+	if transition := t.transition(trigger); transition != nil {
+		for _, to := range transition.To {
+			if to == status {
+				t.Status = status
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("transaction of type %s cannot change from %s to %s on %s", t.Type, t.Status, status, trigger)
+}
+
+func (t *Transaction) transition(trigger Trigger) *Transition {
+	for i, transition := range Transitions {
+		if transition.Type == t.Type && transition.From == t.Status && transition.Trigger == trigger {
+			return &Transitions[i]
+		}
+	}
+	return nil
+}
+
+// TransactionStatusChange records a transition of a transaction or the status it was created with
+type TransactionStatusChange struct {
+	UUID          string `json:"uuid"`
+	TransactionID string `json:"transaction_id"`
+	// FromStatus is empty for the status which the transaction was created with
+	FromStatus TransactionState `json:"from_status"`
+	ToStatus   TransactionState `json:"to_status"`
+	Trigger    Trigger          `json:"trigger"`
+	// CauseID is the uuid of the child transaction which caused the change, if any
+	CauseID string `json:"cause_id"`
+	// Actor is the user or the client which caused the change, or system for the background jobs
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *TransactionStatusChange) GetType() string {
+	return TransactionStatusChangeType
+}
+
+func (c *TransactionStatusChange) Validate() error {
+	if c.TransactionID == "" {
+		return fmt.Errorf("transaction id is required for status change")
+	}
+	if c.ToStatus == "" {
+		return fmt.Errorf("status is required for status change")
+	}
+	return nil
+}
@@ -13,12 +13,19 @@ const TransactionObjectType string = "Transaction"
 type TransactionState string
 
 const (
-	Approved TransactionState = "approved"
-	Reversed TransactionState = "reversed"
-	Refunded TransactionState = "refunded"
-	Errored  TransactionState = "errored"
+	Approved          TransactionState = "approved"
+	Captured          TransactionState = "captured"
+	Reversed          TransactionState = "reversed"
+	Refunded          TransactionState = "refunded"
+	PartiallyRefunded TransactionState = "partially_refunded"
+	Errored           TransactionState = "errored"
+	// Expired authorizations were not charged in full before their validity passed. The rest of their amount is released.
+	Expired TransactionState = "expired"
 )
 
+// TransactionStates lists all states of the transactions
+var TransactionStates = []TransactionState{Approved, Captured, Reversed, Refunded, PartiallyRefunded, Errored, Expired}
+
 type TransactionType string
 
 const (
@@ -33,12 +40,22 @@ type Transaction struct {
 	UUID          string           `json:"uuid" xml:"UUID"`
 	Type          TransactionType  `json:"type" xml:"Type"`
 	Amount        int              `json:"amount" xml:"Amount"`
+	Currency      Currency         `json:"currency" xml:"Currency"`
 	CustomerEmail string           `json:"customer_email" xml:"CustomerEmail"`
 	CustomerPhone string           `json:"customer_phone" xml:"CustomerPhone"`
 	Status        TransactionState `json:"status" xml:"Status"`
 	CreatedAt     time.Time        `json:"created_at"`
 	UpdatedAt     time.Time        `json:"updated_at"`
 
+	// CapturedAmount is the part of an authorized amount which is already charged
+	CapturedAmount int `json:"captured_amount" xml:"CapturedAmount"`
+	// FinalCapture marks a charge as the last one for its authorization. The rest of the authorized amount is released.
+	FinalCapture bool `json:"final_capture" xml:"FinalCapture"`
+	// RefundedAmount is the part of a charged amount which is already refunded
+	RefundedAmount int `json:"refunded_amount" xml:"RefundedAmount"`
+	// ExpiresAt is the time after which an authorization cannot be charged. It is zero for the other transactions.
+	ExpiresAt time.Time `json:"expires_at" xml:"ExpiresAt"`
+
 	DependsOnUUID string `json:"depends_on_uuid" xml:"DependsOnUUID"`
 	MerchantID    string `json:"merchant_id" xml:"MerchantID"`
 }
@@ -54,9 +71,27 @@ func (t *Transaction) Validate() error {
 	if t.Amount < 1 && t.Type != Reversal {
 		return errors.New("amount should be greater than 0")
 	}
+	// Charges, refunds and reversals take the currency of their parent when it is not provided
+	if t.Type == Authorize || t.Currency != "" {
+		if err := t.Currency.Validate(); err != nil {
+			return err
+		}
+	}
 	if t.Status != "" {
 		return errors.New("status should not be provided")
 	}
+	if t.CapturedAmount != 0 {
+		return errors.New("captured amount should not be provided")
+	}
+	if t.RefundedAmount != 0 {
+		return errors.New("refunded amount should not be provided")
+	}
+	if !t.ExpiresAt.IsZero() {
+		return errors.New("expiry time should not be provided")
+	}
+	if t.FinalCapture && t.Type != Charge {
+		return fmt.Errorf("only transaction of type %s can be a final capture", Charge)
+	}
 	switch t.Type {
 	case Authorize:
 		if t.DependsOnUUID != "" {
@@ -79,3 +114,13 @@ func (t *Transaction) Validate() error {
 	}
 	return nil
 }
+
+// RemainingAmount returns the part of an authorized amount which can still be charged
+func (t *Transaction) RemainingAmount() int {
+	return t.Amount - t.CapturedAmount
+}
+
+// RefundableAmount returns the part of a charged amount which can still be refunded
+func (t *Transaction) RefundableAmount() int {
+	return t.Amount - t.RefundedAmount
+}
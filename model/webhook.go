@@ -0,0 +1,95 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	WebhookEndpointType string = "WebhookEndpoint"
+	WebhookEventType    string = "WebhookEvent"
+)
+
+const (
+	// TransactionCreatedEvent is sent for every created transaction
+	TransactionCreatedEvent = "transaction.created"
+	// TransactionStatusChangedEvent is sent when a child transaction changes the status of its parent
+	TransactionStatusChangedEvent = "transaction.status_changed"
+)
+
+// WebhookEndpoint is an URL of a merchant which receives events about its transactions
+type WebhookEndpoint struct {
+	UUID       string `json:"uuid"`
+	MerchantID string `json:"merchant_id"`
+	URL        string `json:"url"`
+	// Secret is used to sign the events sent to the endpoint. It is shown only when the endpoint is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e *WebhookEndpoint) GetType() string {
+	return WebhookEndpointType
+}
+
+func (e *WebhookEndpoint) Validate() error {
+	if e.URL == "" {
+		return errors.New("webhook url is required")
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("webhook url is invalid: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("webhook url should be http or https")
+	}
+	if e.Secret != "" {
+		return errors.New("secret should not be provided")
+	}
+	return nil
+}
+
+type WebhookEventState string
+
+const (
+	WebhookEventPending   WebhookEventState = "pending"
+	WebhookEventDelivered WebhookEventState = "delivered"
+	WebhookEventFailed    WebhookEventState = "failed"
+)
+
+// WebhookEvent is a single delivery of an event to a webhook endpoint.
+// Events are stored together with the change they describe and are delivered later by the webhook dispatcher.
+type WebhookEvent struct {
+	UUID          string            `json:"uuid"`
+	EndpointID    string            `json:"endpoint_id"`
+	MerchantID    string            `json:"merchant_id"`
+	EventType     string            `json:"event_type"`
+	Payload       []byte            `json:"-"`
+	Status        WebhookEventState `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	LastError     string            `json:"last_error"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+func (e *WebhookEvent) GetType() string {
+	return WebhookEventType
+}
+
+func (e *WebhookEvent) Validate() error {
+	if e.EndpointID == "" {
+		return errors.New("endpoint id is required for webhook event")
+	}
+	if e.EventType == "" {
+		return errors.New("event type is required for webhook event")
+	}
+	return nil
+}
+
+// WebhookPayload is the body sent to the webhook endpoints
+type WebhookPayload struct {
+	EventType   string       `json:"event_type"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Transaction *Transaction `json:"transaction"`
+}
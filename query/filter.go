@@ -0,0 +1,312 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pankrator/payment/criteria"
+)
+
+var comparisons = map[string]criteria.Operator{
+	"eq":   criteria.Equal,
+	"ne":   criteria.NotEqual,
+	"gt":   criteria.GreaterThan,
+	"ge":   criteria.GreaterOrEqual,
+	"lt":   criteria.LessThan,
+	"le":   criteria.LessOrEqual,
+	"like": criteria.Like,
+}
+
+// Kind is the type of the values of a field in filters
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Bool
+	// Time values are in RFC 3339 format or dates in the format 2006-01-02
+	Time
+)
+
+// Field is a field which can be used in filters together with the kind of its values and the operators allowed for it
+type Field struct {
+	Kind      Kind
+	Operators []criteria.Operator
+}
+
+func (f Field) allows(operator criteria.Operator) bool {
+	for _, o := range f.Operators {
+		if o == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Field) parse(name, value string) (interface{}, error) {
+	switch f.Kind {
+	case Int:
+		result, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be an integer", name)
+		}
+		return result, nil
+	case Bool:
+		result, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be true or false", name)
+		}
+		return result, nil
+	case Time:
+		result, err := ParseTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be a time in RFC 3339 format or a date", name)
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
+
+// ParseTime parses a time in RFC 3339 format or a date in the format 2006-01-02, which is the start of the day in UTC
+func ParseTime(value string) (time.Time, error) {
+	if result, err := time.Parse(time.RFC3339, value); err == nil {
+		return result, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// ParseFilter parses a filter of conditions joined with "and" into a criterion, e.g.
+//
+//	type eq charge and amount between 100 and 200 and customer_email in (a@b.com, c@d.com) and depends_on_uuid is null
+//
+// Only the fields given in the whitelist can be used, with the operators allowed for them.
+// Values containing spaces should be quoted with single quotes.
+func ParseFilter(filter string, fields map[string]Field) (criteria.Criterion, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	result := make([]criteria.Criterion, 0)
+	for {
+		c, err := p.parseCondition(fields)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+
+		if p.done() {
+			return criteria.And(result...), nil
+		}
+		if !p.acceptKeyword("and") {
+			return nil, fmt.Errorf("expected and instead of %s", p.peek())
+		}
+	}
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() string {
+	if p.done() {
+		return "end of filter"
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() (string, error) {
+	if p.done() {
+		return "", fmt.Errorf("unexpected end of filter")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+func (p *filterParser) acceptKeyword(keyword string) bool {
+	if !p.done() && strings.EqualFold(p.tokens[p.pos], keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) value() (string, error) {
+	token, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if token == "(" || token == ")" || token == "," {
+		return "", fmt.Errorf("expected value instead of %s", token)
+	}
+	return unquote(token), nil
+}
+
+func (p *filterParser) parseCondition(fields map[string]Field) (criteria.Criterion, error) {
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	field, ok := fields[name]
+	if !ok {
+		return nil, fmt.Errorf("filtering by %s is not supported", name)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	var operator criteria.Operator
+	var values []string
+	switch strings.ToLower(op) {
+	case "is":
+		operator = criteria.IsNull
+		if p.acceptKeyword("not") {
+			operator = criteria.IsNotNull
+		}
+		if !p.acceptKeyword("null") {
+			return nil, fmt.Errorf("expected null instead of %s", p.peek())
+		}
+	case "between":
+		from, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("and") {
+			return nil, fmt.Errorf("expected and instead of %s", p.peek())
+		}
+		to, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		operator = criteria.Between
+		values = []string{from, to}
+	case "in":
+		values, err = p.list()
+		if err != nil {
+			return nil, err
+		}
+		operator = criteria.In
+	default:
+		operator, ok = comparisons[strings.ToLower(op)]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %s", op)
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = []string{value}
+	}
+
+	if !field.allows(operator) {
+		return nil, fmt.Errorf("operation %s is not supported for %s", op, name)
+	}
+	var typed []interface{}
+	for _, v := range values {
+		// Like patterns are always text
+		if operator == criteria.Like {
+			typed = append(typed, v)
+			continue
+		}
+		value, err := field.parse(name, v)
+		if err != nil {
+			return nil, err
+		}
+		typed = append(typed, value)
+	}
+	return &criteria.Comparison{
+		Field:    name,
+		Operator: operator,
+		Values:   typed,
+	}, nil
+}
+
+// list parses a list of values in parentheses
+func (p *filterParser) list() ([]string, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if token != "(" {
+		return nil, fmt.Errorf("expected ( instead of %s", token)
+	}
+
+	values := make([]string, 0)
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case ")":
+			return values, nil
+		case ",":
+		default:
+			return nil, fmt.Errorf("expected , or ) instead of %s", token)
+		}
+	}
+}
+
+// tokenize splits the filter by spaces. Parentheses and commas are separate tokens
+// and values in single quotes are kept together with the quotes.
+func tokenize(filter string) ([]string, error) {
+	tokens := make([]string, 0)
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	quoted := false
+	for _, r := range filter {
+		switch {
+		case r == '\'':
+			current.WriteRune(r)
+			quoted = !quoted
+		case quoted:
+			current.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		case r == '(' || r == ')' || r == ',':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in filter")
+	}
+	flush()
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("filter is empty")
+	}
+	return tokens, nil
+}
+
+func unquote(token string) string {
+	if len(token) >= 2 && strings.HasPrefix(token, "'") && strings.HasSuffix(token, "'") {
+		return token[1 : len(token)-1]
+	}
+	return token
+}
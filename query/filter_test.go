@@ -0,0 +1,85 @@
+package query_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/query"
+)
+
+var _ = Describe("Filter", func() {
+	fields := map[string]query.Field{
+		"type":            {Kind: query.String, Operators: []criteria.Operator{criteria.Equal, criteria.In}},
+		"amount":          {Kind: query.Int, Operators: []criteria.Operator{criteria.GreaterThan, criteria.Between}},
+		"customer_email":  {Kind: query.String, Operators: []criteria.Operator{criteria.Like}},
+		"depends_on_uuid": {Kind: query.String, Operators: []criteria.Operator{criteria.IsNull, criteria.IsNotNull}},
+		"created_at":      {Kind: query.Time, Operators: []criteria.Operator{criteria.GreaterOrEqual}},
+	}
+
+	It("should parse conditions joined with and", func() {
+		result, err := query.ParseFilter("type eq charge AND amount gt 100", fields)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).To(Equal(criteria.And(
+			criteria.Eq("type", "charge"),
+			criteria.Gt("amount", int64(100)),
+		)))
+	})
+
+	It("should parse in, between, like and is null", func() {
+		result, err := query.ParseFilter(
+			"type in (charge, refund) and amount between 100 and 200 and customer_email like '%@mail.com' and depends_on_uuid is not null", fields)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).To(Equal(criteria.And(
+			criteria.OneOf("type", "charge", "refund"),
+			criteria.InRange("amount", int64(100), int64(200)),
+			criteria.LikePattern("customer_email", "%@mail.com"),
+			criteria.NotNull("depends_on_uuid"),
+		)))
+	})
+
+	It("should parse typed values", func() {
+		result, err := query.ParseFilter("created_at ge 2020-03-01", fields)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).To(Equal(criteria.And(
+			criteria.Ge("created_at", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)),
+		)))
+
+		_, err = query.ParseFilter("amount gt many", fields)
+		Expect(err).To(MatchError("amount should be an integer"))
+	})
+
+	It("should keep quoted values with spaces together", func() {
+		result, err := query.ParseFilter("type eq 'some type'", fields)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).To(Equal(criteria.And(criteria.Eq("type", "some type"))))
+	})
+
+	It("should not accept fields which are not whitelisted", func() {
+		_, err := query.ParseFilter("merchant_id eq 1", fields)
+		Expect(err).To(MatchError("filtering by merchant_id is not supported"))
+	})
+
+	It("should not accept operations which are not allowed for the field", func() {
+		_, err := query.ParseFilter("amount like 1", fields)
+		Expect(err).To(MatchError("operation like is not supported for amount"))
+	})
+
+	It("should not accept unknown operations", func() {
+		_, err := query.ParseFilter("type = charge", fields)
+		Expect(err).To(MatchError("unknown operation ="))
+	})
+
+	It("should not accept incomplete filters", func() {
+		for _, filter := range []string{"", "type", "type eq", "type eq charge and", "type in (charge", "amount between 1", "type eq 'charge"} {
+			_, err := query.ParseFilter(filter, fields)
+			Expect(err).Should(HaveOccurred(), filter)
+		}
+	})
+
+	It("should not accept conditions which are not joined with and", func() {
+		_, err := query.ParseFilter("type eq charge or type eq refund", fields)
+		Expect(err).To(MatchError("expected and instead of or"))
+	})
+})
@@ -0,0 +1,10 @@
+package query
+
+// Page selects a part of a listing ordered by a single field.
+// The listing continues after the position encoded in the cursor, which is returned together with the previous page.
+type Page struct {
+	Limit      int
+	Cursor     string
+	OrderBy    string
+	Descending bool
+}
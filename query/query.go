@@ -1,6 +1,10 @@
 package query
 
-import "context"
+import (
+	"context"
+
+	"github.com/pankrator/payment/criteria"
+)
 
 type ctxKey string
 
@@ -8,26 +12,23 @@ var (
 	queryCtxKey = ctxKey("query")
 )
 
-type Query struct {
-	Type      string
-	Key       string
-	Operation string
-	Value     string
-}
-
-func AddQuery(ctx context.Context, q Query) context.Context {
-	currentQuery, ok := ctx.Value(queryCtxKey).([]Query)
-	if !ok {
-		currentQuery = make([]Query, 0)
+// AddCriterion adds a criterion which the listed objects of the given type should match
+func AddCriterion(ctx context.Context, typee string, c criteria.Criterion) context.Context {
+	current, _ := ctx.Value(queryCtxKey).(map[string][]criteria.Criterion)
+	result := make(map[string][]criteria.Criterion, len(current)+1)
+	for t, cs := range current {
+		result[t] = cs
 	}
-	currentQuery = append(currentQuery, q)
-	return context.WithValue(ctx, queryCtxKey, currentQuery)
+	result[typee] = append(current[typee][:len(current[typee]):len(current[typee])], c)
+	return context.WithValue(ctx, queryCtxKey, result)
 }
 
-func QueryFromContext(ctx context.Context) []Query {
-	currentQuery, ok := ctx.Value(queryCtxKey).([]Query)
-	if !ok {
+// CriterionFromContext returns the criteria added for the given type joined with and,
+// or nil when there are none
+func CriterionFromContext(ctx context.Context, typee string) criteria.Criterion {
+	current, _ := ctx.Value(queryCtxKey).(map[string][]criteria.Criterion)
+	if len(current[typee]) == 0 {
 		return nil
 	}
-	return currentQuery
+	return criteria.And(current[typee]...)
 }
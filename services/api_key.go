@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
+)
+
+// ErrInvalidAPIKey is returned for keys which are malformed, unknown or revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+const (
+	apiKeyPrefix = "pay_"
+	// apiKeyIDLength is the number of hex characters after the mode which identify a key
+	apiKeyIDLength = 12
+)
+
+type APIKeyService struct {
+	repository storage.Storage
+}
+
+func NewAPIKeyService(repository storage.Storage) *APIKeyService {
+	return &APIKeyService{
+		repository: repository,
+	}
+}
+
+// Create generates a key for the merchant. The key is returned only from here, as only its hash is stored.
+func (ks *APIKeyService) Create(ctx context.Context, merchantID string, key *model.APIKey) (*model.APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Create")
+	defer span.End()
+
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := ks.repository.Get(ctx, model.MerchantType, merchantID); err != nil {
+		return nil, err
+	}
+
+	UUID, err := uuid.NewV4()
+	if err != nil {
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
+		return nil, errors.New("could not generate UUID")
+	}
+	random := make([]byte, apiKeyIDLength/2+32)
+	if _, err := rand.Read(random); err != nil {
+		log.C(ctx).Errorf("Could not generate API key: %s", err)
+		return nil, errors.New("could not generate API key")
+	}
+	text := hex.EncodeToString(random)
+
+	key.UUID = UUID.String()
+	key.MerchantID = merchantID
+	key.Prefix = apiKeyPrefix + string(key.Mode) + "_" + text[:apiKeyIDLength]
+	fullKey := key.Prefix + "_" + text[apiKeyIDLength:]
+	key.Hash = hashAPIKey(fullKey)
+	key.RevokedAt = time.Time{}
+
+	result, err := ks.repository.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	created := result.(*model.APIKey)
+	created.Key = fullKey
+	return created, nil
+}
+
+// List lists the keys of the merchant, including the revoked ones
+func (ks *APIKeyService) List(ctx context.Context, merchantID string) ([]model.Object, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.List")
+	defer span.End()
+
+	if _, err := ks.repository.Get(ctx, model.MerchantType, merchantID); err != nil {
+		return nil, err
+	}
+	return ks.repository.List(ctx, model.APIKeyType, criteria.Eq("merchant_id", merchantID))
+}
+
+// Revoke stops the key of the merchant from authenticating requests. Revoked keys are kept to be listed.
+func (ks *APIKeyService) Revoke(ctx context.Context, merchantID, keyID string) error {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Revoke")
+	defer span.End()
+
+	object, err := ks.repository.GetBy(ctx, model.APIKeyType, criteria.And(
+		criteria.Eq("merchant_id", merchantID),
+		criteria.Eq("uuid", keyID),
+	))
+	if err != nil {
+		return err
+	}
+	key := object.(*model.APIKey)
+	if key.Revoked() {
+		return nil
+	}
+	key.RevokedAt = time.Now()
+	return ks.repository.Save(ctx, key)
+}
+
+// Verify returns the active key and its merchant for the given key text
+func (ks *APIKeyService) Verify(ctx context.Context, text string) (*model.APIKey, *model.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Verify")
+	defer span.End()
+
+	prefix, ok := apiKeyPrefixOf(text)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	object, err := ks.repository.GetBy(ctx, model.APIKeyType, criteria.Eq("prefix", prefix))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	key := object.(*model.APIKey)
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(text))) != 1 || key.Revoked() {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	object, err = ks.repository.Get(ctx, model.MerchantType, key.MerchantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, object.(*model.Merchant), nil
+}
+
+// apiKeyPrefixOf returns the identifying prefix of the key, e.g. pay_live_0123456789ab
+func apiKeyPrefixOf(text string) (string, bool) {
+	for _, mode := range []model.APIKeyMode{model.LiveAPIKey, model.TestAPIKey} {
+		start := apiKeyPrefix + string(mode) + "_"
+		if strings.HasPrefix(text, start) && len(text) > len(start)+apiKeyIDLength {
+			return text[:len(start)+apiKeyIDLength], true
+		}
+	}
+	return "", false
+}
+
+func hashAPIKey(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("API keys", func() {
+	var repository *memory.Storage
+	var apiKeyService *services.APIKeyService
+	var merchant *model.Merchant
+	var key *model.APIKey
+
+	BeforeEach(func() {
+		repository = memory.New()
+		apiKeyService = services.NewAPIKeyService(repository)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+
+		key, err = apiKeyService.Create(context.Background(), merchant.UUID, &model.APIKey{
+			Name:   "server",
+			Mode:   model.TestAPIKey,
+			Scopes: []string{"transaction.read"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should show the key only when it is created", func() {
+		Expect(key.Key).To(HavePrefix(key.Prefix + "_"))
+		Expect(key.Prefix).To(HavePrefix("pay_test_"))
+		Expect(key.Hash).ToNot(ContainSubstring(strings.TrimPrefix(key.Key, key.Prefix)))
+
+		keys, err := apiKeyService.List(context.Background(), merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(keys).To(HaveLen(1))
+		Expect(keys[0].(*model.APIKey).Key).To(BeEmpty())
+	})
+
+	It("should verify active keys", func() {
+		verified, verifiedMerchant, err := apiKeyService.Verify(context.Background(), key.Key)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(verified.UUID).To(Equal(key.UUID))
+		Expect(verifiedMerchant.Email).To(Equal(merchant.Email))
+
+		_, _, err = apiKeyService.Verify(context.Background(), key.Key+"0")
+		Expect(err).To(Equal(services.ErrInvalidAPIKey))
+		_, _, err = apiKeyService.Verify(context.Background(), "pay_live_"+strings.TrimPrefix(key.Key, "pay_test_"))
+		Expect(err).To(Equal(services.ErrInvalidAPIKey))
+	})
+
+	It("should not verify revoked keys", func() {
+		Expect(apiKeyService.Revoke(context.Background(), "other", key.UUID)).To(Equal(storage.ErrNotFound))
+		Expect(apiKeyService.Revoke(context.Background(), merchant.UUID, key.UUID)).To(Succeed())
+
+		_, _, err := apiKeyService.Verify(context.Background(), key.Key)
+		Expect(err).To(Equal(services.ErrInvalidAPIKey))
+	})
+
+	It("should only give transaction scopes to keys", func() {
+		_, err := apiKeyService.Create(context.Background(), merchant.UUID, &model.APIKey{
+			Name:   "server",
+			Mode:   model.LiveAPIKey,
+			Scopes: []string{"merchant.write"},
+		})
+		Expect(err).To(MatchError("scope merchant.write cannot be given to api keys"))
+	})
+
+	It("should not let keys change the transactions of other merchants", func() {
+		paymentService := services.NewPaymentService(repository, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "other",
+			Email:  "other@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		authorization, err := paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Authorize,
+			Amount:        10,
+			Currency:      model.EUR,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    object.(*model.Merchant).UUID,
+		}, "other")
+		Expect(err).ShouldNot(HaveOccurred())
+		authorizationID := authorization.(*model.Transaction).UUID
+
+		writeKey, err := apiKeyService.Create(context.Background(), merchant.UUID, &model.APIKey{
+			Name:   "server",
+			Mode:   model.LiveAPIKey,
+			Scopes: []string{"transaction.write"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, keyMerchant, err := apiKeyService.Verify(context.Background(), writeKey.Key)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for _, transactionType := range []model.TransactionType{model.Charge, model.Reversal} {
+			_, err = paymentService.Create(context.Background(), &model.Transaction{
+				Type:          transactionType,
+				Amount:        10,
+				CustomerEmail: "user@customer.com",
+				MerchantID:    keyMerchant.UUID,
+				DependsOnUUID: authorizationID,
+			}, writeKey.Prefix)
+			Expect(err).To(MatchError("parent transaction with uuid " + authorizationID + " not found"))
+			Expect(err).To(BeAssignableToTypeOf(&services.RejectedError{}))
+		}
+
+		object, err = repository.Get(context.Background(), model.TransactionObjectType, authorizationID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Transaction).Status).To(Equal(model.Approved))
+		Expect(object.(*model.Transaction).CapturedAmount).To(BeZero())
+	})
+})
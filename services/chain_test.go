@@ -0,0 +1,72 @@
+package services_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("Transaction chain", func() {
+	var paymentService *services.PaymentService
+	var merchant *model.Merchant
+	var authorizationID, chargeID, refundID, secondChargeID string
+
+	create := func(transaction *model.Transaction) string {
+		transaction.CustomerEmail = "user@customer.com"
+		transaction.MerchantID = merchant.UUID
+		object, err := paymentService.Create(context.Background(), transaction, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+		return object.(*model.Transaction).UUID
+	}
+
+	uuids := func(transactions []*model.Transaction) []string {
+		result := make([]string, 0, len(transactions))
+		for _, transaction := range transactions {
+			result = append(result, transaction.UUID)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		repository := memory.New()
+		paymentService = services.NewPaymentService(repository, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+
+		authorizationID = create(&model.Transaction{Type: model.Authorize, Currency: model.EUR, Amount: 10})
+		chargeID = create(&model.Transaction{Type: model.Charge, Amount: 4, DependsOnUUID: authorizationID})
+		refundID = create(&model.Transaction{Type: model.Refund, Amount: 1, DependsOnUUID: chargeID})
+		secondChargeID = create(&model.Transaction{Type: model.Charge, Amount: 3, DependsOnUUID: authorizationID})
+	})
+
+	It("should return the descendants depth first", func() {
+		chain, err := paymentService.Chain(context.Background(), authorizationID, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(chain.Transaction.UUID).To(Equal(authorizationID))
+		Expect(chain.Ancestors).To(BeEmpty())
+		Expect(uuids(chain.Descendants)).To(Equal([]string{chargeID, refundID, secondChargeID}))
+	})
+
+	It("should return the ancestors from the authorization", func() {
+		chain, err := paymentService.Chain(context.Background(), refundID, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(uuids(chain.Ancestors)).To(Equal([]string{authorizationID, chargeID}))
+		Expect(chain.Descendants).To(BeEmpty())
+	})
+
+	It("should not find transactions not matching the criterion", func() {
+		_, err := paymentService.Chain(context.Background(), chargeID, criteria.Eq("merchant_id", "other"))
+		Expect(err).To(Equal(storage.ErrNotFound))
+	})
+})
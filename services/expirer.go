@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type AuthorizationSettings struct {
+	// Validity is how long authorizations can be charged, unless their merchant has its own validity
+	Validity time.Duration `mapstructure:"validity"`
+	// ExpiryInterval is how often the expired authorizations are voided
+	ExpiryInterval time.Duration `mapstructure:"expiry_interval"`
+}
+
+func DefaultAuthorizationSettings() *AuthorizationSettings {
+	return &AuthorizationSettings{
+		Validity:       time.Hour * 24 * 7,
+		ExpiryInterval: time.Minute,
+	}
+}
+
+func (s *AuthorizationSettings) Keys() []string {
+	return []string{
+		"validity",
+		"expiry_interval",
+	}
+}
+
+// heartbeatTolerance is how many intervals the background workers may miss their heartbeat for, e.g. while
+// a run is going on. Workers whose runs take longer beat their heartbeats while running as well.
+const heartbeatTolerance = 3
+
+// AuthorizationExpirer periodically voids the authorizations whose validity has passed
+type AuthorizationExpirer struct {
+	settings       *AuthorizationSettings
+	paymentService *PaymentService
+	metrics        *metrics.Metrics
+	heartbeat      *health.Heartbeat
+}
+
+func NewAuthorizationExpirer(settings *AuthorizationSettings, paymentService *PaymentService, metrics *metrics.Metrics) *AuthorizationExpirer {
+	return &AuthorizationExpirer{
+		settings:       settings,
+		paymentService: paymentService,
+		metrics:        metrics,
+		heartbeat:      health.NewHeartbeat(settings.ExpiryInterval * heartbeatTolerance),
+	}
+}
+
+// Heartbeat is beaten on every iteration of the loop started with Start
+func (ae *AuthorizationExpirer) Heartbeat() *health.Heartbeat {
+	return ae.heartbeat
+}
+
+func (ae *AuthorizationExpirer) Start(ctx context.Context) {
+	ctx = log.ContextWithFields(ctx, logrus.Fields{"job": "authorization_expirer"})
+	go func() {
+		for {
+			ae.heartbeat.Beat()
+			elapsed := time.After(ae.settings.ExpiryInterval)
+			select {
+			case <-ctx.Done():
+				log.C(ctx).Infof("Context cancelled. Stopping the authorization expirer...")
+				return
+			case <-elapsed:
+				start := time.Now()
+				count, err := ae.paymentService.ExpireAuthorizations(ctx)
+				ae.metrics.ObserveJob("authorization_expirer", start, err)
+				if err != nil {
+					log.C(ctx).Errorf("Could not expire authorizations: %s", err)
+				}
+				if count > 0 {
+					log.C(ctx).Infof("Expired %d authorizations", count)
+				}
+			}
+		}
+	}()
+
+	log.C(ctx).Infof("Authorization expirer started")
+}
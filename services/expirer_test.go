@@ -0,0 +1,129 @@
+package services_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("Authorization expiry", func() {
+	var repository *memory.Storage
+	var paymentService *services.PaymentService
+	var merchant *model.Merchant
+	var authorization *model.Transaction
+
+	getTransaction := func(id string) *model.Transaction {
+		object, err := repository.Get(context.Background(), model.TransactionObjectType, id)
+		Expect(err).ShouldNot(HaveOccurred())
+		return object.(*model.Transaction)
+	}
+
+	charge := func(amount int) error {
+		_, err := paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Charge,
+			Amount:        amount,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    merchant.UUID,
+			DependsOnUUID: authorization.UUID,
+		}, "user")
+		return err
+	}
+
+	BeforeEach(func() {
+		repository = memory.New()
+		paymentService = services.NewPaymentService(repository, services.DefaultLedgerSettings(), &services.AuthorizationSettings{
+			Validity: time.Hour,
+		}, nil)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:                  "merchant",
+			Email:                 "merchant@mail.com",
+			Status:                true,
+			AuthorizationValidity: model.Duration(time.Hour * 2),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+
+		object, err = paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Authorize,
+			Currency:      model.EUR,
+			Amount:        10,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    merchant.UUID,
+		}, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+		authorization = object.(*model.Transaction)
+	})
+
+	It("should use the validity of the merchant", func() {
+		Expect(authorization.ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Hour*2), time.Minute))
+	})
+
+	When("the validity has passed", func() {
+		BeforeEach(func() {
+			Expect(charge(4)).To(Succeed())
+			authorization = getTransaction(authorization.UUID)
+			authorization.ExpiresAt = time.Now().Add(-time.Second)
+			Expect(repository.Save(context.Background(), authorization)).To(Succeed())
+		})
+
+		It("should not charge the authorization", func() {
+			Expect(charge(1)).To(Equal(services.ErrAuthorizationExpired))
+		})
+
+		It("should void the authorization and release the rest of its amount", func() {
+			count, err := paymentService.ExpireAuthorizations(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+			Expect(getTransaction(authorization.UUID).Status).To(Equal(model.Expired))
+
+			history, err := paymentService.History(context.Background(), authorization.UUID, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(history[len(history)-1].Trigger).To(Equal(model.ExpiryTrigger))
+			Expect(history[len(history)-1].Actor).To(Equal(services.SystemActor))
+
+			reversal, err := repository.GetBy(context.Background(), model.TransactionObjectType, criteria.Eq("type", model.Reversal))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reversal.(*model.Transaction).DependsOnUUID).To(Equal(authorization.UUID))
+			Expect(reversal.(*model.Transaction).Amount).To(Equal(6))
+
+			entries, err := repository.List(context.Background(), model.LedgerEntryType, criteria.Eq("account", model.MerchantPendingAccount))
+			Expect(err).ShouldNot(HaveOccurred())
+			pending := int64(0)
+			for _, entry := range entries {
+				pending += entry.(*model.LedgerEntry).Change()
+			}
+			Expect(pending).To(BeZero())
+
+			Expect(charge(1)).To(Equal(services.ErrAuthorizationExpired))
+
+			count, err = paymentService.ExpireAuthorizations(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count).To(BeZero())
+		})
+	})
+
+	It("should expire authorizations without expiry time after the default validity", func() {
+		authorization.ExpiresAt = time.Time{}
+		authorization.CreatedAt = time.Now().Add(-time.Hour * 3 / 2)
+		Expect(repository.Save(context.Background(), authorization)).To(Succeed())
+
+		count, err := paymentService.ExpireAuthorizations(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+		Expect(getTransaction(authorization.UUID).Status).To(Equal(model.Expired))
+	})
+
+	It("should not expire valid authorizations", func() {
+		count, err := paymentService.ExpireAuthorizations(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).To(BeZero())
+		Expect(charge(10)).To(Succeed())
+	})
+})
@@ -0,0 +1,101 @@
+package services_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("Transaction status history", func() {
+	var repository *memory.Storage
+	var paymentService *services.PaymentService
+	var merchant *model.Merchant
+	var authorizationID string
+
+	create := func(transaction *model.Transaction, actor string) *model.Transaction {
+		transaction.CustomerEmail = "user@customer.com"
+		transaction.MerchantID = merchant.UUID
+		object, err := paymentService.Create(context.Background(), transaction, actor)
+		Expect(err).ShouldNot(HaveOccurred())
+		return object.(*model.Transaction)
+	}
+
+	BeforeEach(func() {
+		repository = memory.New()
+		paymentService = services.NewPaymentService(repository, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+
+		authorizationID = create(&model.Transaction{Type: model.Authorize, Currency: model.EUR, Amount: 10}, "alice").UUID
+	})
+
+	It("should record the creation and the transitions caused by the children", func() {
+		create(&model.Transaction{Type: model.Charge, Amount: 4, DependsOnUUID: authorizationID}, "bob")
+		charge := create(&model.Transaction{Type: model.Charge, Amount: 6, DependsOnUUID: authorizationID}, "carol")
+
+		history, err := paymentService.History(context.Background(), authorizationID, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].FromStatus).To(BeEmpty())
+		Expect(history[0].ToStatus).To(Equal(model.Approved))
+		Expect(history[0].Trigger).To(Equal(model.CreationTrigger))
+		Expect(history[0].Actor).To(Equal("alice"))
+		// The partial charge keeps the authorization approved
+		Expect(history[1].FromStatus).To(Equal(model.Approved))
+		Expect(history[1].ToStatus).To(Equal(model.Captured))
+		Expect(history[1].Trigger).To(Equal(model.TriggerOf(model.Charge)))
+		Expect(history[1].CauseID).To(Equal(charge.UUID))
+		Expect(history[1].Actor).To(Equal("carol"))
+	})
+
+	It("should not change parents which do not accept the child", func() {
+		create(&model.Transaction{Type: model.Reversal, DependsOnUUID: authorizationID}, "bob")
+		chargeID := create(&model.Transaction{Type: model.Charge, Amount: 4, DependsOnUUID: authorizationID}, "bob").UUID
+		object, err := repository.Get(context.Background(), model.TransactionObjectType, chargeID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Transaction).Status).To(Equal(model.Errored))
+
+		history, err := paymentService.History(context.Background(), authorizationID, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(history).To(HaveLen(2))
+		Expect(history[1].ToStatus).To(Equal(model.Reversed))
+	})
+
+	It("should not return the history of transactions not matching the criterion", func() {
+		_, err := paymentService.History(context.Background(), authorizationID, criteria.Eq("merchant_id", "other"))
+		Expect(err).To(Equal(storage.ErrNotFound))
+	})
+
+	Describe("state machine", func() {
+		It("should allow only the declared transitions", func() {
+			authorization := &model.Transaction{Type: model.Authorize, Status: model.Approved}
+			Expect(authorization.Accepts(model.TriggerOf(model.Refund))).To(BeFalse())
+			Expect(authorization.TransitionTo(model.Refunded, model.TriggerOf(model.Charge))).ToNot(Succeed())
+			Expect(authorization.Status).To(Equal(model.Approved))
+
+			Expect(authorization.TransitionTo(model.Expired, model.ExpiryTrigger)).To(Succeed())
+			Expect(authorization.Status).To(Equal(model.Expired))
+			Expect(authorization.Accepts(model.TriggerOf(model.Charge))).To(BeFalse())
+		})
+
+		It("should know the parent type of the children", func() {
+			parentType, found := model.ParentType(model.Refund)
+			Expect(found).To(BeTrue())
+			Expect(parentType).To(Equal(model.Charge))
+
+			_, found = model.ParentType(model.Authorize)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
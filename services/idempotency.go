@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again together with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key is already used for a different request")
+	// ErrIdempotencyKeyInProgress is returned when the first request with the same key is still being processed
+	ErrIdempotencyKeyInProgress = errors.New("a request with the same idempotency key is still being processed")
+)
+
+type IdempotencySettings struct {
+	// ReservationTimeout is how long a key is reserved for a request which has not stored its response,
+	// e.g. because the process stopped. The key is given to the next request with it afterwards.
+	// It should be longer than the request timeout of the server.
+	ReservationTimeout time.Duration `mapstructure:"reservation_timeout"`
+}
+
+func DefaultIdempotencySettings() *IdempotencySettings {
+	return &IdempotencySettings{
+		ReservationTimeout: 5 * time.Minute,
+	}
+}
+
+func (s *IdempotencySettings) Keys() []string {
+	return []string{
+		"reservation_timeout",
+	}
+}
+
+type IdempotencyService struct {
+	settings   *IdempotencySettings
+	repository storage.Storage
+}
+
+func NewIdempotencyService(settings *IdempotencySettings, repository storage.Storage) *IdempotencyService {
+	return &IdempotencyService{
+		settings:   settings,
+		repository: repository,
+	}
+}
+
+// Begin reserves the key of the merchant for the request with the given hash. If the key is already used
+// by a completed request with the same hash, the stored record is returned and its response should be replayed.
+func (is *IdempotencyService) Begin(ctx context.Context, merchantID, key, requestHash string) (*model.IdempotencyKey, error) {
+	ctx, span := tracing.Start(ctx, "IdempotencyService.Begin")
+	defer span.End()
+
+	record, err := is.find(ctx, merchantID, key)
+	switch {
+	case err == nil && is.abandoned(record):
+		// Deleting the record by its id does not affect another request which has already taken the key over
+		log.C(ctx).Infof("Taking over idempotency key %s reserved at %s", key, record.CreatedAt.Format(time.RFC3339))
+		if err := is.repository.Delete(ctx, model.IdempotencyKeyType, criteria.Eq("uuid", record.UUID)); err != nil {
+			return nil, err
+		}
+	case err == nil:
+		return checkIdempotencyKey(record, requestHash)
+	case err != storage.ErrNotFound:
+		return nil, err
+	}
+
+	record = &model.IdempotencyKey{
+		Key:         key,
+		MerchantID:  merchantID,
+		RequestHash: requestHash,
+	}
+	if err := record.Validate(); err != nil {
+		return nil, err
+	}
+
+	UUID, err := uuid.NewV4()
+	if err != nil {
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
+		return nil, errors.New("could not generate UUID")
+	}
+	record.UUID = UUID.String()
+
+	object, createErr := is.repository.Create(ctx, record)
+	if createErr != nil {
+		// Another request with the same key might have reserved it in the meantime
+		existing, err := is.find(ctx, merchantID, key)
+		if err != nil {
+			return nil, createErr
+		}
+		return checkIdempotencyKey(existing, requestHash)
+	}
+	return object.(*model.IdempotencyKey), nil
+}
+
+// Complete stores the response to the request which reserved the key
+func (is *IdempotencyService) Complete(ctx context.Context, record *model.IdempotencyKey, statusCode int, body []byte) error {
+	ctx, span := tracing.Start(ctx, "IdempotencyService.Complete")
+	defer span.End()
+
+	record.StatusCode = statusCode
+	record.Body = body
+	return is.repository.Save(ctx, record)
+}
+
+// Release deletes the key reserved by a request which failed without a final outcome, so that it can be sent again
+func (is *IdempotencyService) Release(ctx context.Context, record *model.IdempotencyKey) error {
+	ctx, span := tracing.Start(ctx, "IdempotencyService.Release")
+	defer span.End()
+
+	return is.repository.Delete(ctx, model.IdempotencyKeyType, criteria.Eq("uuid", record.UUID))
+}
+
+func (is *IdempotencyService) find(ctx context.Context, merchantID, key string) (*model.IdempotencyKey, error) {
+	object, err := is.repository.GetBy(ctx, model.IdempotencyKeyType, criteria.And(
+		criteria.Eq("merchant_id", merchantID),
+		criteria.Eq("key", key),
+	))
+	if err != nil {
+		return nil, err
+	}
+	return object.(*model.IdempotencyKey), nil
+}
+
+// abandoned tells whether the request which reserved the key has not stored its response in time
+func (is *IdempotencyService) abandoned(record *model.IdempotencyKey) bool {
+	if record.Completed() || record.CreatedAt.IsZero() || is.settings.ReservationTimeout <= 0 {
+		return false
+	}
+	return time.Since(record.CreatedAt) > is.settings.ReservationTimeout
+}
+
+func checkIdempotencyKey(record *model.IdempotencyKey, requestHash string) (*model.IdempotencyKey, error) {
+	if record.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !record.Completed() {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return record, nil
+}
@@ -0,0 +1,176 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/storagefakes"
+)
+
+var _ = Describe("Idempotency service", func() {
+	var fakeStorage *storagefakes.FakeStorage
+	var idempotencyService *services.IdempotencyService
+
+	var completedKey *model.IdempotencyKey
+
+	BeforeEach(func() {
+		fakeStorage = &storagefakes.FakeStorage{}
+		idempotencyService = services.NewIdempotencyService(services.DefaultIdempotencySettings(), fakeStorage)
+
+		completedKey = &model.IdempotencyKey{
+			UUID:        "key-uuid",
+			Key:         "key",
+			MerchantID:  "1",
+			RequestHash: "hash",
+			StatusCode:  201,
+			Body:        []byte(`{"uuid":"some-uuid"}`),
+		}
+	})
+
+	Describe("Begin", func() {
+		When("key is not used yet", func() {
+			BeforeEach(func() {
+				fakeStorage.GetByReturns(nil, storage.ErrNotFound)
+				fakeStorage.CreateStub = func(_ context.Context, object model.Object) (model.Object, error) {
+					return object, nil
+				}
+			})
+
+			It("should reserve the key", func() {
+				record, err := idempotencyService.Begin(context.Background(), "1", "key", "hash")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(record.Completed()).To(BeFalse())
+				Expect(record.UUID).ToNot(BeEmpty())
+
+				Expect(fakeStorage.CreateCallCount()).To(Equal(1))
+				_, object := fakeStorage.CreateArgsForCall(0)
+				created := object.(*model.IdempotencyKey)
+				Expect(created.Key).To(Equal("key"))
+				Expect(created.MerchantID).To(Equal("1"))
+				Expect(created.RequestHash).To(Equal("hash"))
+			})
+		})
+
+		When("key is used by a completed request", func() {
+			BeforeEach(func() {
+				fakeStorage.GetByReturns(completedKey, nil)
+			})
+
+			It("should return the stored response for the same request", func() {
+				record, err := idempotencyService.Begin(context.Background(), "1", "key", "hash")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(record).To(Equal(completedKey))
+				Expect(fakeStorage.CreateCallCount()).To(Equal(0))
+			})
+
+			It("should fail for a different request", func() {
+				_, err := idempotencyService.Begin(context.Background(), "1", "key", "other-hash")
+				Expect(err).To(Equal(services.ErrIdempotencyKeyReused))
+			})
+		})
+
+		When("key is used by a request in progress", func() {
+			BeforeEach(func() {
+				completedKey.StatusCode = 0
+				completedKey.Body = nil
+				fakeStorage.GetByReturns(completedKey, nil)
+			})
+
+			It("should fail", func() {
+				_, err := idempotencyService.Begin(context.Background(), "1", "key", "hash")
+				Expect(err).To(Equal(services.ErrIdempotencyKeyInProgress))
+			})
+		})
+
+		When("key is reserved by a request which did not store its response in time", func() {
+			BeforeEach(func() {
+				completedKey.StatusCode = 0
+				completedKey.Body = nil
+				completedKey.CreatedAt = time.Now().Add(-services.DefaultIdempotencySettings().ReservationTimeout - time.Second)
+				fakeStorage.GetByReturns(completedKey, nil)
+				fakeStorage.CreateStub = func(_ context.Context, object model.Object) (model.Object, error) {
+					return object, nil
+				}
+			})
+
+			It("should reserve the key again", func() {
+				record, err := idempotencyService.Begin(context.Background(), "1", "key", "other-hash")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(record.Completed()).To(BeFalse())
+				Expect(record.UUID).ToNot(Equal(completedKey.UUID))
+				Expect(record.RequestHash).To(Equal("other-hash"))
+
+				Expect(fakeStorage.DeleteCallCount()).To(Equal(1))
+				_, typee, c := fakeStorage.DeleteArgsForCall(0)
+				Expect(typee).To(Equal(model.IdempotencyKeyType))
+				Expect(c).To(Equal(criteria.Eq("uuid", completedKey.UUID)))
+				Expect(fakeStorage.CreateCallCount()).To(Equal(1))
+			})
+		})
+
+		When("key is reserved concurrently", func() {
+			BeforeEach(func() {
+				fakeStorage.GetByReturnsOnCall(0, nil, storage.ErrNotFound)
+				fakeStorage.GetByReturnsOnCall(1, completedKey, nil)
+				fakeStorage.CreateReturns(nil, errors.New("unique constraint violated"))
+			})
+
+			It("should use the concurrently stored key", func() {
+				record, err := idempotencyService.Begin(context.Background(), "1", "key", "hash")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(record).To(Equal(completedKey))
+			})
+		})
+
+		When("storage fails", func() {
+			BeforeEach(func() {
+				fakeStorage.GetByReturns(nil, errors.New("connection refused"))
+			})
+
+			It("should return the error", func() {
+				_, err := idempotencyService.Begin(context.Background(), "1", "key", "hash")
+				Expect(err).Should(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("connection refused"))
+			})
+		})
+	})
+
+	Describe("Complete", func() {
+		It("should store the response", func() {
+			record := &model.IdempotencyKey{
+				UUID:        "key-uuid",
+				Key:         "key",
+				MerchantID:  "1",
+				RequestHash: "hash",
+			}
+			err := idempotencyService.Complete(context.Background(), record, 400, []byte(`{"status":400}`))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(fakeStorage.SaveCallCount()).To(Equal(1))
+			_, object := fakeStorage.SaveArgsForCall(0)
+			saved := object.(*model.IdempotencyKey)
+			Expect(saved.Completed()).To(BeTrue())
+			Expect(saved.StatusCode).To(Equal(400))
+			Expect(saved.Body).To(Equal([]byte(`{"status":400}`)))
+		})
+	})
+
+	Describe("Release", func() {
+		It("should delete the reserved key", func() {
+			err := idempotencyService.Release(context.Background(), &model.IdempotencyKey{UUID: "key-uuid"})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(fakeStorage.DeleteCallCount()).To(Equal(1))
+			_, typee, c := fakeStorage.DeleteArgsForCall(0)
+			Expect(typee).To(Equal(model.IdempotencyKeyType))
+			Expect(c).To(Equal(criteria.Eq("uuid", "key-uuid")))
+		})
+	})
+})
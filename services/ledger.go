@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
+)
+
+// ErrInvalidStatementPeriod is returned when the start of a statement period is not before its end
+var ErrInvalidStatementPeriod = errors.New("the start of the statement period should be before its end")
+
+type LedgerSettings struct {
+	// FeeBasisPoints is the fee taken from every charge in hundredths of a percent, e.g. 150 takes 1.5%
+	FeeBasisPoints int64 `mapstructure:"fee_basis_points"`
+}
+
+func DefaultLedgerSettings() *LedgerSettings {
+	return &LedgerSettings{}
+}
+
+func (s *LedgerSettings) Keys() []string {
+	return []string{
+		"fee_basis_points",
+	}
+}
+
+// Fee returns the fee taken from a charge of the given amount, rounded down to the minor unit of its currency
+func (s *LedgerSettings) Fee(amount int64) int64 {
+	return amount * s.FeeBasisPoints / 10000
+}
+
+type LedgerService struct {
+	repository storage.Storage
+}
+
+func NewLedgerService(repository storage.Storage) *LedgerService {
+	return &LedgerService{
+		repository: repository,
+	}
+}
+
+// Statement returns the entries of the merchant posted in the period [from, to) together with the opening
+// and closing balances of its accounts
+func (ls *LedgerService) Statement(ctx context.Context, merchantID string, from, to time.Time) (*model.Statement, error) {
+	ctx, span := tracing.Start(ctx, "LedgerService.Statement")
+	defer span.End()
+
+	if !from.Before(to) {
+		return nil, ErrInvalidStatementPeriod
+	}
+	if _, err := ls.repository.Get(ctx, model.MerchantType, merchantID); err != nil {
+		return nil, err
+	}
+	objects, err := ls.repository.List(ctx, model.LedgerEntryType, criteria.And(
+		criteria.Eq("merchant_id", merchantID),
+		criteria.Lt("created_at", to),
+	))
+	if err != nil {
+		return nil, err
+	}
+	entries := sortedEntries(objects)
+
+	type accountKey struct {
+		account  model.Account
+		currency model.Currency
+	}
+	accounts := make(map[accountKey]*model.AccountStatement)
+	result := &model.Statement{
+		MerchantID: merchantID,
+		From:       from,
+		To:         to,
+		Accounts:   make([]*model.AccountStatement, 0),
+		Entries:    make([]*model.LedgerEntry, 0),
+	}
+	for _, entry := range entries {
+		key := accountKey{account: entry.Account, currency: entry.Currency}
+		account, found := accounts[key]
+		if !found {
+			account = &model.AccountStatement{Account: entry.Account, Currency: entry.Currency}
+			accounts[key] = account
+			result.Accounts = append(result.Accounts, account)
+		}
+
+		if entry.CreatedAt.Before(from) {
+			account.Opening += entry.Change()
+			continue
+		}
+		if entry.Direction == model.Debit {
+			account.Debits += entry.Amount
+		} else {
+			account.Credits += entry.Amount
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	for _, account := range result.Accounts {
+		account.Closing = account.Opening + account.Credits - account.Debits
+	}
+	sort.Slice(result.Accounts, func(i, j int) bool {
+		a, b := result.Accounts[i], result.Accounts[j]
+		if a.Account != b.Account {
+			return accountOrder(a.Account) < accountOrder(b.Account)
+		}
+		return a.Currency < b.Currency
+	})
+	return result, nil
+}
+
+// Verify compares the total transaction sum of the merchant with the balance of its available account in the ledger
+func (ls *LedgerService) Verify(ctx context.Context, merchantID string) (*model.BalanceVerification, error) {
+	ctx, span := tracing.Start(ctx, "LedgerService.Verify")
+	defer span.End()
+
+	var result *model.BalanceVerification
+	// The merchant is locked, so that no payment changes its balance while the entries are summed
+	err := ls.repository.Transaction(ctx, func(tx storage.Storage) error {
+		object, err := tx.GetForUpdate(ctx, model.MerchantType, merchantID)
+		if err != nil {
+			return err
+		}
+		merchant := object.(*model.Merchant)
+
+		entries, err := tx.List(ctx, model.LedgerEntryType, criteria.And(
+			criteria.Eq("merchant_id", merchantID),
+			criteria.Eq("account", model.MerchantAvailableAccount),
+		))
+		if err != nil {
+			return err
+		}
+		ledgerBalance := model.Balance{}
+		for _, entry := range entries {
+			ledgerBalance.Add(entry.(*model.LedgerEntry).Currency, entry.(*model.LedgerEntry).Change())
+		}
+
+		result = &model.BalanceVerification{
+			MerchantID:          merchantID,
+			TotalTransactionSum: merchant.TotalTransactionSum,
+			LedgerBalance:       ledgerBalance,
+			Verified:            sameBalances(merchant.TotalTransactionSum, ledgerBalance),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Verified {
+		log.C(ctx).Warnf("Total transaction sum %v of merchant %s does not match its ledger balance %v",
+			result.TotalTransactionSum, merchantID, result.LedgerBalance)
+	}
+	return result, nil
+}
+
+// PostOpeningBalances posts the total transaction sums of the merchants which have no ledger entries yet to their
+// available accounts. The merchants created before the ledger have sums without entries, which would not match
+// their ledger balances otherwise. The sums are not changed and merchants with entries are skipped, so it can be
+// called on every start.
+func (ls *LedgerService) PostOpeningBalances(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "LedgerService.PostOpeningBalances")
+	defer span.End()
+
+	merchants, err := ls.repository.List(ctx, model.MerchantType, nil)
+	if err != nil {
+		return fmt.Errorf("could not list merchants: %s", err)
+	}
+	for _, object := range merchants {
+		merchantID := object.(*model.Merchant).UUID
+		err := ls.repository.Transaction(ctx, func(tx storage.Storage) error {
+			object, err := tx.GetForUpdate(ctx, model.MerchantType, merchantID)
+			if err != nil {
+				return err
+			}
+			merchant := object.(*model.Merchant)
+			count, err := tx.Count(ctx, model.LedgerEntryType, criteria.Eq("merchant_id", merchantID))
+			if err != nil || count > 0 {
+				return err
+			}
+			for currency, sum := range merchant.TotalTransactionSum {
+				from, to, amount := model.OpeningBalanceAccount, model.MerchantAvailableAccount, sum
+				if sum < 0 {
+					from, to, amount = to, from, -sum
+				}
+				if _, err := postEntries(ctx, tx, merchant.UUID, "", currency, from, to, amount); err != nil {
+					return err
+				}
+				log.C(ctx).Infof("Posted opening balance %d %s of merchant %s", sum, currency, merchantID)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not post opening balance of merchant %s: %s", merchantID, err)
+		}
+	}
+	return nil
+}
+
+// postTransfer posts the amount from one account to another for the transaction. The first account is debited
+// and the second one is credited. Changes of the available account of the merchant are added to its total
+// transaction sum, which is a projection of that account, so the merchant should be saved afterwards.
+// It should be called with the storage transaction which changes the transaction, so that the entries are
+// posted only if the change is.
+func postTransfer(ctx context.Context, tx storage.Storage, merchant *model.Merchant, transaction *model.Transaction, from, to model.Account, amount int64) error {
+	entries, err := postEntries(ctx, tx, merchant.UUID, transaction.UUID, transaction.Currency, from, to, amount)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Account == model.MerchantAvailableAccount {
+			merchant.TotalTransactionSum.Add(entry.Currency, entry.Change())
+		}
+	}
+	return nil
+}
+
+// postEntries posts the debit of the first account and the credit of the second one
+func postEntries(ctx context.Context, tx storage.Storage, merchantID, transactionID string, currency model.Currency, from, to model.Account, amount int64) ([]*model.LedgerEntry, error) {
+	if amount == 0 {
+		return nil, nil
+	}
+	postingID, err := uuid.NewV4()
+	if err != nil {
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
+		return nil, errors.New("could not generate UUID")
+	}
+
+	now := time.Now()
+	entries := []*model.LedgerEntry{
+		{Account: from, Direction: model.Debit},
+		{Account: to, Direction: model.Credit},
+	}
+	for _, entry := range entries {
+		UUID, err := uuid.NewV4()
+		if err != nil {
+			log.C(ctx).Errorf("Could not generate UUID: %s", err)
+			return nil, errors.New("could not generate UUID")
+		}
+		entry.UUID = UUID.String()
+		entry.PostingID = postingID.String()
+		entry.TransactionID = transactionID
+		entry.MerchantID = merchantID
+		entry.Amount = amount
+		entry.Currency = currency
+		entry.CreatedAt = now
+		if err := entry.Validate(); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Create(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// sortedEntries orders the entries by the time they were posted. The debit of a posting comes before its credit.
+func sortedEntries(objects []model.Object) []*model.LedgerEntry {
+	entries := make([]*model.LedgerEntry, 0, len(objects))
+	for _, object := range objects {
+		entries = append(entries, object.(*model.LedgerEntry))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		if a.PostingID != b.PostingID {
+			return a.PostingID < b.PostingID
+		}
+		return a.Direction == model.Debit && b.Direction == model.Credit
+	})
+	return entries
+}
+
+func accountOrder(account model.Account) int {
+	for i, a := range model.Accounts {
+		if a == account {
+			return i
+		}
+	}
+	return len(model.Accounts)
+}
+
+// sameBalances compares two balances, treating missing currencies as zero sums
+func sameBalances(a, b model.Balance) bool {
+	for currency, sum := range a {
+		if b[currency] != sum {
+			return false
+		}
+	}
+	for currency, sum := range b {
+		if a[currency] != sum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package services_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("Ledger", func() {
+	var repository *memory.Storage
+	var paymentService *services.PaymentService
+	var ledgerService *services.LedgerService
+	var merchant *model.Merchant
+	var start time.Time
+
+	createTransaction := func(transaction *model.Transaction) string {
+		transaction.CustomerEmail = "user@customer.com"
+		transaction.MerchantID = merchant.UUID
+		object, err := paymentService.Create(context.Background(), transaction, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+		return object.(*model.Transaction).UUID
+	}
+
+	accountStatement := func(statement *model.Statement, account model.Account) *model.AccountStatement {
+		for _, a := range statement.Accounts {
+			if a.Account == account {
+				return a
+			}
+		}
+		Fail("no statement of account " + string(account))
+		return nil
+	}
+
+	BeforeEach(func() {
+		start = time.Now()
+		repository = memory.New()
+		// 10% fee
+		paymentService = services.NewPaymentService(repository, &services.LedgerSettings{FeeBasisPoints: 1000}, services.DefaultAuthorizationSettings(), nil)
+		ledgerService = services.NewLedgerService(repository)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+
+		authorizationID := createTransaction(&model.Transaction{Type: model.Authorize, Currency: model.EUR, Amount: 100})
+		chargeID := createTransaction(&model.Transaction{Type: model.Charge, Amount: 60, FinalCapture: true, DependsOnUUID: authorizationID})
+		createTransaction(&model.Transaction{Type: model.Refund, Amount: 20, DependsOnUUID: chargeID})
+	})
+
+	It("should post balanced entries", func() {
+		entries, err := repository.List(context.Background(), model.LedgerEntryType, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		// authorization, charge, fee, release of the remaining amount and refund
+		Expect(entries).To(HaveLen(10))
+
+		sums := make(map[string]int64)
+		for _, object := range entries {
+			entry := object.(*model.LedgerEntry)
+			sums[entry.PostingID] += entry.Change()
+		}
+		Expect(sums).To(HaveLen(5))
+		for _, sum := range sums {
+			Expect(sum).To(BeZero())
+		}
+	})
+
+	It("should keep the total transaction sum as a projection of the available account", func() {
+		object, err := repository.Get(context.Background(), model.MerchantType, merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Merchant).TotalTransactionSum).To(Equal(model.Balance{model.EUR: 34}))
+
+		verification, err := ledgerService.Verify(context.Background(), merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(verification.Verified).To(BeTrue())
+		Expect(verification.LedgerBalance).To(Equal(model.Balance{model.EUR: 34}))
+	})
+
+	It("should detect balances which do not match the ledger", func() {
+		object, err := repository.Get(context.Background(), model.MerchantType, merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		object.(*model.Merchant).TotalTransactionSum.Add(model.EUR, 1)
+		Expect(repository.Save(context.Background(), object)).To(Succeed())
+
+		verification, err := ledgerService.Verify(context.Background(), merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(verification.Verified).To(BeFalse())
+		Expect(verification.TotalTransactionSum).To(Equal(model.Balance{model.EUR: 35}))
+	})
+
+	It("should derive the balances of the statement from the entries", func() {
+		statement, err := ledgerService.Statement(context.Background(), merchant.UUID, start, time.Now().Add(time.Second))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(statement.Entries).To(HaveLen(10))
+		Expect(statement.Accounts).To(HaveLen(4))
+		Expect(statement.Accounts[0]).To(Equal(&model.AccountStatement{
+			Account:  model.MerchantAvailableAccount,
+			Currency: model.EUR,
+			Debits:   26,
+			Credits:  60,
+			Closing:  34,
+		}))
+		Expect(accountStatement(statement, model.MerchantPendingAccount).Closing).To(BeZero())
+		Expect(accountStatement(statement, model.CustomerFundsAccount).Closing).To(Equal(int64(-40)))
+		Expect(accountStatement(statement, model.FeesAccount).Closing).To(Equal(int64(6)))
+	})
+
+	It("should carry the earlier entries into the opening balances", func() {
+		statement, err := ledgerService.Statement(context.Background(), merchant.UUID, time.Now().Add(time.Second), time.Now().Add(time.Hour))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(statement.Entries).To(BeEmpty())
+		available := accountStatement(statement, model.MerchantAvailableAccount)
+		Expect(available.Opening).To(Equal(int64(34)))
+		Expect(available.Closing).To(Equal(int64(34)))
+	})
+
+	It("should not return statements for invalid periods or missing merchants", func() {
+		_, err := ledgerService.Statement(context.Background(), merchant.UUID, time.Now(), start)
+		Expect(err).To(Equal(services.ErrInvalidStatementPeriod))
+
+		_, err = ledgerService.Statement(context.Background(), "missing", start, time.Now())
+		Expect(err).To(Equal(storage.ErrNotFound))
+	})
+
+	Context("when a merchant was created before the ledger", func() {
+		var oldMerchant *model.Merchant
+
+		BeforeEach(func() {
+			object, err := repository.Create(context.Background(), &model.Merchant{
+				UUID:                "old-merchant",
+				Name:                "old merchant",
+				Email:               "old@mail.com",
+				Status:              true,
+				TotalTransactionSum: model.Balance{model.EUR: 50, model.USD: -5},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			oldMerchant = object.(*model.Merchant)
+		})
+
+		It("should post its total transaction sum as the opening balance once", func() {
+			Expect(ledgerService.PostOpeningBalances(context.Background())).To(Succeed())
+			Expect(ledgerService.PostOpeningBalances(context.Background())).To(Succeed())
+
+			verification, err := ledgerService.Verify(context.Background(), oldMerchant.UUID)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(verification.Verified).To(BeTrue())
+			Expect(verification.TotalTransactionSum).To(Equal(model.Balance{model.EUR: 50, model.USD: -5}))
+
+			statement, err := ledgerService.Statement(context.Background(), oldMerchant.UUID, start, time.Now().Add(time.Second))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(statement.Entries).To(HaveLen(4))
+			Expect(accountStatement(statement, model.OpeningBalanceAccount).Closing).To(Equal(int64(-50)))
+		})
+
+		It("should not post opening balances of merchants with ledger entries", func() {
+			Expect(ledgerService.PostOpeningBalances(context.Background())).To(Succeed())
+
+			statement, err := ledgerService.Statement(context.Background(), merchant.UUID, start, time.Now().Add(time.Second))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(statement.Entries).To(HaveLen(10))
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("Services with the memory storage", func() {
+	var repository *memory.Storage
+	var paymentService *services.PaymentService
+	var merchant *model.Merchant
+
+	BeforeEach(func() {
+		repository = memory.New()
+		paymentService = services.NewPaymentService(repository, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+	})
+
+	It("should charge authorizations partially and reverse the remaining amount once", func() {
+		authorization, err := paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Authorize,
+			Currency:      model.EUR,
+			Amount:        10,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    merchant.UUID,
+		}, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+		authorizationID := authorization.(*model.Transaction).UUID
+
+		_, err = paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Charge,
+			Amount:        4,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    merchant.UUID,
+			DependsOnUUID: authorizationID,
+		}, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		object, err := repository.Get(context.Background(), model.MerchantType, merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Merchant).TotalTransactionSum[model.EUR]).To(Equal(int64(4)))
+
+		object, err = repository.Get(context.Background(), model.TransactionObjectType, authorizationID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Transaction).CapturedAmount).To(Equal(4))
+
+		reversal := &model.Transaction{
+			Type:          model.Reversal,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    merchant.UUID,
+			DependsOnUUID: authorizationID,
+		}
+		_, err = paymentService.Create(context.Background(), reversal, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(reversal.Status).To(Equal(model.Approved))
+
+		object, err = repository.Get(context.Background(), model.TransactionObjectType, authorizationID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Transaction).Status).To(Equal(model.Reversed))
+		Expect(object.(*model.Transaction).CapturedAmount).To(Equal(4))
+
+		object, err = repository.Get(context.Background(), model.MerchantType, merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Merchant).TotalTransactionSum[model.EUR]).To(Equal(int64(4)))
+
+		_, err = paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Reversal,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    merchant.UUID,
+			DependsOnUUID: authorizationID,
+		}, "user")
+		Expect(err).To(MatchError("the parent transaction is already followed"))
+	})
+})
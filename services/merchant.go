@@ -1,15 +1,23 @@
 package services
 
 import (
+	"context"
 	"errors"
-	"log"
 
 	"github.com/gofrs/uuid"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
-	"github.com/pankrator/payment/query"
 	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
 )
 
+// ErrMerchantHasTransactions is returned when deleting a merchant which still has transactions
+var ErrMerchantHasTransactions = errors.New("merchant still has transactions")
+
+// ErrMerchantHasLedgerEntries is returned when deleting a merchant which has ledger entries
+var ErrMerchantHasLedgerEntries = errors.New("merchant has ledger entries")
+
 type MerchantService struct {
 	repository storage.Storage
 }
@@ -20,30 +28,126 @@ func NewMerchantService(repository storage.Storage) *MerchantService {
 	}
 }
 
-func (ms *MerchantService) Create(merchant *model.Merchant) (model.Object, error) {
+func (ms *MerchantService) Create(ctx context.Context, merchant *model.Merchant) (model.Object, error) {
+	ctx, span := tracing.Start(ctx, "MerchantService.Create")
+	defer span.End()
+
 	if err := merchant.Validate(); err != nil {
 		return nil, err
 	}
 
 	UUID, err := uuid.NewV4()
 	if err != nil {
-		log.Printf("Could not generate UUID: %s", err)
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
 		return nil, errors.New("could not generate UUID")
 	}
 
 	merchant.UUID = UUID.String()
+	merchant.TotalTransactionSum = model.Balance{}
 
-	return ms.repository.Create(merchant)
+	return ms.repository.Create(ctx, merchant)
 }
 
-func (ms *MerchantService) Get(uuid string) (*model.Merchant, error) {
-	object, err := ms.repository.Get(model.MerchantType, uuid)
+func (ms *MerchantService) Get(ctx context.Context, uuid string) (*model.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "MerchantService.Get")
+	defer span.End()
+
+	object, err := ms.repository.Get(ctx, model.MerchantType, uuid)
 	if err != nil {
 		return nil, err
 	}
 	return object.(*model.Merchant), nil
 }
 
-func (ms *MerchantService) List(q []query.Query) ([]model.Object, error) {
-	return ms.repository.List(model.MerchantType, q...)
+func (ms *MerchantService) List(ctx context.Context, c criteria.Criterion) ([]model.Object, error) {
+	ctx, span := tracing.Start(ctx, "MerchantService.List")
+	defer span.End()
+
+	return ms.repository.List(ctx, model.MerchantType, c)
+}
+
+// Update replaces the name, description, email, status and authorization validity of the merchant with the given uuid
+func (ms *MerchantService) Update(ctx context.Context, uuid string, merchant *model.Merchant) (*model.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "MerchantService.Update")
+	defer span.End()
+
+	if err := merchant.Validate(); err != nil {
+		return nil, err
+	}
+
+	var result *model.Merchant
+	err := ms.repository.Transaction(ctx, func(tx storage.Storage) error {
+		object, err := tx.GetForUpdate(ctx, model.MerchantType, uuid)
+		if err != nil {
+			return err
+		}
+		result = object.(*model.Merchant)
+		result.Name = merchant.Name
+		result.Description = merchant.Description
+		result.Email = merchant.Email
+		result.Status = merchant.Status
+		result.AuthorizationValidity = merchant.AuthorizationValidity
+		return tx.Save(ctx, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Deactivate marks the merchant as inactive, so that it cannot create transactions any more
+func (ms *MerchantService) Deactivate(ctx context.Context, uuid string) (*model.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "MerchantService.Deactivate")
+	defer span.End()
+
+	var result *model.Merchant
+	err := ms.repository.Transaction(ctx, func(tx storage.Storage) error {
+		object, err := tx.GetForUpdate(ctx, model.MerchantType, uuid)
+		if err != nil {
+			return err
+		}
+		result = object.(*model.Merchant)
+		result.Status = false
+		return tx.Save(ctx, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Delete deletes the merchant with the given uuid. Merchants which still have transactions or ledger entries
+// cannot be deleted.
+func (ms *MerchantService) Delete(ctx context.Context, uuid string) error {
+	ctx, span := tracing.Start(ctx, "MerchantService.Delete")
+	defer span.End()
+
+	return ms.repository.Transaction(ctx, func(tx storage.Storage) error {
+		if _, err := tx.GetForUpdate(ctx, model.MerchantType, uuid); err != nil {
+			return err
+		}
+		count, err := tx.Count(ctx, model.TransactionObjectType, criteria.Eq("merchant_id", uuid))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrMerchantHasTransactions
+		}
+		count, err = tx.Count(ctx, model.ArchivedTransactionType, criteria.Eq("merchant_id", uuid))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrMerchantHasTransactions
+		}
+		// The ledger outlives the transactions, which are archived after a while
+		count, err = tx.Count(ctx, model.LedgerEntryType, criteria.Eq("merchant_id", uuid))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrMerchantHasLedgerEntries
+		}
+		return tx.Delete(ctx, model.MerchantType, criteria.Eq("uuid", uuid))
+	})
 }
@@ -0,0 +1,154 @@
+package services_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/storagefakes"
+)
+
+var _ = Describe("Merchant service", func() {
+	var fakeStorage *storagefakes.FakeStorage
+	var merchantService *services.MerchantService
+
+	var merchant *model.Merchant
+
+	BeforeEach(func() {
+		fakeStorage = &storagefakes.FakeStorage{}
+		fakeStorage.TransactionStub = func(_ context.Context, fs func(s storage.Storage) error) error {
+			return fs(fakeStorage)
+		}
+		merchantService = services.NewMerchantService(fakeStorage)
+
+		merchant = &model.Merchant{
+			UUID:                "1",
+			Status:              true,
+			Email:               "merchant@email.com",
+			Name:                "merchant",
+			Description:         "description",
+			TotalTransactionSum: model.Balance{model.EUR: 10},
+		}
+	})
+
+	Describe("Create", func() {
+		BeforeEach(func() {
+			fakeStorage.CreateStub = func(_ context.Context, object model.Object) (model.Object, error) {
+				return object, nil
+			}
+		})
+
+		It("should generate uuid", func() {
+			result, err := merchantService.Create(context.Background(), &model.Merchant{
+				Name:  "new",
+				Email: "new@email.com",
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.(*model.Merchant).UUID).ToNot(BeEmpty())
+		})
+
+		It("should fail without a valid email", func() {
+			_, err := merchantService.Create(context.Background(), &model.Merchant{
+				Name:  "new",
+				Email: "email",
+			})
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("merchant email is invalid"))
+			Expect(fakeStorage.CreateCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("Update", func() {
+		BeforeEach(func() {
+			fakeStorage.GetForUpdateReturns(merchant, nil)
+		})
+
+		It("should update only the editable fields", func() {
+			result, err := merchantService.Update(context.Background(), "1", &model.Merchant{
+				UUID:        "other",
+				Name:        "renamed",
+				Email:       "renamed@email.com",
+				Description: "new description",
+				Status:      false,
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).To(Equal(&model.Merchant{
+				UUID:                "1",
+				Name:                "renamed",
+				Email:               "renamed@email.com",
+				Description:         "new description",
+				Status:              false,
+				TotalTransactionSum: model.Balance{model.EUR: 10},
+			}))
+			_, saved := fakeStorage.SaveArgsForCall(0)
+			Expect(saved).To(Equal(result))
+		})
+
+		When("merchant does not exist", func() {
+			BeforeEach(func() {
+				fakeStorage.GetForUpdateReturns(nil, storage.ErrNotFound)
+			})
+
+			It("should return not found", func() {
+				_, err := merchantService.Update(context.Background(), "1", &model.Merchant{
+					Name:  "renamed",
+					Email: "renamed@email.com",
+				})
+				Expect(err).To(Equal(storage.ErrNotFound))
+				Expect(fakeStorage.SaveCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("Deactivate", func() {
+		BeforeEach(func() {
+			fakeStorage.GetForUpdateReturns(merchant, nil)
+		})
+
+		It("should mark the merchant as inactive", func() {
+			result, err := merchantService.Deactivate(context.Background(), "1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.Status).To(BeFalse())
+			_, saved := fakeStorage.SaveArgsForCall(0)
+			Expect(saved).To(Equal(result))
+		})
+	})
+
+	Describe("Delete", func() {
+		BeforeEach(func() {
+			fakeStorage.GetForUpdateReturns(merchant, nil)
+		})
+
+		It("should delete merchant without transactions", func() {
+			fakeStorage.CountReturns(0, nil)
+			err := merchantService.Delete(context.Background(), "1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fakeStorage.DeleteCallCount()).To(Equal(1))
+			_, typee, c := fakeStorage.DeleteArgsForCall(0)
+			Expect(typee).To(Equal(model.MerchantType))
+			Expect(c).To(Equal(criteria.Eq("uuid", "1")))
+		})
+
+		It("should not delete merchant with transactions", func() {
+			fakeStorage.CountReturns(2, nil)
+			err := merchantService.Delete(context.Background(), "1")
+			Expect(err).To(Equal(services.ErrMerchantHasTransactions))
+			Expect(fakeStorage.DeleteCallCount()).To(Equal(0))
+		})
+
+		It("should not delete merchant with ledger entries", func() {
+			fakeStorage.CountStub = func(_ context.Context, typee string, c criteria.Criterion) (int, error) {
+				if typee == model.LedgerEntryType {
+					return 4, nil
+				}
+				return 0, nil
+			}
+			err := merchantService.Delete(context.Background(), "1")
+			Expect(err).To(Equal(services.ErrMerchantHasLedgerEntries))
+			Expect(fakeStorage.DeleteCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -1,211 +1,589 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
+	"time"
 
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/metrics"
 	"github.com/pankrator/payment/query"
 
 	"github.com/gofrs/uuid"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
 )
 
+// ErrAuthorizationExpired is returned when charging an authorization whose validity has passed
+var ErrAuthorizationExpired error = &RejectedError{Reason: "the authorization has expired"}
+
+// RejectedError is returned when the requested transaction is not valid on its own or for its merchant and parent.
+// Unlike the other errors, e.g. of the storage, sending the same request again does not change it.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
+}
+
+func reject(format string, args ...interface{}) error {
+	return &RejectedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// SystemActor is the actor of the status changes made by the background jobs
+const SystemActor = "system"
+
 type PaymentService struct {
-	repository storage.Storage
+	repository            storage.Storage
+	ledgerSettings        *LedgerSettings
+	authorizationSettings *AuthorizationSettings
+	metrics               *metrics.Metrics
 }
 
-func NewPaymentService(repository storage.Storage) *PaymentService {
+func NewPaymentService(repository storage.Storage, ledgerSettings *LedgerSettings, authorizationSettings *AuthorizationSettings, metrics *metrics.Metrics) *PaymentService {
 	return &PaymentService{
-		repository: repository,
+		repository:            repository,
+		ledgerSettings:        ledgerSettings,
+		authorizationSettings: authorizationSettings,
+		metrics:               metrics,
 	}
 }
 
-func (ps *PaymentService) Create(transaction *model.Transaction) (model.Object, error) {
+// Create creates the transaction and changes the status of its parent. The actor is recorded in the status history.
+func (ps *PaymentService) Create(ctx context.Context, transaction *model.Transaction, actor string) (model.Object, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.Create")
+	defer span.End()
+
 	if err := transaction.Validate(); err != nil {
-		return nil, err
+		return nil, &RejectedError{Reason: err.Error()}
 	}
 
 	UUID, err := uuid.NewV4()
 	if err != nil {
-		log.Printf("Could not generate UUID: %s", err)
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
 		return nil, errors.New("could not generate UUID")
 	}
 
 	transaction.UUID = UUID.String()
 	transaction.Status = model.Approved
+	transaction.ExpiresAt = time.Time{}
+
+	var result model.Object
+	// The merchant and the parent transaction are locked in this order until the end of the transaction,
+	// so that concurrent requests for the same entities are serialized
+	err = ps.repository.Transaction(ctx, func(tx storage.Storage) error {
+		merchant, err := ps.lockActiveMerchant(ctx, tx, transaction)
+		if err != nil {
+			return err
+		}
+
+		var parentTransaction *model.Transaction
+		if transaction.Type != model.Authorize {
+			parentTransaction, err = ps.lockParentTransaction(ctx, tx, transaction)
+			if err != nil {
+				return err
+			}
+
+			// Authorizations and charges can be followed by several partial charges and refunds, but only by one reversal.
+			// A partially charged authorization can still be reversed, which releases the rest of the authorized amount.
+			if transaction.Type == model.Reversal {
+				count, err := tx.Count(ctx, model.TransactionObjectType, criteria.And(
+					criteria.Eq("depends_on_uuid", transaction.DependsOnUUID),
+					criteria.Eq("type", model.Reversal),
+				))
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					return reject("the parent transaction is already followed")
+				}
+			}
+
+			if transaction.Currency == "" {
+				transaction.Currency = parentTransaction.Currency
+			}
+			if err = ps.checkParentTransactionConditions(transaction, parentTransaction); err != nil {
+				return err
+			}
+
+			// Children which their parent does not accept in its current status are kept as errored
+			if !parentTransaction.Accepts(model.TriggerOf(transaction.Type)) {
+				transaction.Status = model.Errored
+			}
+		}
+
+		var parentStatus model.TransactionState
+		if parentTransaction != nil {
+			parentStatus = parentTransaction.Status
+		}
 
-	if err := ps.checkMerchantStatus(transaction); err != nil {
+		switch transaction.Type {
+		case model.Authorize:
+			result, err = ps.authorizeTransaction(ctx, tx, transaction, merchant)
+		case model.Charge:
+			result, err = ps.chargeTransaction(ctx, tx, transaction, parentTransaction, merchant, actor)
+		case model.Refund:
+			result, err = ps.refundTransaction(ctx, tx, transaction, parentTransaction, merchant, actor)
+		case model.Reversal:
+			result, err = ps.reverseTransaction(ctx, tx, transaction, parentTransaction, merchant, actor)
+		default:
+			err = reject("transaction type %s not recognized", transaction.Type)
+		}
+		if err != nil {
+			return err
+		}
+		if err := recordStatusChange(ctx, tx, transaction, "", model.CreationTrigger, "", actor); err != nil {
+			return err
+		}
+
+		if err := enqueueWebhookEvents(ctx, tx, model.TransactionCreatedEvent, result.(*model.Transaction)); err != nil {
+			return err
+		}
+		if parentTransaction != nil && parentTransaction.Status != parentStatus {
+			return enqueueWebhookEvents(ctx, tx, model.TransactionStatusChangedEvent, parentTransaction)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	var parentTransaction *model.Transaction
+	ps.metrics.ObserveTransaction(result.(*model.Transaction))
+	return result, nil
+}
+
+func (ps *PaymentService) List(ctx context.Context, c criteria.Criterion) ([]model.Object, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.List")
+	defer span.End()
+
+	return ps.repository.List(ctx, model.TransactionObjectType, c)
+}
+
+// ListPage lists a page of transactions and returns the cursor of the next page
+func (ps *PaymentService) ListPage(ctx context.Context, page query.Page, c criteria.Criterion) ([]model.Object, string, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.ListPage")
+	defer span.End()
 
-	// TODO: Do everything in one transaction, otherwise it might happen that two requests change dependent entities or so and
-	// might get into inconsistent state
-	if transaction.Type != model.Authorize {
-		count, err := ps.repository.Count(model.TransactionObjectType, "transaction_id = ?", transaction.DependsOnUUID)
+	return ps.repository.ListPage(ctx, model.TransactionObjectType, page, c)
+}
+
+// Chain returns the transaction matching the criterion together with its ancestors and descendants matching it
+func (ps *PaymentService) Chain(ctx context.Context, transactionID string, c criteria.Criterion) (*model.TransactionChain, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.Chain")
+	defer span.End()
+
+	object, err := ps.repository.GetBy(ctx, model.TransactionObjectType, criteria.And(c, criteria.Eq("uuid", transactionID)))
+	if err != nil {
+		return nil, err
+	}
+	transaction := object.(*model.Transaction)
+
+	ancestors := make([]*model.Transaction, 0)
+	for parentID := transaction.DependsOnUUID; parentID != ""; {
+		object, err := ps.repository.GetBy(ctx, model.TransactionObjectType, criteria.And(c, criteria.Eq("uuid", parentID)))
 		if err != nil {
+			if err == storage.ErrNotFound {
+				break
+			}
 			return nil, err
 		}
-		if count > 0 {
-			return nil, fmt.Errorf("the parent transaction is already followed")
+		parent := object.(*model.Transaction)
+		ancestors = append([]*model.Transaction{parent}, ancestors...)
+		parentID = parent.DependsOnUUID
+	}
+
+	descendants, err := listDescendants(ctx, ps.repository, []model.Object{transaction}, c)
+	if err != nil {
+		return nil, err
+	}
+	return &model.TransactionChain{
+		Transaction: transaction,
+		Ancestors:   ancestors,
+		Descendants: model.OrderDescendants(transaction, descendants),
+	}, nil
+}
+
+// History lists the status changes of the transaction matching the criterion in the order they were made
+func (ps *PaymentService) History(ctx context.Context, transactionID string, c criteria.Criterion) ([]*model.TransactionStatusChange, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.History")
+	defer span.End()
+
+	if _, err := ps.repository.GetBy(ctx, model.TransactionObjectType, criteria.And(c, criteria.Eq("uuid", transactionID))); err != nil {
+		return nil, err
+	}
+	objects, err := ps.repository.List(ctx, model.TransactionStatusChangeType, criteria.Eq("transaction_id", transactionID))
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*model.TransactionStatusChange, 0, len(objects))
+	for _, object := range objects {
+		changes = append(changes, object.(*model.TransactionStatusChange))
+	}
+	// The creation of a transaction and the changes which it causes to its parent are made at the same time
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.FromStatus == "" && b.FromStatus != ""
+	})
+	return changes, nil
+}
+
+// ListDescendants lists all transactions matching the criterion which depend directly or indirectly on the given ones
+func (ps *PaymentService) ListDescendants(ctx context.Context, transactions []model.Object, c criteria.Criterion) ([]model.Object, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.ListDescendants")
+	defer span.End()
+
+	return listDescendants(ctx, ps.repository, transactions, c)
+}
+
+func listDescendants(ctx context.Context, repository storage.Storage, transactions []model.Object, c criteria.Criterion) ([]model.Object, error) {
+	result := make([]model.Object, 0)
+	parents := transactions
+	for len(parents) > 0 {
+		parentIDs := make([]interface{}, 0, len(parents))
+		for _, parent := range parents {
+			parentIDs = append(parentIDs, parent.(*model.Transaction).UUID)
 		}
-		parentTransaction, err = ps.findParentTransaction(ps.repository, transaction)
+		children, err := repository.List(ctx, model.TransactionObjectType, criteria.And(c, criteria.OneOf("depends_on_uuid", parentIDs...)))
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, children...)
+		parents = children
+	}
+	return result, nil
+}
 
-		if err = ps.checkParentTransactionConditions(transaction, parentTransaction); err != nil {
-			return nil, err
-		}
+func (ps *PaymentService) authorizeTransaction(ctx context.Context, tx storage.Storage, transaction *model.Transaction, merchant *model.Merchant) (model.Object, error) {
+	validity := time.Duration(merchant.AuthorizationValidity)
+	if validity == 0 {
+		validity = ps.authorizationSettings.Validity
+	}
+	transaction.ExpiresAt = time.Now().Add(validity)
 
-		ps.updateStateBasedOnParent(transaction, parentTransaction)
+	result, err := tx.Create(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("database operation failed: %s", err)
 	}
 
-	switch transaction.Type {
-	case model.Authorize:
-		return ps.repository.Create(transaction)
-	case model.Charge:
-		return ps.chargeTransaction(transaction)
-	case model.Refund:
-		return ps.refundTransaction(transaction, parentTransaction)
-	case model.Reversal:
-		return ps.reverseTransaction(transaction, parentTransaction)
-	default:
-		return nil, fmt.Errorf("transaction type %s not recognized", transaction.Type)
+	// The authorized amount is held for the merchant until it is charged or released
+	if err := postTransfer(ctx, tx, merchant, transaction, model.CustomerFundsAccount, model.MerchantPendingAccount, int64(transaction.Amount)); err != nil {
+		return nil, err
 	}
+	return result, nil
 }
 
-func (ps *PaymentService) List(q []query.Query) ([]model.Object, error) {
-	return ps.repository.List(model.TransactionObjectType, q...)
-}
+func (ps *PaymentService) chargeTransaction(ctx context.Context, tx storage.Storage, transaction, parentTransaction *model.Transaction, merchant *model.Merchant, actor string) (model.Object, error) {
+	result, err := tx.Create(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("database operation failed: %s", err)
+	}
 
-func (ps *PaymentService) chargeTransaction(transaction *model.Transaction) (model.Object, error) {
-	var result model.Object
-	err := ps.repository.Transaction(func(tx storage.Storage) error {
-		var err error
-		result, err = tx.Create(transaction)
-		if err != nil {
-			return fmt.Errorf("database operation failed: %s", err)
+	if transaction.Status != model.Errored {
+		parentTransaction.CapturedAmount += transaction.Amount
+		status := model.Approved
+		if transaction.FinalCapture || parentTransaction.RemainingAmount() == 0 {
+			status = model.Captured
+		}
+		if err := changeStatus(ctx, tx, parentTransaction, status, transaction, actor); err != nil {
+			return nil, err
+		}
+		if err := tx.Save(ctx, parentTransaction); err != nil {
+			return nil, err
 		}
 
-		if transaction.Status != model.Errored {
-			object, err := tx.Get(model.MerchantType, transaction.MerchantID)
-			if err != nil {
-				return err
+		amount := int64(transaction.Amount)
+		if err := postTransfer(ctx, tx, merchant, transaction, model.MerchantPendingAccount, model.MerchantAvailableAccount, amount); err != nil {
+			return nil, err
+		}
+		if err := postTransfer(ctx, tx, merchant, transaction, model.MerchantAvailableAccount, model.FeesAccount, ps.ledgerSettings.Fee(amount)); err != nil {
+			return nil, err
+		}
+		// The final capture releases the part of the authorized amount which is not charged
+		if parentTransaction.Status == model.Captured {
+			remaining := int64(parentTransaction.RemainingAmount())
+			if err := postTransfer(ctx, tx, merchant, transaction, model.MerchantPendingAccount, model.CustomerFundsAccount, remaining); err != nil {
+				return nil, err
 			}
-			merchant := object.(*model.Merchant)
-			merchant.TotalTransactionSum += int64(transaction.Amount)
-			return tx.Save(merchant)
 		}
-		return nil
-	})
+		if err := tx.Save(ctx, merchant); err != nil {
+			return nil, err
+		}
+	}
 
-	return result, err
+	return result, nil
 }
 
-func (ps *PaymentService) refundTransaction(transaction, parentTransaction *model.Transaction) (model.Object, error) {
-	var result model.Object
-	err := ps.repository.Transaction(func(tx storage.Storage) error {
-		var err error
-		result, err = tx.Create(transaction)
-		if err != nil {
-			return fmt.Errorf("database operation failed: %s", err)
+func (ps *PaymentService) refundTransaction(ctx context.Context, tx storage.Storage, transaction, parentTransaction *model.Transaction, merchant *model.Merchant, actor string) (model.Object, error) {
+	result, err := tx.Create(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("database operation failed: %s", err)
+	}
+
+	if transaction.Status != model.Errored {
+		parentTransaction.RefundedAmount += transaction.Amount
+		status := model.PartiallyRefunded
+		if parentTransaction.RefundableAmount() == 0 {
+			status = model.Refunded
+		}
+		if err := changeStatus(ctx, tx, parentTransaction, status, transaction, actor); err != nil {
+			return nil, err
+		}
+		if err := tx.Save(ctx, parentTransaction); err != nil {
+			return nil, err
 		}
 
-		if transaction.Status != model.Errored {
-			parentTransaction.Status = model.Refunded
-			if err := tx.Save(parentTransaction); err != nil {
-				return err
-			}
+		if err := postTransfer(ctx, tx, merchant, transaction, model.MerchantAvailableAccount, model.CustomerFundsAccount, int64(transaction.Amount)); err != nil {
+			return nil, err
+		}
+		if err := tx.Save(ctx, merchant); err != nil {
+			return nil, err
+		}
+	}
 
-			object, err := tx.Get(model.MerchantType, transaction.MerchantID)
-			if err != nil {
-				return err
-			}
+	return result, nil
+}
+
+func (ps *PaymentService) reverseTransaction(ctx context.Context, tx storage.Storage, transaction, parentTransaction *model.Transaction, merchant *model.Merchant, actor string) (model.Object, error) {
+	result, err := tx.Create(ctx, transaction)
+	if err != nil {
+		return nil, fmt.Errorf("database operation failed: %s", err)
+	}
 
-			merchant := object.(*model.Merchant)
-			merchant.TotalTransactionSum -= int64(transaction.Amount)
-			return tx.Save(merchant)
+	// The reversal releases the part of the authorized amount which is not charged
+	if transaction.Status != model.Errored {
+		remaining := int64(parentTransaction.RemainingAmount())
+		if err := postTransfer(ctx, tx, merchant, transaction, model.MerchantPendingAccount, model.CustomerFundsAccount, remaining); err != nil {
+			return nil, err
 		}
 
-		return nil
-	})
-	return result, err
+		if err := changeStatus(ctx, tx, parentTransaction, model.Reversed, transaction, actor); err != nil {
+			return nil, err
+		}
+		if err := tx.Save(ctx, parentTransaction); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
-func (ps *PaymentService) reverseTransaction(transaction, parentTransaction *model.Transaction) (model.Object, error) {
-	var result model.Object
-	err := ps.repository.Transaction(func(tx storage.Storage) error {
-		var err error
-		result, err = tx.Create(transaction)
+// ExpireAuthorizations voids the approved authorizations whose validity has passed and returns how many were voided.
+// A reversal of the part which is not charged is created for every voided authorization.
+func (ps *PaymentService) ExpireAuthorizations(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.ExpireAuthorizations")
+	defer span.End()
+
+	now := time.Now()
+	candidates, err := ps.repository.List(ctx, model.TransactionObjectType, criteria.And(
+		criteria.Eq("type", model.Authorize),
+		criteria.Eq("status", model.Approved),
+		criteria.Or(
+			criteria.Le("expires_at", now),
+			// Authorizations created before they had expiry times expire after the default validity
+			criteria.And(criteria.Null("expires_at"), criteria.Le("created_at", now.Add(-ps.authorizationSettings.Validity))),
+		),
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, candidate := range candidates {
+		expired, err := ps.expireAuthorization(ctx, candidate.(*model.Transaction), now)
+		if err != nil {
+			// The other authorizations are still expired. This one is tried again the next time.
+			log.C(ctx).Errorf("Could not expire authorization %s: %s", candidate.(*model.Transaction).UUID, err)
+			continue
+		}
+		if expired {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (ps *PaymentService) expireAuthorization(ctx context.Context, candidate *model.Transaction, now time.Time) (bool, error) {
+	UUID, err := uuid.NewV4()
+	if err != nil {
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
+		return false, errors.New("could not generate UUID")
+	}
+
+	expired := false
+	// The merchant and the authorization are locked in the same order as when creating transactions
+	err = ps.repository.Transaction(ctx, func(tx storage.Storage) error {
+		object, err := tx.GetForUpdate(ctx, model.MerchantType, candidate.MerchantID)
+		if err != nil {
+			return err
+		}
+		merchant := object.(*model.Merchant)
+		object, err = tx.GetForUpdate(ctx, model.TransactionObjectType, candidate.UUID)
+		if err != nil {
+			return err
+		}
+		authorization := object.(*model.Transaction)
+		// The authorization may have been charged in full or reversed in the meantime
+		if authorization.Status != model.Approved || !ps.isExpired(authorization, now) {
+			return nil
+		}
+
+		result, err := tx.Create(ctx, &model.Transaction{
+			UUID:          UUID.String(),
+			Type:          model.Reversal,
+			Amount:        authorization.RemainingAmount(),
+			Currency:      authorization.Currency,
+			CustomerEmail: authorization.CustomerEmail,
+			CustomerPhone: authorization.CustomerPhone,
+			Status:        model.Approved,
+			DependsOnUUID: authorization.UUID,
+			MerchantID:    authorization.MerchantID,
+		})
 		if err != nil {
 			return fmt.Errorf("database operation failed: %s", err)
 		}
+		reversal := result.(*model.Transaction)
+		if err := recordStatusChange(ctx, tx, reversal, "", model.CreationTrigger, "", SystemActor); err != nil {
+			return err
+		}
+		if err := postTransfer(ctx, tx, merchant, reversal, model.MerchantPendingAccount, model.CustomerFundsAccount, int64(authorization.RemainingAmount())); err != nil {
+			return err
+		}
 
-		parentTransaction.Status = model.Reversed
-		return tx.Save(parentTransaction)
+		if err := authorization.TransitionTo(model.Expired, model.ExpiryTrigger); err != nil {
+			return err
+		}
+		if err := recordStatusChange(ctx, tx, authorization, model.Approved, model.ExpiryTrigger, reversal.UUID, SystemActor); err != nil {
+			return err
+		}
+		if err := tx.Save(ctx, authorization); err != nil {
+			return err
+		}
+
+		if err := enqueueWebhookEvents(ctx, tx, model.TransactionCreatedEvent, reversal); err != nil {
+			return err
+		}
+		if err := enqueueWebhookEvents(ctx, tx, model.TransactionStatusChangedEvent, authorization); err != nil {
+			return err
+		}
+		expired = true
+		return nil
 	})
-	return result, err
+	return expired, err
+}
+
+// isExpired tells whether the validity of the authorization has passed at the given time
+func (ps *PaymentService) isExpired(authorization *model.Transaction, now time.Time) bool {
+	expiresAt := authorization.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = authorization.CreatedAt.Add(ps.authorizationSettings.Validity)
+	}
+	return !now.Before(expiresAt)
 }
 
 func (ps *PaymentService) checkParentTransactionConditions(transaction *model.Transaction, parent *model.Transaction) error {
+	if transaction.Currency != parent.Currency {
+		return reject("currency %s does not match the parent transaction currency %s", transaction.Currency, parent.Currency)
+	}
+	if parentType, found := model.ParentType(transaction.Type); found && parent.Type != parentType {
+		return reject("parent transaction should be of type %s", parentType)
+	}
+	accepted := parent.Accepts(model.TriggerOf(transaction.Type))
 	switch transaction.Type {
-	case model.Reversal:
-		fallthrough
 	case model.Charge:
-		if parent.Type != model.Authorize {
-			return fmt.Errorf("parent transaction should be of type %s", model.Authorize)
+		if parent.Status == model.Expired || ps.isExpired(parent, time.Now()) {
+			return ErrAuthorizationExpired
+		}
+		if accepted && transaction.Amount > parent.RemainingAmount() {
+			return reject("amount exceeds the remaining authorized amount: %d", parent.RemainingAmount())
 		}
 	case model.Refund:
-		if parent.Type != model.Charge {
-			return fmt.Errorf("parent transaction should be of type %s", model.Charge)
+		if accepted && transaction.Amount > parent.RefundableAmount() {
+			return reject("amount exceeds the refundable amount: %d", parent.RefundableAmount())
 		}
 	}
+	return nil
+}
 
-	if transaction.Type != model.Reversal && parent.Amount != transaction.Amount {
-		return fmt.Errorf("amount the two transactions is different, but it should be: %d", parent.Amount)
+// changeStatus moves the parent transaction to the status through the state machine because of its child
+// and records the change, if the status is a different one. The parent should be saved afterwards.
+func changeStatus(ctx context.Context, tx storage.Storage, parent *model.Transaction, status model.TransactionState, child *model.Transaction, actor string) error {
+	from := parent.Status
+	if err := parent.TransitionTo(status, model.TriggerOf(child.Type)); err != nil {
+		return err
 	}
-	return nil
+	if from == status {
+		return nil
+	}
+	return recordStatusChange(ctx, tx, parent, from, model.TriggerOf(child.Type), child.UUID, actor)
 }
 
-func (ps *PaymentService) updateStateBasedOnParent(transaction *model.Transaction, parent *model.Transaction) {
-	switch transaction.Type {
-	case model.Reversal:
-		fallthrough
-	case model.Charge:
-		if parent.Status != model.Approved {
-			transaction.Status = model.Errored
-		}
-	case model.Refund:
-		if parent.Status != model.Approved {
-			transaction.Status = model.Errored
-		}
+// recordStatusChange adds the change of the transaction from a status to its current one to its status history.
+// The status is empty for the creation of the transaction.
+func recordStatusChange(ctx context.Context, tx storage.Storage, transaction *model.Transaction, from model.TransactionState, trigger model.Trigger, causeID, actor string) error {
+	UUID, err := uuid.NewV4()
+	if err != nil {
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
+		return errors.New("could not generate UUID")
+	}
+	change := &model.TransactionStatusChange{
+		UUID:          UUID.String(),
+		TransactionID: transaction.UUID,
+		FromStatus:    from,
+		ToStatus:      transaction.Status,
+		Trigger:       trigger,
+		CauseID:       causeID,
+		Actor:         actor,
+		CreatedAt:     time.Now(),
 	}
+	if err := change.Validate(); err != nil {
+		return err
+	}
+	if _, err := tx.Create(ctx, change); err != nil {
+		return fmt.Errorf("database operation failed: %s", err)
+	}
+	return nil
 }
 
-func (ps *PaymentService) checkMerchantStatus(transaction *model.Transaction) error {
-	object, err := ps.repository.Get(model.MerchantType, transaction.MerchantID)
+func (ps *PaymentService) lockActiveMerchant(ctx context.Context, tx storage.Storage, transaction *model.Transaction) (*model.Merchant, error) {
+	object, err := tx.GetForUpdate(ctx, model.MerchantType, transaction.MerchantID)
 	if err != nil {
 		if err == storage.ErrNotFound {
-			return fmt.Errorf("merchant with id %s not found", transaction.MerchantID)
+			return nil, reject("merchant with id %s not found", transaction.MerchantID)
 		}
-		return err
+		return nil, err
 	}
 	merchant := object.(*model.Merchant)
 	if !merchant.Status {
-		return fmt.Errorf("merchant with name %s is not active", merchant.Name)
+		return nil, reject("merchant with name %s is not active", merchant.Name)
 	}
-	return nil
+	return merchant, nil
 }
 
-func (ps *PaymentService) findParentTransaction(repository storage.Storage, transaction *model.Transaction) (*model.Transaction, error) {
-	object, err := repository.Get(model.TransactionObjectType, transaction.DependsOnUUID)
+// lockParentTransaction locks the transaction which the given one depends on. Transactions of other merchants are
+// not found. They are looked up before they are locked, so that rows of other merchants are never locked.
+func (ps *PaymentService) lockParentTransaction(ctx context.Context, tx storage.Storage, transaction *model.Transaction) (*model.Transaction, error) {
+	notFound := reject("parent transaction with uuid %s not found", transaction.DependsOnUUID)
+	_, err := tx.GetBy(ctx, model.TransactionObjectType, criteria.And(
+		criteria.Eq("uuid", transaction.DependsOnUUID),
+		criteria.Eq("merchant_id", transaction.MerchantID),
+	))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	object, err := tx.GetForUpdate(ctx, model.TransactionObjectType, transaction.DependsOnUUID)
 	if err != nil {
 		if err == storage.ErrNotFound {
-			return nil, fmt.Errorf("parent transaction with uuid %s not found", transaction.DependsOnUUID)
+			return nil, notFound
 		}
 		return nil, err
 	}
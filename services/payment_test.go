@@ -1,10 +1,13 @@
 package services_test
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/services"
 	"github.com/pankrator/payment/storage"
@@ -23,27 +26,30 @@ var _ = Describe("Payment service", func() {
 
 	BeforeEach(func() {
 		fakeStorage = &storagefakes.FakeStorage{}
-		fakeStorage.TransactionStub = func(fs func(s storage.Storage) error) error {
+		fakeStorage.TransactionStub = func(_ context.Context, fs func(s storage.Storage) error) error {
 			return fs(fakeStorage)
 		}
-		paymentService = services.NewPaymentService(fakeStorage)
+		paymentService = services.NewPaymentService(fakeStorage, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
 
 		merchant = &model.Merchant{
 			UUID:                "1",
 			Status:              true,
 			Email:               "merchant@email.com",
 			Name:                "merchant",
-			TotalTransactionSum: 0,
+			TotalTransactionSum: model.Balance{},
 		}
 
 		authorizeTransaction = &model.Transaction{
 			UUID:          "some-uuid",
 			Status:        model.Approved,
 			Type:          model.Authorize,
+			Currency:      model.EUR,
 			Amount:        10,
 			CustomerEmail: "user@customer.com",
 			CustomerPhone: "000000000",
 			MerchantID:    "1",
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(time.Hour),
 		}
 
 		chargeTransaction = &model.Transaction{
@@ -51,6 +57,7 @@ var _ = Describe("Payment service", func() {
 			DependsOnUUID: "parent-uuid",
 			Status:        model.Approved,
 			Type:          model.Charge,
+			Currency:      model.EUR,
 			Amount:        10,
 			CustomerEmail: "user@customer.com",
 			CustomerPhone: "000000000",
@@ -62,6 +69,7 @@ var _ = Describe("Payment service", func() {
 			DependsOnUUID: "parent-uuid",
 			Status:        model.Approved,
 			Type:          model.Refund,
+			Currency:      model.EUR,
 			Amount:        10,
 			CustomerEmail: "user@customer.com",
 			CustomerPhone: "000000000",
@@ -84,7 +92,7 @@ var _ = Describe("Payment service", func() {
 		When("there is a merchant associated with the transaction", func() {
 			When("merchant is active", func() {
 				BeforeEach(func() {
-					fakeStorage.GetReturnsOnCall(0, merchant, nil)
+					fakeStorage.GetForUpdateReturnsOnCall(0, merchant, nil)
 				})
 
 				When("transaction is authorize", func() {
@@ -93,13 +101,14 @@ var _ = Describe("Payment service", func() {
 					})
 
 					It("should be created successfully", func() {
-						result, err := paymentService.Create(&model.Transaction{
+						result, err := paymentService.Create(context.Background(), &model.Transaction{
 							Type:          model.Authorize,
+							Currency:      model.EUR,
 							Amount:        10,
 							CustomerEmail: "user@customer.com",
 							CustomerPhone: "000000000",
 							MerchantID:    "1",
-						})
+						}, "user")
 						Expect(err).ShouldNot(HaveOccurred())
 						transaction := result.(*model.Transaction)
 						Expect(transaction.Status).To(Equal(model.Approved))
@@ -107,19 +116,33 @@ var _ = Describe("Payment service", func() {
 						Expect(transaction.DependsOnUUID).To(BeEmpty())
 					})
 
+					It("should fail for an unsupported currency", func() {
+						_, err := paymentService.Create(context.Background(), &model.Transaction{
+							Type:          model.Authorize,
+							Currency:      "XYZ",
+							Amount:        10,
+							CustomerEmail: "user@customer.com",
+							CustomerPhone: "000000000",
+							MerchantID:    "1",
+						}, "user")
+						Expect(err).Should(HaveOccurred())
+						Expect(err.Error()).Should(ContainSubstring("currency XYZ is not supported"))
+					})
+
 					When("create returns an error", func() {
 						BeforeEach(func() {
 							fakeStorage.CreateReturns(nil, errors.New("error during create"))
 						})
 
 						It("should return the error", func() {
-							_, err := paymentService.Create(&model.Transaction{
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
 								Type:          model.Authorize,
+								Currency:      model.EUR,
 								Amount:        10,
 								CustomerEmail: "user@customer.com",
 								CustomerPhone: "000000000",
 								MerchantID:    "1",
-							})
+							}, "user")
 							Expect(err).Should(HaveOccurred())
 						})
 					})
@@ -132,18 +155,19 @@ var _ = Describe("Payment service", func() {
 
 					When("parent is not of type authorize", func() {
 						BeforeEach(func() {
-							fakeStorage.GetReturnsOnCall(1, chargeTransaction, nil)
+							fakeStorage.GetForUpdateReturnsOnCall(1, chargeTransaction, nil)
 						})
 
 						It("should fail to create", func() {
-							_, err := paymentService.Create(&model.Transaction{
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
 								Type:          model.Charge,
+								Currency:      model.EUR,
 								Amount:        10,
 								DependsOnUUID: "parent-uuid",
 								CustomerEmail: "user@customer.com",
 								CustomerPhone: "000000000",
 								MerchantID:    "1",
-							})
+							}, "user")
 							Expect(err).Should(HaveOccurred())
 							Expect(err.Error()).Should(ContainSubstring("parent transaction should be of type authorize"))
 						})
@@ -151,26 +175,206 @@ var _ = Describe("Payment service", func() {
 
 					When("parent is authorize", func() {
 						BeforeEach(func() {
-							fakeStorage.GetReturnsOnCall(1, authorizeTransaction, nil)
-							fakeStorage.GetReturnsOnCall(2, merchant, nil)
+							fakeStorage.GetForUpdateReturnsOnCall(1, authorizeTransaction, nil)
 							fakeStorage.SaveReturns(nil)
 						})
 
 						It("should be created successfully", func() {
-							result, err := paymentService.Create(&model.Transaction{
+							result, err := paymentService.Create(context.Background(), &model.Transaction{
 								Type:          model.Charge,
+								Currency:      model.EUR,
 								DependsOnUUID: "parent-uuid",
 								Amount:        10,
 								CustomerEmail: "user@customer.com",
 								CustomerPhone: "000000000",
 								MerchantID:    "1",
-							})
+							}, "user")
 							Expect(err).ShouldNot(HaveOccurred())
 							transaction := result.(*model.Transaction)
 							Expect(transaction.Status).To(Equal(model.Approved))
 							Expect(transaction.UUID).To(Not(BeEmpty()))
 							Expect(transaction.DependsOnUUID).ToNot(BeEmpty())
 						})
+
+						It("should capture the whole authorized amount", func() {
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
+								Type:          model.Charge,
+								Currency:      model.EUR,
+								DependsOnUUID: "parent-uuid",
+								Amount:        10,
+								CustomerEmail: "user@customer.com",
+								CustomerPhone: "000000000",
+								MerchantID:    "1",
+							}, "user")
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(authorizeTransaction.CapturedAmount).To(Equal(10))
+							Expect(authorizeTransaction.Status).To(Equal(model.Captured))
+							_, saved := fakeStorage.SaveArgsForCall(0)
+							Expect(saved).To(Equal(authorizeTransaction))
+						})
+
+						It("should lock the merchant and the parent in a single transaction", func() {
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
+								Type:          model.Charge,
+								Currency:      model.EUR,
+								DependsOnUUID: "parent-uuid",
+								Amount:        10,
+								CustomerEmail: "user@customer.com",
+								CustomerPhone: "000000000",
+								MerchantID:    "1",
+							}, "user")
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(fakeStorage.TransactionCallCount()).To(Equal(1))
+							Expect(fakeStorage.GetForUpdateCallCount()).To(Equal(2))
+							_, merchantType, merchantID := fakeStorage.GetForUpdateArgsForCall(0)
+							Expect(merchantType).To(Equal(model.MerchantType))
+							Expect(merchantID).To(Equal("1"))
+							_, parentType, parentID := fakeStorage.GetForUpdateArgsForCall(1)
+							Expect(parentType).To(Equal(model.TransactionObjectType))
+							Expect(parentID).To(Equal("parent-uuid"))
+							Expect(fakeStorage.GetCallCount()).To(Equal(0))
+						})
+
+						It("should take the currency of the parent when it is not provided", func() {
+							result, err := paymentService.Create(context.Background(), &model.Transaction{
+								Type:          model.Charge,
+								DependsOnUUID: "parent-uuid",
+								Amount:        10,
+								CustomerEmail: "user@customer.com",
+								CustomerPhone: "000000000",
+								MerchantID:    "1",
+							}, "user")
+							Expect(err).ShouldNot(HaveOccurred())
+							_, created := fakeStorage.CreateArgsForCall(0)
+							Expect(created.(*model.Transaction).Currency).To(Equal(model.EUR))
+							Expect(result).ToNot(BeNil())
+						})
+
+						It("should fail when the currency does not match the parent one", func() {
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
+								Type:          model.Charge,
+								Currency:      model.USD,
+								DependsOnUUID: "parent-uuid",
+								Amount:        10,
+								CustomerEmail: "user@customer.com",
+								CustomerPhone: "000000000",
+								MerchantID:    "1",
+							}, "user")
+							Expect(err).Should(HaveOccurred())
+							Expect(err.Error()).Should(ContainSubstring("currency USD does not match the parent transaction currency EUR"))
+							Expect(fakeStorage.CreateCallCount()).To(Equal(0))
+						})
+
+						It("should keep the merchant total per currency", func() {
+							merchant.TotalTransactionSum = model.Balance{model.USD: 5}
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
+								Type:          model.Charge,
+								Currency:      model.EUR,
+								DependsOnUUID: "parent-uuid",
+								Amount:        10,
+								CustomerEmail: "user@customer.com",
+								CustomerPhone: "000000000",
+								MerchantID:    "1",
+							}, "user")
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(merchant.TotalTransactionSum).To(Equal(model.Balance{model.USD: 5, model.EUR: 10}))
+						})
+
+						When("charge is partial", func() {
+							It("should keep the authorization open for further charges", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Charge,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-uuid",
+									Amount:        4,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								Expect(authorizeTransaction.CapturedAmount).To(Equal(4))
+								Expect(authorizeTransaction.RemainingAmount()).To(Equal(6))
+								Expect(authorizeTransaction.Status).To(Equal(model.Approved))
+								Expect(merchant.TotalTransactionSum).To(Equal(model.Balance{model.EUR: int64(4)}))
+							})
+
+							It("should release the rest of the authorization on final capture", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Charge,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-uuid",
+									Amount:        4,
+									FinalCapture:  true,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								Expect(authorizeTransaction.CapturedAmount).To(Equal(4))
+								Expect(authorizeTransaction.Status).To(Equal(model.Captured))
+							})
+						})
+
+						When("authorization is already partially captured", func() {
+							BeforeEach(func() {
+								authorizeTransaction.CapturedAmount = 7
+							})
+
+							It("should fail to charge more than the remaining amount", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Charge,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-uuid",
+									Amount:        4,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).Should(HaveOccurred())
+								Expect(err.Error()).Should(ContainSubstring("amount exceeds the remaining authorized amount: 3"))
+								Expect(fakeStorage.CreateCallCount()).To(Equal(0))
+							})
+
+							It("should capture the remaining amount", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Charge,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-uuid",
+									Amount:        3,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								Expect(authorizeTransaction.CapturedAmount).To(Equal(10))
+								Expect(authorizeTransaction.Status).To(Equal(model.Captured))
+							})
+						})
+
+						When("authorization is already captured", func() {
+							BeforeEach(func() {
+								authorizeTransaction.CapturedAmount = 4
+								authorizeTransaction.Status = model.Captured
+							})
+
+							It("should create the charge as errored", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Charge,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-uuid",
+									Amount:        4,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								_, object := fakeStorage.CreateArgsForCall(0)
+								created := object.(*model.Transaction)
+								Expect(created.Status).To(Equal(model.Errored))
+								Expect(authorizeTransaction.CapturedAmount).To(Equal(4))
+								Expect(fakeStorage.SaveCallCount()).To(Equal(0))
+							})
+						})
 					})
 
 				})
@@ -182,18 +386,19 @@ var _ = Describe("Payment service", func() {
 
 					When("parent is not of type charge", func() {
 						BeforeEach(func() {
-							fakeStorage.GetReturnsOnCall(1, authorizeTransaction, nil)
+							fakeStorage.GetForUpdateReturnsOnCall(1, authorizeTransaction, nil)
 						})
 
 						It("should fail", func() {
-							_, err := paymentService.Create(&model.Transaction{
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
 								Type:          model.Refund,
+								Currency:      model.EUR,
 								DependsOnUUID: "parent-id",
 								Amount:        10,
 								CustomerEmail: "user@customer.com",
 								CustomerPhone: "000000000",
 								MerchantID:    "1",
-							})
+							}, "user")
 							Expect(err).Should(HaveOccurred())
 							Expect(err.Error()).To(ContainSubstring("parent transaction should be of type charge"))
 						})
@@ -201,26 +406,123 @@ var _ = Describe("Payment service", func() {
 
 					When("parent is charge", func() {
 						BeforeEach(func() {
-							fakeStorage.GetReturnsOnCall(1, chargeTransaction, nil)
-							fakeStorage.GetReturnsOnCall(2, merchant, nil)
+							fakeStorage.GetForUpdateReturnsOnCall(1, chargeTransaction, nil)
 							fakeStorage.SaveReturns(nil)
 						})
 
 						It("should be successfully created", func() {
-							result, err := paymentService.Create(&model.Transaction{
+							result, err := paymentService.Create(context.Background(), &model.Transaction{
 								Type:          model.Refund,
+								Currency:      model.EUR,
 								DependsOnUUID: "parent-id",
 								Amount:        10,
 								CustomerEmail: "user@customer.com",
 								CustomerPhone: "000000000",
 								MerchantID:    "1",
-							})
+							}, "user")
 							Expect(err).ShouldNot(HaveOccurred())
 							transaction := result.(*model.Transaction)
 							Expect(transaction.Status).To(Equal(model.Approved))
 							Expect(transaction.UUID).To(Not(BeEmpty()))
 							Expect(transaction.DependsOnUUID).ToNot(BeEmpty())
 						})
+
+						It("should refund the whole charge", func() {
+							_, err := paymentService.Create(context.Background(), &model.Transaction{
+								Type:          model.Refund,
+								Currency:      model.EUR,
+								DependsOnUUID: "parent-id",
+								Amount:        10,
+								CustomerEmail: "user@customer.com",
+								CustomerPhone: "000000000",
+								MerchantID:    "1",
+							}, "user")
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(chargeTransaction.RefundedAmount).To(Equal(10))
+							Expect(chargeTransaction.Status).To(Equal(model.Refunded))
+							Expect(merchant.TotalTransactionSum).To(Equal(model.Balance{model.EUR: int64(-10)}))
+						})
+
+						When("refund is partial", func() {
+							It("should mark the charge as partially refunded", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Refund,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-id",
+									Amount:        4,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								Expect(chargeTransaction.RefundedAmount).To(Equal(4))
+								Expect(chargeTransaction.RefundableAmount()).To(Equal(6))
+								Expect(chargeTransaction.Status).To(Equal(model.PartiallyRefunded))
+								Expect(merchant.TotalTransactionSum).To(Equal(model.Balance{model.EUR: int64(-4)}))
+							})
+						})
+
+						When("charge is already partially refunded", func() {
+							BeforeEach(func() {
+								chargeTransaction.RefundedAmount = 7
+								chargeTransaction.Status = model.PartiallyRefunded
+							})
+
+							It("should fail to refund more than the refundable amount", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Refund,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-id",
+									Amount:        4,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).Should(HaveOccurred())
+								Expect(err.Error()).Should(ContainSubstring("amount exceeds the refundable amount: 3"))
+								Expect(fakeStorage.CreateCallCount()).To(Equal(0))
+							})
+
+							It("should refund the rest of the charge", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Refund,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-id",
+									Amount:        3,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								Expect(chargeTransaction.RefundedAmount).To(Equal(10))
+								Expect(chargeTransaction.Status).To(Equal(model.Refunded))
+								Expect(merchant.TotalTransactionSum).To(Equal(model.Balance{model.EUR: int64(-3)}))
+							})
+						})
+
+						When("charge is already refunded", func() {
+							BeforeEach(func() {
+								chargeTransaction.RefundedAmount = 10
+								chargeTransaction.Status = model.Refunded
+							})
+
+							It("should create the refund as errored", func() {
+								_, err := paymentService.Create(context.Background(), &model.Transaction{
+									Type:          model.Refund,
+									Currency:      model.EUR,
+									DependsOnUUID: "parent-id",
+									Amount:        10,
+									CustomerEmail: "user@customer.com",
+									CustomerPhone: "000000000",
+									MerchantID:    "1",
+								}, "user")
+								Expect(err).ShouldNot(HaveOccurred())
+								_, object := fakeStorage.CreateArgsForCall(0)
+								created := object.(*model.Transaction)
+								Expect(created.Status).To(Equal(model.Errored))
+								Expect(fakeStorage.SaveCallCount()).To(Equal(0))
+							})
+						})
 					})
 				})
 
@@ -228,39 +530,84 @@ var _ = Describe("Payment service", func() {
 
 				})
 
+				When("final capture is requested on a non charge transaction", func() {
+					It("should fail validation", func() {
+						_, err := paymentService.Create(context.Background(), &model.Transaction{
+							Type:          model.Authorize,
+							Currency:      model.EUR,
+							Amount:        10,
+							FinalCapture:  true,
+							CustomerEmail: "user@customer.com",
+							CustomerPhone: "000000000",
+							MerchantID:    "1",
+						}, "user")
+						Expect(err).Should(HaveOccurred())
+						Expect(err.Error()).Should(ContainSubstring("only transaction of type charge can be a final capture"))
+					})
+				})
+
 				When("the parent transaction is already followed", func() {
 					BeforeEach(func() {
+						fakeStorage.GetForUpdateReturnsOnCall(1, authorizeTransaction, nil)
 						fakeStorage.CountReturns(1, nil)
 					})
 
 					It("should fail", func() {
-						_, err := paymentService.Create(&model.Transaction{
-							Type:          model.Charge,
+						_, err := paymentService.Create(context.Background(), &model.Transaction{
+							Type:          model.Reversal,
+							Currency:      model.EUR,
 							DependsOnUUID: "parent-id",
 							Amount:        10,
 							CustomerEmail: "user@customer.com",
 							CustomerPhone: "000000000",
 							MerchantID:    "1",
-						})
+						}, "user")
 						Expect(err).Should(HaveOccurred())
 						Expect(err.Error()).To(ContainSubstring("the parent transaction is already followed"))
+
+						_, _, c := fakeStorage.CountArgsForCall(0)
+						Expect(c).To(Equal(criteria.And(
+							criteria.Eq("depends_on_uuid", "parent-id"),
+							criteria.Eq("type", model.Reversal),
+						)))
+					})
+				})
+
+				When("the parent transaction is unknown", func() {
+					BeforeEach(func() {
+						fakeStorage.GetForUpdateReturnsOnCall(1, nil, storage.ErrNotFound)
+					})
+
+					It("should reject the transaction", func() {
+						_, err := paymentService.Create(context.Background(), &model.Transaction{
+							Type:          model.Charge,
+							Currency:      model.EUR,
+							Amount:        10,
+							DependsOnUUID: "no-such-parent",
+							CustomerEmail: "user@customer.com",
+							CustomerPhone: "000000000",
+							MerchantID:    "1",
+						}, "user")
+						Expect(err).To(MatchError("parent transaction with uuid no-such-parent not found"))
+						Expect(err).To(BeAssignableToTypeOf(&services.RejectedError{}))
 					})
 				})
 
 				When("there is no such parent transaction", func() {
 					BeforeEach(func() {
-						fakeStorage.GetReturnsOnCall(1, nil, errors.New("no such parent found"))
+						fakeStorage.GetForUpdateReturnsOnCall(1, nil, errors.New("no such parent found"))
 					})
 
 					It("should fail to create", func() {
-						_, err := paymentService.Create(&model.Transaction{
+						_, err := paymentService.Create(context.Background(), &model.Transaction{
 							Type:          model.Charge,
+							Currency:      model.EUR,
 							Amount:        10,
 							DependsOnUUID: "no-such-parent",
 							CustomerEmail: "user@customer.com",
 							CustomerPhone: "000000000",
 							MerchantID:    "1",
-						})
+						}, "user")
 						Expect(err).Should(HaveOccurred())
 						Expect(err.Error()).Should(ContainSubstring("no such parent found"))
 					})
@@ -270,39 +617,85 @@ var _ = Describe("Payment service", func() {
 			When("merchant is inactive", func() {
 				BeforeEach(func() {
 					merchant.Status = false
-					fakeStorage.GetReturnsOnCall(0, merchant, nil)
+					fakeStorage.GetForUpdateReturnsOnCall(0, merchant, nil)
 					fakeStorage.CreateReturns(authorizeTransaction, nil)
 				})
 
 				It("should fail to create transaction", func() {
-					_, err := paymentService.Create(&model.Transaction{
+					_, err := paymentService.Create(context.Background(), &model.Transaction{
 						Type:          model.Authorize,
+						Currency:      model.EUR,
 						Amount:        10,
 						CustomerEmail: "user@customer.com",
 						CustomerPhone: "000000000",
 						MerchantID:    "1",
-					})
+					}, "user")
 					Expect(err).Should(HaveOccurred())
 					Expect(err.Error()).Should(ContainSubstring("merchant with name merchant is not active"))
+					Expect(err).To(BeAssignableToTypeOf(&services.RejectedError{}))
 				})
 			})
 		})
 
 		When("there is no such merchant", func() {
 			BeforeEach(func() {
-				fakeStorage.GetReturnsOnCall(0, nil, errors.New("merchant not found"))
+				fakeStorage.GetForUpdateReturnsOnCall(0, nil, errors.New("merchant not found"))
 			})
 			It("should fail to create transaction", func() {
-				_, err := paymentService.Create(&model.Transaction{
+				_, err := paymentService.Create(context.Background(), &model.Transaction{
 					Type:          model.Authorize,
+					Currency:      model.EUR,
 					Amount:        10,
 					CustomerEmail: "user@customer.com",
 					CustomerPhone: "000000000",
 					MerchantID:    "1",
-				})
+				}, "user")
 				Expect(err).Should(HaveOccurred())
 				Expect(err.Error()).Should(ContainSubstring("merchant not found"))
+				Expect(err).ToNot(BeAssignableToTypeOf(&services.RejectedError{}))
 			})
 		})
+
+		When("merchant is unknown", func() {
+			BeforeEach(func() {
+				fakeStorage.GetForUpdateReturnsOnCall(0, nil, storage.ErrNotFound)
+			})
+
+			It("should reject the transaction", func() {
+				_, err := paymentService.Create(context.Background(), &model.Transaction{
+					Type:          model.Authorize,
+					Currency:      model.EUR,
+					Amount:        10,
+					CustomerEmail: "user@customer.com",
+					CustomerPhone: "000000000",
+					MerchantID:    "1",
+				}, "user")
+				Expect(err).To(MatchError("merchant with id 1 not found"))
+				Expect(err).To(BeAssignableToTypeOf(&services.RejectedError{}))
+			})
+		})
+	})
+
+	Describe("ListDescendants", func() {
+		It("should list the children and their children matching the criterion", func() {
+			chargeTransaction.UUID = "charge-uuid"
+			chargeTransaction.DependsOnUUID = authorizeTransaction.UUID
+			refundTransaction.UUID = "refund-uuid"
+			refundTransaction.DependsOnUUID = chargeTransaction.UUID
+			otherMerchantRefund := &model.Transaction{
+				UUID:          "other-refund-uuid",
+				DependsOnUUID: chargeTransaction.UUID,
+				MerchantID:    "2",
+			}
+			stored := []model.Object{authorizeTransaction, chargeTransaction, refundTransaction, otherMerchantRefund}
+			fakeStorage.ListStub = func(_ context.Context, typee string, c criteria.Criterion) ([]model.Object, error) {
+				return criteria.Filter(stored, c)
+			}
+
+			result, err := paymentService.ListDescendants(context.Background(), []model.Object{authorizeTransaction}, criteria.Eq("merchant_id", "1"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).To(Equal([]model.Object{chargeTransaction, refundTransaction}))
+			Expect(fakeStorage.ListCallCount()).To(Equal(3))
+		})
 	})
 })
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/metrics"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+type RetentionSettings struct {
+	// KeepTransactionsFor is how long the transactions in each status are kept after their last change.
+	// Chains with transactions in statuses without a period are not archived.
+	KeepTransactionsFor    map[model.TransactionState]time.Duration `mapstructure:"keep_transactions_for"`
+	KeepIdempotencyKeysFor time.Duration                            `mapstructure:"keep_idempotency_keys_for"`
+	// DryRun only reports the chains which would be archived
+	DryRun   bool          `mapstructure:"dry_run"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// DefaultRetentionSettings keeps the transactions in every status for seven years
+func DefaultRetentionSettings() *RetentionSettings {
+	keepTransactionsFor := make(map[model.TransactionState]time.Duration)
+	for _, state := range model.TransactionStates {
+		keepTransactionsFor[state] = time.Hour * 24 * 365 * 7
+	}
+	return &RetentionSettings{
+		KeepTransactionsFor: keepTransactionsFor,
+		Interval:            time.Hour,
+	}
+}
+
+func (s *RetentionSettings) Keys() []string {
+	keys := []string{
+		"keep_idempotency_keys_for",
+		"dry_run",
+		"interval",
+	}
+	for _, state := range model.TransactionStates {
+		keys = append(keys, "keep_transactions_for."+string(state))
+	}
+	return keys
+}
+
+// RetentionReport lists the chains of transactions which were archived, or would be in a dry run
+type RetentionReport struct {
+	DryRun bool
+	// RootUUIDs are the uuids of the authorizations which the archived chains start with
+	RootUUIDs    []string
+	Transactions int
+}
+
+// RetentionService moves old chains of transactions to the archive. A chain is an authorization together with
+// all transactions depending on it and is archived at once, when all of its transactions are old enough.
+// The ledger entries of archived transactions are kept, so the balances of the merchants do not change.
+type RetentionService struct {
+	settings   *RetentionSettings
+	repository storage.Storage
+	metrics    *metrics.Metrics
+	heartbeat  *health.Heartbeat
+}
+
+func NewRetentionService(settings *RetentionSettings, repository storage.Storage, metrics *metrics.Metrics) *RetentionService {
+	return &RetentionService{
+		settings:   settings,
+		repository: repository,
+		metrics:    metrics,
+		heartbeat:  health.NewHeartbeat(settings.Interval * heartbeatTolerance),
+	}
+}
+
+// Heartbeat is beaten on every iteration of the loop started with Start and after every chain checked in it
+func (rs *RetentionService) Heartbeat() *health.Heartbeat {
+	return rs.heartbeat
+}
+
+func (rs *RetentionService) Start(ctx context.Context) {
+	ctx = log.ContextWithFields(ctx, logrus.Fields{"job": "retention"})
+	go func() {
+		for {
+			rs.heartbeat.Beat()
+			elapsed := time.After(rs.settings.Interval)
+			select {
+			case <-ctx.Done():
+				log.C(ctx).Infof("Context cancelled. Stopping the retention service...")
+				return
+			case <-elapsed:
+				start := time.Now()
+				report, err := rs.Run(ctx, start)
+				rs.metrics.ObserveJob("retention", start, err)
+				if err != nil {
+					log.C(ctx).Errorf("Could not archive old transactions: %s", err)
+					continue
+				}
+				if report.DryRun {
+					log.C(ctx).Infof("Would archive %d chains with %d transactions", len(report.RootUUIDs), report.Transactions)
+				} else {
+					log.C(ctx).Infof("Archived %d chains with %d transactions", len(report.RootUUIDs), report.Transactions)
+				}
+			}
+		}
+	}()
+
+	log.C(ctx).Infof("Retention service started")
+}
+
+// Run archives the chains of transactions which are old enough at the given time and cleans old idempotency keys
+func (rs *RetentionService) Run(ctx context.Context, now time.Time) (*RetentionReport, error) {
+	ctx, span := tracing.Start(ctx, "RetentionService.Run")
+	defer span.End()
+
+	report, err := rs.archiveTransactions(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if rs.settings.KeepIdempotencyKeysFor > 0 && !rs.settings.DryRun {
+		log.C(ctx).Infof("Will clean idempotency keys older than %s", now.Add(-rs.settings.KeepIdempotencyKeysFor).Format(time.RFC3339))
+		if err := rs.repository.Delete(ctx, model.IdempotencyKeyType, criteria.Lt("created_at", now.Add(-rs.settings.KeepIdempotencyKeysFor))); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func (rs *RetentionService) archiveTransactions(ctx context.Context, now time.Time) (*RetentionReport, error) {
+	report := &RetentionReport{
+		DryRun:    rs.settings.DryRun,
+		RootUUIDs: make([]string, 0),
+	}
+	shortest := time.Duration(0)
+	for _, keepFor := range rs.settings.KeepTransactionsFor {
+		if keepFor > 0 && (shortest == 0 || keepFor < shortest) {
+			shortest = keepFor
+		}
+	}
+	if shortest == 0 {
+		return report, nil
+	}
+
+	roots, err := rs.repository.List(ctx, model.TransactionObjectType, criteria.And(
+		criteria.Null("depends_on_uuid"),
+		criteria.Lt("updated_at", now.Add(-shortest)),
+	))
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		// Runs over years of transactions take long, but they are not stuck while they go through the chains
+		rs.heartbeat.Beat()
+		count, err := rs.archiveChain(ctx, root.(*model.Transaction), now)
+		if err != nil {
+			// The other chains are still archived. This one is tried again the next time.
+			log.C(ctx).Errorf("Could not archive the chain of transaction %s: %s", root.(*model.Transaction).UUID, err)
+			continue
+		}
+		if count == 0 {
+			continue
+		}
+		if rs.settings.DryRun {
+			log.C(ctx).Infof("Would archive the chain of transaction %s with %d transactions", root.(*model.Transaction).UUID, count)
+		}
+		report.RootUUIDs = append(report.RootUUIDs, root.(*model.Transaction).UUID)
+		report.Transactions += count
+	}
+	return report, nil
+}
+
+// archiveChain archives the chain of the root transaction, if all of its transactions are old enough,
+// and returns how many transactions it has
+func (rs *RetentionService) archiveChain(ctx context.Context, root *model.Transaction, now time.Time) (int, error) {
+	count := 0
+	// The merchant is locked as when creating transactions, so that no transaction is added to the chain meanwhile
+	err := rs.repository.Transaction(ctx, func(tx storage.Storage) error {
+		if _, err := tx.GetForUpdate(ctx, model.MerchantType, root.MerchantID); err != nil {
+			return err
+		}
+		object, err := tx.Get(ctx, model.TransactionObjectType, root.UUID)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		descendants, err := listDescendants(ctx, tx, []model.Object{object}, nil)
+		if err != nil {
+			return err
+		}
+		chain := append([]model.Object{object}, descendants...)
+
+		ids := make([]interface{}, 0, len(chain))
+		for _, transaction := range chain {
+			if !rs.isOld(transaction.(*model.Transaction), now) {
+				return nil
+			}
+			ids = append(ids, transaction.(*model.Transaction).UUID)
+		}
+		count = len(chain)
+		if rs.settings.DryRun {
+			return nil
+		}
+
+		changes, err := tx.List(ctx, model.TransactionStatusChangeType, criteria.OneOf("transaction_id", ids...))
+		if err != nil {
+			return err
+		}
+		history := make(map[string][]*model.TransactionStatusChange)
+		for _, change := range changes {
+			change := change.(*model.TransactionStatusChange)
+			history[change.TransactionID] = append(history[change.TransactionID], change)
+		}
+
+		for _, transaction := range chain {
+			transaction := transaction.(*model.Transaction)
+			archived := &model.ArchivedTransaction{
+				UUID:        transaction.UUID,
+				RootUUID:    root.UUID,
+				MerchantID:  transaction.MerchantID,
+				Transaction: transaction,
+				History:     history[transaction.UUID],
+				ArchivedAt:  now,
+			}
+			if err := archived.Validate(); err != nil {
+				return err
+			}
+			if _, err := tx.Create(ctx, archived); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Delete(ctx, model.TransactionStatusChangeType, criteria.OneOf("transaction_id", ids...)); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, model.TransactionObjectType, criteria.OneOf("uuid", ids...))
+	})
+	return count, err
+}
+
+// isOld tells whether the transaction has not changed for longer than the retention period of its status
+func (rs *RetentionService) isOld(transaction *model.Transaction, now time.Time) bool {
+	keepFor := rs.settings.KeepTransactionsFor[transaction.Status]
+	return keepFor > 0 && transaction.UpdatedAt.Before(now.Add(-keepFor))
+}
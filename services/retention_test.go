@@ -0,0 +1,109 @@
+package services_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage/memory"
+)
+
+var _ = Describe("Retention", func() {
+	var repository *memory.Storage
+	var settings *services.RetentionSettings
+	var retentionService *services.RetentionService
+	var merchant *model.Merchant
+	var authorizationID string
+
+	createTransaction := func(transaction *model.Transaction) string {
+		transaction.CustomerEmail = "user@customer.com"
+		transaction.MerchantID = merchant.UUID
+		paymentService := services.NewPaymentService(repository, &services.LedgerSettings{FeeBasisPoints: 1000}, services.DefaultAuthorizationSettings(), nil)
+		object, err := paymentService.Create(context.Background(), transaction, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+		return object.(*model.Transaction).UUID
+	}
+
+	count := func(typee string) int {
+		count, err := repository.Count(context.Background(), typee, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		return count
+	}
+
+	BeforeEach(func() {
+		repository = memory.New()
+		settings = &services.RetentionSettings{
+			KeepTransactionsFor: make(map[model.TransactionState]time.Duration),
+		}
+		for _, state := range model.TransactionStates {
+			settings.KeepTransactionsFor[state] = time.Hour
+		}
+		retentionService = services.NewRetentionService(settings, repository, nil)
+
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		merchant = object.(*model.Merchant)
+
+		authorizationID = createTransaction(&model.Transaction{Type: model.Authorize, Currency: model.EUR, Amount: 100})
+		chargeID := createTransaction(&model.Transaction{Type: model.Charge, Amount: 60, FinalCapture: true, DependsOnUUID: authorizationID})
+		createTransaction(&model.Transaction{Type: model.Refund, Amount: 20, DependsOnUUID: chargeID})
+	})
+
+	It("should archive whole chains and keep the ledger", func() {
+		report, err := retentionService.Run(context.Background(), time.Now().Add(time.Hour*2))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(report.RootUUIDs).To(ConsistOf(authorizationID))
+		Expect(report.Transactions).To(Equal(3))
+
+		Expect(count(model.TransactionObjectType)).To(BeZero())
+		Expect(count(model.TransactionStatusChangeType)).To(BeZero())
+		Expect(count(model.ArchivedTransactionType)).To(Equal(3))
+
+		object, err := repository.Get(context.Background(), model.ArchivedTransactionType, authorizationID)
+		Expect(err).ShouldNot(HaveOccurred())
+		archived := object.(*model.ArchivedTransaction)
+		Expect(archived.RootUUID).To(Equal(authorizationID))
+		Expect(archived.Transaction.Status).To(Equal(model.Captured))
+		Expect(archived.History).To(HaveLen(2))
+
+		verification, err := services.NewLedgerService(repository).Verify(context.Background(), merchant.UUID)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(verification.Verified).To(BeTrue())
+		Expect(verification.TotalTransactionSum).To(Equal(model.Balance{model.EUR: 34}))
+
+		Expect(services.NewMerchantService(repository).Delete(context.Background(), merchant.UUID)).To(Equal(services.ErrMerchantHasTransactions))
+	})
+
+	It("should only report the chains in a dry run", func() {
+		settings.DryRun = true
+		report, err := retentionService.Run(context.Background(), time.Now().Add(time.Hour*2))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(report.DryRun).To(BeTrue())
+		Expect(report.RootUUIDs).To(ConsistOf(authorizationID))
+		Expect(report.Transactions).To(Equal(3))
+
+		Expect(count(model.TransactionObjectType)).To(Equal(3))
+		Expect(count(model.ArchivedTransactionType)).To(BeZero())
+	})
+
+	It("should keep chains with transactions which are not old enough", func() {
+		settings.KeepTransactionsFor[model.PartiallyRefunded] = time.Hour * 3
+		report, err := retentionService.Run(context.Background(), time.Now().Add(time.Hour*2))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(report.RootUUIDs).To(BeEmpty())
+		Expect(count(model.TransactionObjectType)).To(Equal(3))
+
+		delete(settings.KeepTransactionsFor, model.PartiallyRefunded)
+		report, err = retentionService.Run(context.Background(), time.Now().Add(time.Hour*24))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(report.RootUUIDs).To(BeEmpty())
+		Expect(count(model.TransactionObjectType)).To(Equal(3))
+	})
+})
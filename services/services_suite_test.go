@@ -3,14 +3,11 @@ package services_test
 import (
 	"testing"
 
-	."github.com/onsi/gomega"
-	."github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
-
 func TestServiceSuite(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Services Suite")
 }
-
-
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
+)
+
+// ErrWebhookEventNotFailed is returned when redelivering an event which is not failed
+var ErrWebhookEventNotFailed = errors.New("only failed webhook events can be redelivered")
+
+type WebhookService struct {
+	repository storage.Storage
+}
+
+func NewWebhookService(repository storage.Storage) *WebhookService {
+	return &WebhookService{
+		repository: repository,
+	}
+}
+
+// CreateEndpoint registers the endpoint for the merchant and generates the secret used to sign its events
+func (ws *WebhookService) CreateEndpoint(ctx context.Context, merchantID string, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error) {
+	ctx, span := tracing.Start(ctx, "WebhookService.CreateEndpoint")
+	defer span.End()
+
+	if err := endpoint.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := ws.repository.Get(ctx, model.MerchantType, merchantID); err != nil {
+		return nil, err
+	}
+
+	UUID, err := uuid.NewV4()
+	if err != nil {
+		log.C(ctx).Errorf("Could not generate UUID: %s", err)
+		return nil, errors.New("could not generate UUID")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.C(ctx).Errorf("Could not generate webhook secret: %s", err)
+		return nil, errors.New("could not generate webhook secret")
+	}
+
+	endpoint.UUID = UUID.String()
+	endpoint.MerchantID = merchantID
+	endpoint.Secret = hex.EncodeToString(secret)
+
+	result, err := ws.repository.Create(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*model.WebhookEndpoint), nil
+}
+
+// ListEndpoints lists the endpoints of the merchant without their secrets
+func (ws *WebhookService) ListEndpoints(ctx context.Context, merchantID string) ([]model.Object, error) {
+	ctx, span := tracing.Start(ctx, "WebhookService.ListEndpoints")
+	defer span.End()
+
+	endpoints, err := listWebhookEndpoints(ctx, ws.repository, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range endpoints {
+		endpoint.(*model.WebhookEndpoint).Secret = ""
+	}
+	return endpoints, nil
+}
+
+func (ws *WebhookService) DeleteEndpoint(ctx context.Context, merchantID, endpointID string) error {
+	ctx, span := tracing.Start(ctx, "WebhookService.DeleteEndpoint")
+	defer span.End()
+
+	if _, err := ws.repository.GetBy(ctx, model.WebhookEndpointType, criteria.And(
+		criteria.Eq("merchant_id", merchantID),
+		criteria.Eq("uuid", endpointID),
+	)); err != nil {
+		return err
+	}
+	return ws.repository.Delete(ctx, model.WebhookEndpointType, criteria.Eq("uuid", endpointID))
+}
+
+// ListEvents lists the events of the merchant which are in the given status
+func (ws *WebhookService) ListEvents(ctx context.Context, merchantID string, status model.WebhookEventState) ([]model.Object, error) {
+	ctx, span := tracing.Start(ctx, "WebhookService.ListEvents")
+	defer span.End()
+
+	return ws.repository.List(ctx, model.WebhookEventType, criteria.And(
+		criteria.Eq("merchant_id", merchantID),
+		criteria.Eq("status", status),
+	))
+}
+
+// Redeliver schedules a failed event to be delivered again as soon as possible
+func (ws *WebhookService) Redeliver(ctx context.Context, merchantID, eventID string) (*model.WebhookEvent, error) {
+	ctx, span := tracing.Start(ctx, "WebhookService.Redeliver")
+	defer span.End()
+
+	object, err := ws.repository.GetBy(ctx, model.WebhookEventType, criteria.And(
+		criteria.Eq("merchant_id", merchantID),
+		criteria.Eq("uuid", eventID),
+	))
+	if err != nil {
+		return nil, err
+	}
+	event := object.(*model.WebhookEvent)
+	if event.Status != model.WebhookEventFailed {
+		return nil, ErrWebhookEventNotFailed
+	}
+
+	event.Status = model.WebhookEventPending
+	event.Attempts = 0
+	event.NextAttemptAt = time.Now()
+	event.LastError = ""
+	if err := ws.repository.Save(ctx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// enqueueWebhookEvents stores an event about the transaction for every endpoint of its merchant.
+// It should be called with the storage transaction which changes the transaction, so that the events
+// are stored only if the change is.
+func enqueueWebhookEvents(ctx context.Context, tx storage.Storage, eventType string, transaction *model.Transaction) error {
+	endpoints, err := listWebhookEndpoints(ctx, tx, transaction.MerchantID)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(&model.WebhookPayload{
+		EventType:   eventType,
+		CreatedAt:   time.Now(),
+		Transaction: transaction,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		UUID, err := uuid.NewV4()
+		if err != nil {
+			log.C(ctx).Errorf("Could not generate UUID: %s", err)
+			return errors.New("could not generate UUID")
+		}
+		_, err = tx.Create(ctx, &model.WebhookEvent{
+			UUID:          UUID.String(),
+			EndpointID:    endpoint.(*model.WebhookEndpoint).UUID,
+			MerchantID:    transaction.MerchantID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        model.WebhookEventPending,
+			NextAttemptAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listWebhookEndpoints(ctx context.Context, repository storage.Storage, merchantID string) ([]model.Object, error) {
+	return repository.List(ctx, model.WebhookEndpointType, criteria.Eq("merchant_id", merchantID))
+}
+
+// SignWebhookPayload returns the HMAC-SHA256 signature of the payload with the secret of the endpoint
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/metrics"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	webhookSignatureHeader = "X-Payment-Signature"
+	webhookEventHeader     = "X-Payment-Event"
+	webhookDeliveryHeader  = "X-Payment-Delivery"
+)
+
+type WebhookSettings struct {
+	Interval time.Duration `mapstructure:"interval"`
+	// Timeout is how long a delivery may take. The events are claimed for twice as long, so that other
+	// instances do not deliver them meanwhile.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// BatchSize is how many due events are delivered at most in a run
+	BatchSize int `mapstructure:"batch_size"`
+	// Workers is how many events are delivered at the same time, so that slow endpoints do not hold up the others
+	Workers        int           `mapstructure:"workers"`
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+func DefaultWebhookSettings() *WebhookSettings {
+	return &WebhookSettings{
+		Interval:       time.Second * 5,
+		Timeout:        time.Second * 10,
+		BatchSize:      100,
+		Workers:        10,
+		MaxAttempts:    10,
+		InitialBackoff: time.Second * 10,
+		MaxBackoff:     time.Hour,
+	}
+}
+
+func (s *WebhookSettings) Keys() []string {
+	return []string{
+		"interval",
+		"timeout",
+		"batch_size",
+		"workers",
+		"max_attempts",
+		"initial_backoff",
+		"max_backoff",
+	}
+}
+
+// longestRun is how long a run takes at most, when every worker waits for slow endpoints until the timeout
+func (s *WebhookSettings) longestRun() time.Duration {
+	workers := s.workers()
+	rounds := (s.BatchSize + workers - 1) / workers
+	return s.Timeout * time.Duration(rounds)
+}
+
+func (s *WebhookSettings) workers() int {
+	if s.Workers < 1 {
+		return 1
+	}
+	return s.Workers
+}
+
+// WebhookDispatcher delivers the pending webhook events to the endpoints of the merchants
+type WebhookDispatcher struct {
+	settings   *WebhookSettings
+	repository storage.Storage
+	client     *http.Client
+	metrics    *metrics.Metrics
+	heartbeat  *health.Heartbeat
+}
+
+func NewWebhookDispatcher(settings *WebhookSettings, repository storage.Storage, metrics *metrics.Metrics) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		settings:   settings,
+		repository: repository,
+		metrics:    metrics,
+		heartbeat:  health.NewHeartbeat(settings.Interval*heartbeatTolerance + settings.longestRun()),
+		client: &http.Client{
+			Timeout: settings.Timeout,
+		},
+	}
+}
+
+// Heartbeat is beaten on every iteration of the loop started with Start and after every event processed in it.
+// It tolerates runs in which all deliveries wait until the timeout.
+func (wd *WebhookDispatcher) Heartbeat() *health.Heartbeat {
+	return wd.heartbeat
+}
+
+func (wd *WebhookDispatcher) Start(ctx context.Context) {
+	ctx = log.ContextWithFields(ctx, logrus.Fields{"job": "webhook_dispatcher"})
+	go func() {
+		for {
+			wd.heartbeat.Beat()
+			elapsed := time.After(wd.settings.Interval)
+			select {
+			case <-ctx.Done():
+				log.C(ctx).Infof("Context cancelled. Stopping the webhook dispatcher...")
+				return
+			case <-elapsed:
+				start := time.Now()
+				err := wd.Run(ctx)
+				wd.metrics.ObserveJob("webhook_dispatcher", start, err)
+				if err != nil {
+					log.C(ctx).Errorf("Could not deliver webhook events: %s", err)
+				}
+			}
+		}
+	}()
+
+	log.C(ctx).Infof("Webhook dispatcher started")
+}
+
+// Run delivers a batch of the pending events which are due, the longest due first. Each event is claimed before
+// it is delivered, so that it is delivered by a single instance.
+func (wd *WebhookDispatcher) Run(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "WebhookDispatcher.Run")
+	defer span.End()
+
+	events, _, err := wd.repository.ListPage(ctx, model.WebhookEventType, query.Page{
+		Limit:   wd.settings.BatchSize,
+		OrderBy: "next_attempt_at",
+	}, criteria.And(
+		criteria.Eq("status", model.WebhookEventPending),
+		criteria.Le("next_attempt_at", time.Now()),
+	))
+	if err != nil {
+		return err
+	}
+
+	eventIDs := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < wd.settings.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for eventID := range eventIDs {
+				wd.process(ctx, eventID)
+			}
+		}()
+	}
+sending:
+	for _, object := range events {
+		select {
+		case <-ctx.Done():
+			break sending
+		case eventIDs <- object.(*model.WebhookEvent).UUID:
+		}
+	}
+	close(eventIDs)
+	wg.Wait()
+	return nil
+}
+
+// process claims and delivers the event, unless another instance has claimed or delivered it meanwhile
+func (wd *WebhookDispatcher) process(ctx context.Context, eventID string) {
+	defer wd.heartbeat.Beat()
+
+	event, err := wd.claim(ctx, eventID)
+	if err != nil {
+		log.C(ctx).Errorf("Could not claim webhook event %s: %s", eventID, err)
+		return
+	}
+	if event == nil {
+		return
+	}
+
+	deliveryErr := wd.deliver(ctx, event)
+	wd.recordAttempt(ctx, event, deliveryErr)
+	if err := wd.repository.Save(ctx, event); err != nil {
+		log.C(ctx).Errorf("Could not save webhook event %s: %s", event.UUID, err)
+	}
+}
+
+// claim moves the next attempt of the event after the delivery, so that no other run takes it. If the delivery
+// is not recorded, e.g. because the instance stopped, the event is due again when the claim ends.
+// No event is returned when it is not due anymore.
+func (wd *WebhookDispatcher) claim(ctx context.Context, eventID string) (*model.WebhookEvent, error) {
+	var event *model.WebhookEvent
+	err := wd.repository.Transaction(ctx, func(tx storage.Storage) error {
+		object, err := tx.GetForUpdate(ctx, model.WebhookEventType, eventID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		claimed := object.(*model.WebhookEvent)
+		if claimed.Status != model.WebhookEventPending || claimed.NextAttemptAt.After(now) {
+			return nil
+		}
+		claimed.NextAttemptAt = now.Add(wd.settings.Timeout * 2)
+		if err := tx.Save(ctx, claimed); err != nil {
+			return err
+		}
+		event = claimed
+		return nil
+	})
+	return event, err
+}
+
+func (wd *WebhookDispatcher) deliver(ctx context.Context, event *model.WebhookEvent) error {
+	object, err := wd.repository.Get(ctx, model.WebhookEndpointType, event.EndpointID)
+	if err != nil {
+		return fmt.Errorf("could not get webhook endpoint: %s", err)
+	}
+	endpoint := object.(*model.WebhookEndpoint)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(event.Payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event.EventType)
+	req.Header.Set(webhookDeliveryHeader, event.UUID)
+	req.Header.Set(webhookSignatureHeader, SignWebhookPayload(endpoint.Secret, event.Payload))
+
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordAttempt marks the event as delivered or schedules the next attempt with an exponential backoff.
+// The event fails when all attempts are used.
+func (wd *WebhookDispatcher) recordAttempt(ctx context.Context, event *model.WebhookEvent, deliveryErr error) {
+	event.Attempts++
+	if deliveryErr == nil {
+		event.Status = model.WebhookEventDelivered
+		event.LastError = ""
+		return
+	}
+
+	log.C(ctx).Warnf("Delivery of webhook event %s failed: %s", event.UUID, deliveryErr)
+	event.LastError = deliveryErr.Error()
+	if event.Attempts >= wd.settings.MaxAttempts {
+		event.Status = model.WebhookEventFailed
+		return
+	}
+	event.NextAttemptAt = time.Now().Add(wd.backoff(event.Attempts))
+}
+
+func (wd *WebhookDispatcher) backoff(attempts int) time.Duration {
+	backoff := wd.settings.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= wd.settings.MaxBackoff {
+			return wd.settings.MaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,307 @@
+package services_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/health"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/storagefakes"
+)
+
+var _ = Describe("Webhooks", func() {
+	var fakeStorage *storagefakes.FakeStorage
+
+	BeforeEach(func() {
+		fakeStorage = &storagefakes.FakeStorage{}
+		fakeStorage.TransactionStub = func(_ context.Context, fs func(s storage.Storage) error) error {
+			return fs(fakeStorage)
+		}
+	})
+
+	Describe("Payment service", func() {
+		var paymentService *services.PaymentService
+		var authorizeTransaction *model.Transaction
+
+		BeforeEach(func() {
+			paymentService = services.NewPaymentService(fakeStorage, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
+			authorizeTransaction = &model.Transaction{
+				UUID:          "parent-uuid",
+				Status:        model.Approved,
+				Type:          model.Authorize,
+				Currency:      model.EUR,
+				Amount:        10,
+				CustomerEmail: "user@customer.com",
+				MerchantID:    "1",
+			}
+
+			fakeStorage.GetForUpdateReturnsOnCall(0, &model.Merchant{UUID: "1", Name: "merchant", Status: true}, nil)
+			fakeStorage.GetForUpdateReturnsOnCall(1, authorizeTransaction, nil)
+			fakeStorage.CreateStub = func(_ context.Context, object model.Object) (model.Object, error) {
+				return object, nil
+			}
+			fakeStorage.ListReturns([]model.Object{
+				&model.WebhookEndpoint{UUID: "endpoint-1", MerchantID: "1"},
+				&model.WebhookEndpoint{UUID: "endpoint-2", MerchantID: "1"},
+			}, nil)
+		})
+
+		It("should store an event per endpoint for the created transaction and the changed parent", func() {
+			_, err := paymentService.Create(context.Background(), &model.Transaction{
+				Type:          model.Reversal,
+				DependsOnUUID: "parent-uuid",
+				CustomerEmail: "user@customer.com",
+				MerchantID:    "1",
+			}, "user")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			events := make([]*model.WebhookEvent, 0)
+			for i := 0; i < fakeStorage.CreateCallCount(); i++ {
+				_, object := fakeStorage.CreateArgsForCall(i)
+				if event, ok := object.(*model.WebhookEvent); ok {
+					events = append(events, event)
+				}
+			}
+			Expect(events).To(HaveLen(4))
+			Expect(events[0].EventType).To(Equal(model.TransactionCreatedEvent))
+			Expect(events[0].EndpointID).To(Equal("endpoint-1"))
+			Expect(events[1].EndpointID).To(Equal("endpoint-2"))
+			Expect(events[2].EventType).To(Equal(model.TransactionStatusChangedEvent))
+			Expect(string(events[2].Payload)).To(ContainSubstring(`"status":"reversed"`))
+			Expect(events[2].Status).To(Equal(model.WebhookEventPending))
+		})
+	})
+
+	Describe("Dispatcher", func() {
+		var dispatcher *services.WebhookDispatcher
+		var server *httptest.Server
+		var responseStatus int
+		var received *http.Request
+		var receivedBody []byte
+		var event *model.WebhookEvent
+
+		BeforeEach(func() {
+			responseStatus = http.StatusOK
+			received = nil
+			server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				received = req
+				receivedBody, _ = ioutil.ReadAll(req.Body)
+				rw.WriteHeader(responseStatus)
+			}))
+
+			settings := services.DefaultWebhookSettings()
+			settings.MaxAttempts = 3
+			settings.InitialBackoff = time.Minute
+			dispatcher = services.NewWebhookDispatcher(settings, fakeStorage, nil)
+
+			event = &model.WebhookEvent{
+				UUID:       "event-uuid",
+				EndpointID: "endpoint-1",
+				MerchantID: "1",
+				EventType:  model.TransactionCreatedEvent,
+				Payload:    []byte(`{"event_type":"transaction.created"}`),
+				Status:     model.WebhookEventPending,
+			}
+			fakeStorage.ListPageReturns([]model.Object{event}, "", nil)
+			fakeStorage.GetForUpdateReturns(event, nil)
+			fakeStorage.GetReturns(&model.WebhookEndpoint{
+				UUID:   "endpoint-1",
+				URL:    server.URL,
+				Secret: "secret",
+			}, nil)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should send signed events", func() {
+			Expect(dispatcher.Run(context.Background())).To(Succeed())
+			Expect(receivedBody).To(Equal(event.Payload))
+			Expect(received.Header.Get("X-Payment-Signature")).To(Equal(services.SignWebhookPayload("secret", event.Payload)))
+			Expect(received.Header.Get("X-Payment-Event")).To(Equal(model.TransactionCreatedEvent))
+			Expect(received.Header.Get("X-Payment-Delivery")).To(Equal("event-uuid"))
+
+			_, saved := fakeStorage.SaveArgsForCall(0)
+			Expect(saved).To(Equal(event))
+			Expect(event.Status).To(Equal(model.WebhookEventDelivered))
+			Expect(event.Attempts).To(Equal(1))
+		})
+
+		It("should deliver a batch of the longest due events", func() {
+			Expect(dispatcher.Run(context.Background())).To(Succeed())
+			_, typee, page, _ := fakeStorage.ListPageArgsForCall(0)
+			Expect(typee).To(Equal(model.WebhookEventType))
+			Expect(page.Limit).To(Equal(100))
+			Expect(page.OrderBy).To(Equal("next_attempt_at"))
+			Expect(page.Descending).To(BeFalse())
+		})
+
+		It("should claim the events before delivering them", func() {
+			var claimedUntil time.Time
+			deliveredBeforeClaim := false
+			fakeStorage.SaveStub = func(_ context.Context, object model.Object) error {
+				if claimedUntil.IsZero() {
+					claimedUntil = object.(*model.WebhookEvent).NextAttemptAt
+					deliveredBeforeClaim = received != nil
+				}
+				return nil
+			}
+			Expect(dispatcher.Run(context.Background())).To(Succeed())
+			Expect(deliveredBeforeClaim).To(BeFalse())
+			Expect(claimedUntil).To(BeTemporally("~", time.Now().Add(20*time.Second), time.Second))
+			Expect(fakeStorage.SaveCallCount()).To(Equal(2))
+		})
+
+		It("should not deliver events which another run has claimed", func() {
+			fakeStorage.GetForUpdateReturns(&model.WebhookEvent{
+				UUID:          "event-uuid",
+				Status:        model.WebhookEventPending,
+				NextAttemptAt: time.Now().Add(time.Minute),
+			}, nil)
+			Expect(dispatcher.Run(context.Background())).To(Succeed())
+			Expect(received).To(BeNil())
+			Expect(fakeStorage.SaveCallCount()).To(BeZero())
+		})
+
+		It("should deliver the other events while an endpoint is slow", func() {
+			release := make(chan struct{})
+			slowServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				<-release
+			}))
+			defer slowServer.Close()
+			defer close(release)
+
+			slowEvent := &model.WebhookEvent{UUID: "slow-event", EndpointID: "slow-endpoint", MerchantID: "2", Status: model.WebhookEventPending}
+			fakeStorage.ListPageReturns([]model.Object{slowEvent, event}, "", nil)
+			fakeStorage.GetForUpdateStub = func(_ context.Context, _ string, id string) (model.Object, error) {
+				if id == slowEvent.UUID {
+					return slowEvent, nil
+				}
+				return event, nil
+			}
+			fakeStorage.GetStub = func(_ context.Context, _ string, id string) (model.Object, error) {
+				if id == slowEvent.EndpointID {
+					return &model.WebhookEndpoint{UUID: id, URL: slowServer.URL, Secret: "secret"}, nil
+				}
+				return &model.WebhookEndpoint{UUID: id, URL: server.URL, Secret: "secret"}, nil
+			}
+
+			delivered := make(chan string, 2)
+			fakeStorage.SaveStub = func(_ context.Context, object model.Object) error {
+				if saved := object.(*model.WebhookEvent); saved.Status == model.WebhookEventDelivered {
+					delivered <- saved.UUID
+				}
+				return nil
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- dispatcher.Run(context.Background())
+			}()
+			Eventually(delivered).Should(Receive(Equal(event.UUID)))
+			Consistently(done).ShouldNot(Receive())
+		})
+
+		When("endpoint does not accept the event", func() {
+			BeforeEach(func() {
+				responseStatus = http.StatusInternalServerError
+			})
+
+			It("should retry with an exponential backoff", func() {
+				Expect(dispatcher.Run(context.Background())).To(Succeed())
+				Expect(event.Status).To(Equal(model.WebhookEventPending))
+				Expect(event.LastError).To(ContainSubstring("status 500"))
+				Expect(event.NextAttemptAt).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+				// The next attempt is due
+				event.NextAttemptAt = time.Now()
+				Expect(dispatcher.Run(context.Background())).To(Succeed())
+				Expect(event.NextAttemptAt).To(BeTemporally("~", time.Now().Add(2*time.Minute), time.Second))
+			})
+
+			It("should fail the event after the last attempt", func() {
+				for i := 0; i < 3; i++ {
+					event.NextAttemptAt = time.Now()
+					Expect(dispatcher.Run(context.Background())).To(Succeed())
+				}
+				Expect(event.Status).To(Equal(model.WebhookEventFailed))
+				Expect(event.Attempts).To(Equal(3))
+			})
+		})
+	})
+
+	Describe("Liveness", func() {
+		It("should stay up while an endpoint stalls the delivery", func() {
+			release := make(chan struct{})
+			stalledServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				<-release
+			}))
+			defer stalledServer.Close()
+			defer close(release)
+
+			fakeStorage.ListPageReturns([]model.Object{&model.WebhookEvent{UUID: "event-uuid", EndpointID: "endpoint-1", Status: model.WebhookEventPending}}, "", nil)
+			fakeStorage.GetForUpdateStub = func(context.Context, string, string) (model.Object, error) {
+				// Every run finds the event due, so the endpoint stalls every run
+				return &model.WebhookEvent{UUID: "event-uuid", EndpointID: "endpoint-1", Status: model.WebhookEventPending}, nil
+			}
+			fakeStorage.GetReturns(&model.WebhookEndpoint{UUID: "endpoint-1", URL: stalledServer.URL, Secret: "secret"}, nil)
+
+			settings := services.DefaultWebhookSettings()
+			settings.Interval = 20 * time.Millisecond
+			settings.Timeout = 200 * time.Millisecond
+			settings.BatchSize = 2
+			settings.Workers = 1
+			dispatcher := services.NewWebhookDispatcher(settings, fakeStorage, nil)
+			registry := health.NewRegistry(health.DefaultSettings())
+			registry.RegisterLiveness("webhook_dispatcher", dispatcher.Heartbeat())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			dispatcher.Start(ctx)
+
+			Eventually(fakeStorage.GetCallCount).ShouldNot(BeZero())
+			Consistently(func() bool {
+				return registry.Liveness(ctx).Up()
+			}, time.Second, 20*time.Millisecond).Should(BeTrue())
+		})
+	})
+
+	Describe("Redeliver", func() {
+		var webhookService *services.WebhookService
+
+		BeforeEach(func() {
+			webhookService = services.NewWebhookService(fakeStorage)
+		})
+
+		It("should schedule a failed event again", func() {
+			fakeStorage.GetByReturns(&model.WebhookEvent{
+				UUID:     "event-uuid",
+				Status:   model.WebhookEventFailed,
+				Attempts: 3,
+			}, nil)
+			event, err := webhookService.Redeliver(context.Background(), "1", "event-uuid")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(event.Status).To(Equal(model.WebhookEventPending))
+			Expect(event.Attempts).To(Equal(0))
+			Expect(fakeStorage.SaveCallCount()).To(Equal(1))
+		})
+
+		It("should not redeliver events which are not failed", func() {
+			fakeStorage.GetByReturns(&model.WebhookEvent{
+				UUID:   "event-uuid",
+				Status: model.WebhookEventDelivered,
+			}, nil)
+			_, err := webhookService.Redeliver(context.Background(), "1", "event-uuid")
+			Expect(err).To(Equal(services.ErrWebhookEventNotFailed))
+			Expect(fakeStorage.SaveCallCount()).To(Equal(0))
+		})
+	})
+})
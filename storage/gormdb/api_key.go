@@ -0,0 +1,67 @@
+package gormdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/model"
+)
+
+type APIKey struct {
+	UUID       string `gorm:"primary_key"`
+	CreatedAt  time.Time
+	MerchantID string `gorm:"not null;index"`
+	Name       string `gorm:"type:varchar(255);not null"`
+	Mode       string `gorm:"type:varchar(8);not null"`
+	Prefix     string `gorm:"type:varchar(32);unique;not null"`
+	// Scopes are separated with commas
+	Scopes    string `gorm:"not null"`
+	Hash      string `gorm:"type:varchar(64);not null"`
+	RevokedAt *time.Time
+}
+
+func (k *APIKey) InitSQL(db *gorm.DB) error {
+	return db.Model(k).
+		AddForeignKey("merchant_id", "merchants(uuid)", "CASCADE", "RESTRICT").
+		Error
+}
+
+func (k *APIKey) ToObject() model.Object {
+	result := &model.APIKey{
+		UUID:       k.UUID,
+		MerchantID: k.MerchantID,
+		Name:       k.Name,
+		Mode:       model.APIKeyMode(k.Mode),
+		Prefix:     k.Prefix,
+		Scopes:     strings.Split(k.Scopes, ","),
+		Hash:       k.Hash,
+		CreatedAt:  k.CreatedAt,
+	}
+	if k.RevokedAt != nil {
+		result.RevokedAt = *k.RevokedAt
+	}
+	return result
+}
+
+func (k *APIKey) FromObject(o model.Object) (Model, error) {
+	key, ok := o.(*model.APIKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not api key", o.GetType())
+	}
+	result := &APIKey{
+		UUID:       key.UUID,
+		CreatedAt:  key.CreatedAt,
+		MerchantID: key.MerchantID,
+		Name:       key.Name,
+		Mode:       string(key.Mode),
+		Prefix:     key.Prefix,
+		Scopes:     strings.Join(key.Scopes, ","),
+		Hash:       key.Hash,
+	}
+	if !key.RevokedAt.IsZero() {
+		result.RevokedAt = &key.RevokedAt
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package gormdb
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/model"
+)
+
+// ArchivedData is stored as a JSON document, so that the archive does not depend on the schema of the transactions
+type ArchivedData struct {
+	Transaction *model.Transaction               `json:"transaction"`
+	History     []*model.TransactionStatusChange `json:"history"`
+}
+
+func (d *ArchivedData) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("could not scan %T into archived data", value)
+	}
+	return json.Unmarshal(data, d)
+}
+
+func (d ArchivedData) Value() (driver.Value, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+type ArchivedTransaction struct {
+	UUID       string       `gorm:"primary_key"`
+	RootUUID   string       `gorm:"not null;index"`
+	MerchantID string       `gorm:"not null;index"`
+	Data       ArchivedData `gorm:"type:text;not null"`
+	ArchivedAt time.Time    `gorm:"not null"`
+}
+
+func (a *ArchivedTransaction) InitSQL(db *gorm.DB) error {
+	return db.Model(a).
+		AddForeignKey("merchant_id", "merchants(uuid)", "RESTRICT", "RESTRICT").
+		Error
+}
+
+func (a *ArchivedTransaction) ToObject() model.Object {
+	return &model.ArchivedTransaction{
+		UUID:        a.UUID,
+		RootUUID:    a.RootUUID,
+		MerchantID:  a.MerchantID,
+		Transaction: a.Data.Transaction,
+		History:     a.Data.History,
+		ArchivedAt:  a.ArchivedAt,
+	}
+}
+
+func (a *ArchivedTransaction) FromObject(o model.Object) (Model, error) {
+	archived, ok := o.(*model.ArchivedTransaction)
+	if !ok {
+		return nil, fmt.Errorf("%s is not archived transaction", o.GetType())
+	}
+	return &ArchivedTransaction{
+		UUID:       archived.UUID,
+		RootUUID:   archived.RootUUID,
+		MerchantID: archived.MerchantID,
+		Data: ArchivedData{
+			Transaction: archived.Transaction,
+			History:     archived.History,
+		},
+		ArchivedAt: archived.ArchivedAt,
+	}, nil
+}
@@ -0,0 +1,77 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pankrator/payment/log"
+)
+
+// contextQuerier is implemented by both sql.DB and sql.Tx
+type contextQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// contextDB runs the queries of gorm with a context, as gorm does not pass one to database/sql.
+// It cannot begin transactions, so gorm creates and saves objects without wrapping the single statement in one,
+// which would run without the context.
+type contextDB struct {
+	ctx context.Context
+	db  contextQuerier
+}
+
+func (c *contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Prepare(query string) (*sql.Stmt, error) {
+	return c.db.PrepareContext(c.ctx, query)
+}
+
+func (c *contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+// logger writes the errors of gorm to a logrus entry
+type logger struct {
+	entry *logrus.Entry
+}
+
+func (l logger) Print(values ...interface{}) {
+	// gorm logs the kind of the message and its source before the message itself
+	if len(values) > 2 {
+		l.entry.WithField("source", values[1]).Error(values[2:]...)
+		return
+	}
+	l.entry.Error(values...)
+}
+
+// db returns a connection whose queries stop when the context is done or the statement timeout passes.
+// The connection should not be used after the returned function is called.
+func (s *Storage) db(ctx context.Context) (*gorm.DB, context.CancelFunc) {
+	cancel := func() {}
+	if s.settings.StatementTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.settings.StatementTimeout)
+	}
+	querier, ok := s.DB.CommonDB().(contextQuerier)
+	if !ok {
+		return s.DB.New(), cancel
+	}
+	// Opening a connection on an existing database or transaction does not connect again
+	db, err := gorm.Open(s.Dialect().GetName(), &contextDB{ctx: ctx, db: querier})
+	if err != nil {
+		return s.DB.New(), cancel
+	}
+	db.SetLogger(logger{entry: log.C(ctx)})
+	return db, cancel
+}
@@ -0,0 +1,104 @@
+package gormdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/criteria"
+)
+
+// columnMapper is implemented by models which keep some fields of their objects in columns named differently
+type columnMapper interface {
+	// FieldColumns maps the names of the fields in criteria to the names of the columns
+	FieldColumns() map[string]string
+}
+
+// column returns the column of the model in which the field is stored.
+// Columns are part of the statements, so only the ones of the model are accepted.
+func column(scope *gorm.Scope, fieldName string) (string, error) {
+	name := fieldName
+	if mapper, ok := scope.Value.(columnMapper); ok {
+		for field, col := range mapper.FieldColumns() {
+			switch fieldName {
+			case field:
+				name = col
+			case col:
+				// The column is known only by the name of its field
+				return "", fmt.Errorf("unknown field %s", fieldName)
+			}
+		}
+	}
+	if field, ok := scope.FieldByName(name); !ok || field.DBName != name || !field.IsNormal {
+		return "", fmt.Errorf("unknown field %s", fieldName)
+	}
+	return name, nil
+}
+
+// where translates the criterion to a condition with placeholders for the values
+func where(scope *gorm.Scope, c criteria.Criterion) (string, []interface{}, error) {
+	if err := c.Validate(); err != nil {
+		return "", nil, err
+	}
+	return condition(scope, c)
+}
+
+func condition(scope *gorm.Scope, c criteria.Criterion) (string, []interface{}, error) {
+	switch c := c.(type) {
+	case *criteria.Comparison:
+		col, err := column(scope, c.Field)
+		if err != nil {
+			return "", nil, err
+		}
+		switch c.Operator {
+		case criteria.In:
+			return fmt.Sprintf("%s IN (?)", col), []interface{}{c.Values}, nil
+		case criteria.Between:
+			return fmt.Sprintf("%s BETWEEN ? AND ?", col), c.Values, nil
+		case criteria.IsNull, criteria.IsNotNull:
+			return fmt.Sprintf("%s %s", col, strings.ToUpper(string(c.Operator))), nil, nil
+		case criteria.Like:
+			return fmt.Sprintf("%s LIKE ?", col), c.Values, nil
+		default:
+			return fmt.Sprintf("%s %s ?", col, c.Operator), c.Values, nil
+		}
+	case *criteria.Group:
+		if len(c.Criteria) == 0 {
+			if c.Conjunction == criteria.OrConjunction {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		conditions := make([]string, 0, len(c.Criteria))
+		params := make([]interface{}, 0)
+		for _, member := range c.Criteria {
+			memberCondition, memberParams, err := condition(scope, member)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, memberCondition)
+			params = append(params, memberParams...)
+		}
+		separator := fmt.Sprintf(" %s ", strings.ToUpper(string(c.Conjunction)))
+		return "(" + strings.Join(conditions, separator) + ")", params, nil
+	case *criteria.Negation:
+		negated, params, err := condition(scope, c.Criterion)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT (%s)", negated), params, nil
+	}
+	return "", nil, fmt.Errorf("unknown criterion %T", c)
+}
+
+// filter narrows the query to the rows matching the criterion. A nil criterion matches all rows.
+func filter(db *gorm.DB, scope *gorm.Scope, c criteria.Criterion) (*gorm.DB, error) {
+	if c == nil {
+		return db, nil
+	}
+	condition, params, err := where(scope, c)
+	if err != nil {
+		return nil, err
+	}
+	return db.Where(condition, params...), nil
+}
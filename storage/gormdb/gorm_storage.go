@@ -1,23 +1,32 @@
 package gormdb
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"path"
 	"runtime"
 
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
-	"github.com/pankrator/payment/query"
 	"github.com/pankrator/payment/storage"
 
 	"github.com/golang-migrate/migrate"
+	"github.com/golang-migrate/migrate/database"
 	migratepg "github.com/golang-migrate/migrate/database/postgres"
+	migratesqlite "github.com/golang-migrate/migrate/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/source/file"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	// The SQLite connections are opened with the options of this driver, which needs cgo
+	_ "github.com/mattn/go-sqlite3"
 )
 
+// sqliteDialect is the name of the SQLite dialect and database/sql driver
+const sqliteDialect = "sqlite3"
+
 var (
 	_, b, _, _ = runtime.Caller(0)
 	basepath   = path.Dir(b)
@@ -42,27 +51,21 @@ func New(s *storage.Settings) *Storage {
 }
 
 func (s *Storage) Open(connectFunc func(driver, url string) (*sql.DB, error)) error {
-	sslmode := "verify-full"
-	if s.settings.SkipSSLValidation {
-		sslmode = "disable"
-	}
-	dbURI := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
-		s.settings.Username,
-		s.settings.Password,
-		s.settings.Host,
-		s.settings.Port,
-		s.settings.Database,
-		sslmode)
-
-	connection, err := connectFunc("postgres", dbURI)
+	dialect, dbURI, err := s.dataSource()
+	if err != nil {
+		return err
+	}
+
+	connection, err := connectFunc(dialect, dbURI)
 	if err != nil {
 		return err
 	}
 
-	db, err := gorm.Open("postgres", connection)
+	db, err := gorm.Open(dialect, connection)
 	if err != nil {
 		return err
 	}
+	db.SetLogger(logger{entry: log.D()})
 	s.DB = db
 	s.configure()
 
@@ -72,17 +75,38 @@ func (s *Storage) Open(connectFunc func(driver, url string) (*sql.DB, error)) er
 	s.registerModels(model.MerchantType, modelData{
 		singleModel: func() Model { return &Merchant{} },
 	})
+	s.registerModels(model.IdempotencyKeyType, modelData{
+		singleModel: func() Model { return &IdempotencyKey{} },
+	})
+	s.registerModels(model.WebhookEndpointType, modelData{
+		singleModel: func() Model { return &WebhookEndpoint{} },
+	})
+	s.registerModels(model.WebhookEventType, modelData{
+		singleModel: func() Model { return &WebhookEvent{} },
+	})
+	s.registerModels(model.LedgerEntryType, modelData{
+		singleModel: func() Model { return &LedgerEntry{} },
+	})
+	s.registerModels(model.TransactionStatusChangeType, modelData{
+		singleModel: func() Model { return &TransactionStatusChange{} },
+	})
+	s.registerModels(model.ArchivedTransactionType, modelData{
+		singleModel: func() Model { return &ArchivedTransaction{} },
+	})
+	s.registerModels(model.APIKeyType, modelData{
+		singleModel: func() Model { return &APIKey{} },
+	})
 
-	if err := s.migrate(dbURI); err != nil {
+	if err := s.migrate(); err != nil {
 		return err
 	}
 
-	log.Printf("Storage connection opened")
+	log.D().Infof("Storage connection opened")
 
 	return nil
 }
 
-func (s *Storage) Create(object model.Object) (model.Object, error) {
+func (s *Storage) Create(ctx context.Context, object model.Object) (model.Object, error) {
 	dbModelBlueprint, found := s.models[object.GetType()]
 	if !found {
 		return nil, fmt.Errorf("no such model found %s", object.GetType())
@@ -92,8 +116,9 @@ func (s *Storage) Create(object model.Object) (model.Object, error) {
 		return nil, err
 	}
 
-	// TODO: No way found to provide context via Gorm?!
-	err = s.DB.Create(dbModel).Error
+	db, cancel := s.db(ctx)
+	defer cancel()
+	err = db.Create(dbModel).Error
 	if err != nil {
 		// TODO: Wrap storage error
 		return nil, err
@@ -102,7 +127,7 @@ func (s *Storage) Create(object model.Object) (model.Object, error) {
 	return dbModel.ToObject(), nil
 }
 
-func (s *Storage) Save(object model.Object) error {
+func (s *Storage) Save(ctx context.Context, object model.Object) error {
 	dbModelBlueprint, found := s.models[object.GetType()]
 	if !found {
 		return fmt.Errorf("no such model found %s", object.GetType())
@@ -111,46 +136,75 @@ func (s *Storage) Save(object model.Object) error {
 	if err != nil {
 		return err
 	}
-	return s.DB.Save(dbModel).Error
+	db, cancel := s.db(ctx)
+	defer cancel()
+	return db.Save(dbModel).Error
 }
 
-func (s *Storage) Get(typee string, id string) (model.Object, error) {
+func (s *Storage) Get(ctx context.Context, typee string, id string) (model.Object, error) {
 	dbModelBlueprint, found := s.models[typee]
 	if !found {
 		return nil, fmt.Errorf("no such model found %s", typee)
 	}
 	dbModel := dbModelBlueprint.singleModel()
-	result := s.Where("uuid = ?", id).Find(dbModel)
+	db, cancel := s.db(ctx)
+	defer cancel()
+	result := db.Where("uuid = ?", id).Find(dbModel)
 	if result.RecordNotFound() {
 		return nil, storage.ErrNotFound
 	}
 	return dbModel.ToObject(), result.Error
 }
 
-func (s *Storage) GetBy(typee string, condition string, args ...interface{}) (model.Object, error) {
+func (s *Storage) GetForUpdate(ctx context.Context, typee string, id string) (model.Object, error) {
 	dbModelBlueprint, found := s.models[typee]
 	if !found {
 		return nil, fmt.Errorf("no such model found %s", typee)
 	}
 	dbModel := dbModelBlueprint.singleModel()
-	result := s.Where(condition, args...).First(dbModel)
+	db, cancel := s.db(ctx)
+	defer cancel()
+	// SQLite has no row locks. Its transactions take the write lock of the whole database when they begin.
+	if s.Dialect().GetName() != sqliteDialect {
+		db = db.Set("gorm:query_option", "FOR UPDATE")
+	}
+	result := db.Where("uuid = ?", id).Find(dbModel)
 	if result.RecordNotFound() {
 		return nil, storage.ErrNotFound
 	}
 	return dbModel.ToObject(), result.Error
 }
 
-func (s *Storage) List(typee string, q ...query.Query) ([]model.Object, error) {
+func (s *Storage) GetBy(ctx context.Context, typee string, c criteria.Criterion) (model.Object, error) {
 	dbModelBlueprint, found := s.models[typee]
 	if !found {
 		return nil, fmt.Errorf("no such model found %s", typee)
 	}
 	dbModel := dbModelBlueprint.singleModel()
-	tableName := s.DB.NewScope(dbModel).TableName()
-	db := s.DB.Table(tableName)
-	whereClause, params := s.buildWhere(typee, q)
-	if len(params) > 0 {
-		db = db.Where(whereClause, params)
+	db, cancel := s.db(ctx)
+	defer cancel()
+	db, err := filter(db, db.NewScope(dbModel), c)
+	if err != nil {
+		return nil, err
+	}
+	result := db.First(dbModel)
+	if result.RecordNotFound() {
+		return nil, storage.ErrNotFound
+	}
+	return dbModel.ToObject(), result.Error
+}
+
+func (s *Storage) List(ctx context.Context, typee string, c criteria.Criterion) ([]model.Object, error) {
+	dbModelBlueprint, found := s.models[typee]
+	if !found {
+		return nil, fmt.Errorf("no such model found %s", typee)
+	}
+	db, cancel := s.db(ctx)
+	defer cancel()
+	scope := db.NewScope(dbModelBlueprint.singleModel())
+	db, err := filter(db.Table(scope.TableName()), scope, c)
+	if err != nil {
+		return nil, err
 	}
 	rows, err := db.Select("*").Rows()
 	if err != nil {
@@ -160,28 +214,20 @@ func (s *Storage) List(typee string, q ...query.Query) ([]model.Object, error) {
 	return s.rowsToObject(rows, dbModelBlueprint.singleModel)
 }
 
-func (s *Storage) buildWhere(typee string, q []query.Query) (string, []string) {
-	if len(q) < 1 {
-		return "", nil
-	}
-
-	qq := make([]query.Query, 0)
-	for _, iq := range q {
-		if iq.Type == typee {
-			qq = append(qq, iq)
-		}
+func (s *Storage) rowsToObject(rows *sql.Rows, modelGenerator func() Model) ([]model.Object, error) {
+	models, err := s.rowsToModels(rows, modelGenerator)
+	if err != nil {
+		return nil, err
 	}
-	result := ""
-	params := make([]string, 0, len(qq))
-	for _, qi := range qq {
-		result += fmt.Sprintf("%s %s ? AND ", qi.Key, qi.Operation)
-		params = append(params, qi.Value)
+	result := make([]model.Object, 0, len(models))
+	for _, m := range models {
+		result = append(result, m.ToObject())
 	}
-	return result[:len(result)-5], params
+	return result, nil
 }
 
-func (s *Storage) rowsToObject(rows *sql.Rows, modelGenerator func() Model) ([]model.Object, error) {
-	result := make([]model.Object, 0)
+func (s *Storage) rowsToModels(rows *sql.Rows, modelGenerator func() Model) ([]Model, error) {
+	result := make([]Model, 0)
 	defer rows.Close()
 	for rows.Next() {
 		r := modelGenerator()
@@ -189,60 +235,125 @@ func (s *Storage) rowsToObject(rows *sql.Rows, modelGenerator func() Model) ([]m
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, r.ToObject())
+		result = append(result, r)
 	}
 	return result, nil
 }
 
-func (s *Storage) Count(typee string, condition string, args ...interface{}) (int, error) {
+func (s *Storage) Count(ctx context.Context, typee string, c criteria.Criterion) (int, error) {
 	dbModelBlueprint, found := s.models[typee]
 	if !found {
 		return 0, fmt.Errorf("no such model found %s", typee)
 	}
 	dbModel := dbModelBlueprint.singleModel()
+	db, cancel := s.db(ctx)
+	defer cancel()
+	db, err := filter(db.Model(dbModel), db.NewScope(dbModel), c)
+	if err != nil {
+		return 0, err
+	}
 	var count int
-	result := s.New().Model(dbModel).Where(condition, args...).Count(&count)
+	result := db.Count(&count)
 	return count, result.Error
 }
 
-func (s *Storage) DeleteAll(typee string) error {
+func (s *Storage) DeleteAll(ctx context.Context, typee string) error {
 	dbModelBlueprint, found := s.models[typee]
 	if !found {
 		return fmt.Errorf("no such model found %s", typee)
 	}
 	dbModel := dbModelBlueprint.singleModel()
-	return s.DB.Delete(dbModel).Error
+	db, cancel := s.db(ctx)
+	defer cancel()
+	return db.Delete(dbModel).Error
 }
 
-func (s *Storage) Delete(typee string, condition string, args ...interface{}) error {
+func (s *Storage) Delete(ctx context.Context, typee string, c criteria.Criterion) error {
 	dbModelBlueprint, found := s.models[typee]
 	if !found {
 		return fmt.Errorf("no such model found %s", typee)
 	}
+	// DeleteAll is used for deleting all objects, so that it is never done by mistake
+	if c == nil {
+		return fmt.Errorf("no criterion given for deleting %s", typee)
+	}
 	dbModel := dbModelBlueprint.singleModel()
-	return s.DB.Where(condition, args...).Delete(dbModel).Error
+	db, cancel := s.db(ctx)
+	defer cancel()
+	db, err := filter(db, db.NewScope(dbModel), c)
+	if err != nil {
+		return err
+	}
+	return db.Delete(dbModel).Error
 }
 
-func (s *Storage) Transaction(f func(s storage.Storage) error) error {
-	return s.DB.Transaction(func(tx *gorm.DB) error {
-		return f(&Storage{
-			models: s.models,
-			DB:     tx,
-		})
+// Transaction runs f in a transaction bound to the context, so that it is rolled back when the context is done
+func (s *Storage) Transaction(ctx context.Context, f func(s storage.Storage) error) (err error) {
+	tx := s.DB.BeginTx(ctx, &sql.TxOptions{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = f(&Storage{
+		settings: s.settings,
+		models:   s.models,
+		DB:       tx,
 	})
+	if err == nil {
+		err = tx.Commit().Error
+	}
+	panicked = false
+	return err
 }
 
 func (s *Storage) registerModels(typee string, modelProvider modelData) {
 	s.models[typee] = modelProvider
 }
 
-func (s *Storage) migrate(dbURI string) error {
-	driver, err := migratepg.WithInstance(s.DB.DB(), &migratepg.Config{})
+// dataSource returns the dialect of the database and the URI to connect to it
+func (s *Storage) dataSource() (string, string, error) {
+	switch s.settings.Driver {
+	case storage.PostgresDriver:
+		sslmode := "verify-full"
+		if s.settings.SkipSSLValidation {
+			sslmode = "disable"
+		}
+		return "postgres", fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+			s.settings.Username,
+			s.settings.Password,
+			s.settings.Host,
+			s.settings.Port,
+			s.settings.Database,
+			sslmode), nil
+	case storage.SQLiteDriver:
+		// Foreign keys are off by default in SQLite. Transactions take the write lock when they begin,
+		// so that concurrent ones wait for each other instead of failing when they first write.
+		return sqliteDialect, fmt.Sprintf("file:%s?_foreign_keys=1&_txlock=immediate&_busy_timeout=5000&_journal_mode=WAL",
+			s.settings.Database), nil
+	default:
+		return "", "", fmt.Errorf("unsupported storage driver %s", s.settings.Driver)
+	}
+}
+
+func (s *Storage) migrate() error {
+	var driver database.Driver
+	var err error
+	if s.settings.Driver == storage.SQLiteDriver {
+		driver, err = migratesqlite.WithInstance(s.DB.DB(), &migratesqlite.Config{})
+	} else {
+		driver, err = migratepg.WithInstance(s.DB.DB(), &migratepg.Config{})
+	}
 	if err != nil {
 		return fmt.Errorf("could not initialize driver: %s", err)
 	}
 	// TODO: Introduce configuration for migrations path
-	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file://%s/migrations", basepath), "postgres", driver)
+	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file://%s/migrations/%s", basepath, s.settings.Driver), s.settings.Driver, driver)
 	if err != nil {
 		return fmt.Errorf("could not initialize migrate: %s", err)
 	}
@@ -252,7 +363,12 @@ func (s *Storage) migrate(dbURI string) error {
 		return fmt.Errorf("could not execute migrations: %s", err)
 	}
 
-	err = s.DB.Transaction(func(tx *gorm.DB) error {
+	if s.settings.Driver == storage.SQLiteDriver {
+		// The SQLite migrations create the whole schema, as SQLite cannot add foreign keys to existing tables
+		return nil
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
 		for _, m := range s.models {
 			tx.AutoMigrate(m.singleModel())
 		}
@@ -264,7 +380,6 @@ func (s *Storage) migrate(dbURI string) error {
 		}
 		return nil
 	})
-	return err
 }
 
 func (s *Storage) configure() {
@@ -275,3 +390,10 @@ func (s *Storage) configure() {
 func (s *Storage) Close() {
 	s.DB.Close()
 }
+
+func (s *Storage) Ping(ctx context.Context) error {
+	db, cancel := s.db(ctx)
+	defer cancel()
+	// A query checks transactions as well, as they have no connection of their own to ping
+	return db.Exec("SELECT 1").Error
+}
@@ -1,14 +1,18 @@
 package gormdb_test
 
 import (
+	"context"
 	"database/sql"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
 	"github.com/pankrator/payment/storage"
 	"github.com/pankrator/payment/storage/gormdb"
 )
@@ -21,6 +25,7 @@ func TestGormStorageSuite(t *testing.T) {
 var _ = Describe("Gorm storage test", func() {
 	var mock sqlmock.Sqlmock
 	var repository storage.Storage
+	var settings *storage.Settings
 
 	BeforeEach(func() {
 		var err error
@@ -28,7 +33,8 @@ var _ = Describe("Gorm storage test", func() {
 		db, mock, err = sqlmock.New()
 		mock.MatchExpectationsInOrder(false)
 		Expect(err).ShouldNot(HaveOccurred())
-		repository = gormdb.New(&storage.Settings{})
+		settings = &storage.Settings{Driver: storage.PostgresDriver}
+		repository = gormdb.New(settings)
 
 		mock.ExpectQuery(`SELECT CURRENT_DATABASE()`).WillReturnRows(sqlmock.NewRows([]string{"mock"}).FromCSVString("mock"))
 		mock.ExpectQuery(`SELECT COUNT(1)*`).WillReturnRows(sqlmock.NewRows([]string{"mock"}).FromCSVString("1"))
@@ -44,11 +50,10 @@ var _ = Describe("Gorm storage test", func() {
 
 	Describe("Create", func() {
 		It("should insert successfully", func() {
-			mock.ExpectBegin()
 			mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "merchants"`)).
 				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg())
 
-			repository.Create(&model.Merchant{
+			repository.Create(context.Background(), &model.Merchant{
 				Name: "hello",
 			})
 
@@ -57,7 +62,7 @@ var _ = Describe("Gorm storage test", func() {
 
 		When("model is not registered", func() {
 			It("should return an error", func() {
-				_, err := repository.Create(&testModel{})
+				_, err := repository.Create(context.Background(), &testModel{})
 				Expect(err).Should(HaveOccurred())
 			})
 		})
@@ -65,11 +70,10 @@ var _ = Describe("Gorm storage test", func() {
 
 	Describe("Save", func() {
 		It("should update successfully", func() {
-			mock.ExpectBegin()
 			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "merchants"`)).
-				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg())
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg())
 
-			repository.Save(&model.Merchant{
+			repository.Save(context.Background(), &model.Merchant{
 				UUID: "someid",
 				Name: "hello",
 			})
@@ -79,7 +83,7 @@ var _ = Describe("Gorm storage test", func() {
 
 		When("model is not registered", func() {
 			It("should return an error", func() {
-				err := repository.Save(&testModel{})
+				err := repository.Save(context.Background(), &testModel{})
 				Expect(err).Should(HaveOccurred())
 			})
 		})
@@ -90,32 +94,165 @@ var _ = Describe("Gorm storage test", func() {
 			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions"`)).
 				WithArgs(sqlmock.AnyArg())
 
-			repository.Get(model.TransactionObjectType, "someid")
+			repository.Get(context.Background(), model.TransactionObjectType, "someid")
 
 			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
 		})
 
 		When("model is not registered", func() {
 			It("should return an error", func() {
-				_, err := repository.Get("unknown", "someid")
+				_, err := repository.Get(context.Background(), "unknown", "someid")
 				Expect(err).Should(HaveOccurred())
 			})
 		})
 	})
 
+	Describe("context", func() {
+		It("should not query when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := repository.Get(ctx, model.TransactionObjectType, "someid")
+			Expect(err).To(Equal(context.Canceled))
+		})
+
+		It("should cancel statements which exceed the statement timeout", func() {
+			settings.StatementTimeout = 10 * time.Millisecond
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions"`)).
+				WithArgs(sqlmock.AnyArg()).
+				WillDelayFor(time.Second).
+				WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+
+			start := time.Now()
+			_, err := repository.Get(context.Background(), model.TransactionObjectType, "someid")
+			Expect(err).Should(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+	})
+
+	Describe("List", func() {
+		It("should build conditions for the supported operators", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions" WHERE ((type IN ($1,$2) AND amount BETWEEN $3 AND $4 AND transaction_id IS NULL AND customer_email LIKE $5))`)).
+				WithArgs("charge", "refund", 1, 5, "%@mail.com").
+				WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+
+			_, err := repository.List(context.Background(), model.TransactionObjectType, criteria.And(
+				criteria.OneOf("type", model.Charge, model.Refund),
+				criteria.InRange("amount", 1, 5),
+				criteria.Null("depends_on_uuid"),
+				criteria.LikePattern("customer_email", "%@mail.com"),
+			))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		})
+
+		It("should group and negate criteria", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions" WHERE ((merchant_id = $1 AND (status = $2 OR NOT (amount >= $3))))`)).
+				WithArgs("1", "approved", 10).
+				WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+
+			_, err := repository.List(context.Background(), model.TransactionObjectType, criteria.And(
+				criteria.Eq("merchant_id", "1"),
+				criteria.Or(
+					criteria.Eq("status", model.Approved),
+					criteria.Not(criteria.Ge("amount", 10)),
+				),
+			))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		})
+
+		It("should not query by unknown fields", func() {
+			_, err := repository.List(context.Background(), model.TransactionObjectType, criteria.Eq("1 = 1 OR amount", 1))
+			Expect(err).To(MatchError("unknown field 1 = 1 OR amount"))
+
+			_, err = repository.List(context.Background(), model.TransactionObjectType, criteria.Eq("transaction_id", "1"))
+			Expect(err).To(MatchError("unknown field transaction_id"))
+		})
+
+		It("should not accept unknown operators", func() {
+			_, err := repository.List(context.Background(), model.TransactionObjectType,
+				&criteria.Comparison{Field: "amount", Operator: "= 1 OR 1 =", Values: []interface{}{1}},
+			)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("ListPage", func() {
+		It("should order and limit the rows", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions" WHERE (merchant_id = $1) ORDER BY amount DESC, uuid DESC LIMIT 11`)).
+				WithArgs("1").
+				WillReturnRows(sqlmock.NewRows([]string{"uuid", "amount"}).AddRow("a", 10).AddRow("b", 5))
+
+			result, next, err := repository.ListPage(context.Background(), model.TransactionObjectType, query.Page{
+				Limit:      10,
+				OrderBy:    "amount",
+				Descending: true,
+			}, criteria.Eq("merchant_id", "1"))
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).To(HaveLen(2))
+			Expect(next).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		})
+
+		It("should continue after the cursor of the previous page", func() {
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions" ORDER BY amount ASC, uuid ASC LIMIT 2`)).
+				WillReturnRows(sqlmock.NewRows([]string{"uuid", "amount"}).AddRow("a", 5).AddRow("b", 10))
+
+			result, next, err := repository.ListPage(context.Background(), model.TransactionObjectType, query.Page{
+				Limit:   1,
+				OrderBy: "amount",
+			}, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).To(HaveLen(1))
+			Expect(next).ToNot(BeEmpty())
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transactions" WHERE ((amount, uuid) > ($1, $2)) ORDER BY amount ASC, uuid ASC LIMIT 2`)).
+				WithArgs(5, "a").
+				WillReturnRows(sqlmock.NewRows([]string{"uuid", "amount"}).AddRow("b", 10))
+
+			result, next, err = repository.ListPage(context.Background(), model.TransactionObjectType, query.Page{
+				Limit:   1,
+				Cursor:  next,
+				OrderBy: "amount",
+			}, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).To(HaveLen(1))
+			Expect(next).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		})
+
+		It("should not order by unknown columns", func() {
+			_, _, err := repository.ListPage(context.Background(), model.TransactionObjectType, query.Page{
+				Limit:   1,
+				OrderBy: "amount; DROP TABLE transactions",
+			}, nil)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("should not accept an invalid cursor", func() {
+			_, _, err := repository.ListPage(context.Background(), model.TransactionObjectType, query.Page{
+				Limit:  1,
+				Cursor: "invalid",
+			}, nil)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
 	Describe("Count", func() {
 		It("should count successfully", func() {
 			mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "transactions" WHERE (uuid`)).
 				WithArgs(sqlmock.AnyArg())
 
-			repository.Count(model.TransactionObjectType, "uuid = ?", "id")
+			repository.Count(context.Background(), model.TransactionObjectType, criteria.Eq("uuid", "id"))
 
 			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
 		})
 
 		When("model is not registered", func() {
 			It("should return an error", func() {
-				_, err := repository.Count("unknown", "uuid = ?", "id")
+				_, err := repository.Count(context.Background(), "unknown", criteria.Eq("uuid", "id"))
 				Expect(err).Should(HaveOccurred())
 			})
 		})
@@ -0,0 +1,58 @@
+package gormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/model"
+)
+
+type IdempotencyKey struct {
+	UUID        string `gorm:"primary_key"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Key         string `gorm:"column:idempotency_key;type:varchar(255);not null;unique_index:idx_idempotency_keys_merchant_key"`
+	MerchantID  string `gorm:"not null;unique_index:idx_idempotency_keys_merchant_key"`
+	RequestHash string `gorm:"type:varchar(64);not null"`
+	StatusCode  int
+	Body        []byte
+}
+
+func (k *IdempotencyKey) InitSQL(*gorm.DB) error {
+	return nil
+}
+
+func (k *IdempotencyKey) FieldColumns() map[string]string {
+	return map[string]string{
+		"key": "idempotency_key",
+	}
+}
+
+func (k *IdempotencyKey) ToObject() model.Object {
+	return &model.IdempotencyKey{
+		UUID:        k.UUID,
+		Key:         k.Key,
+		MerchantID:  k.MerchantID,
+		RequestHash: k.RequestHash,
+		StatusCode:  k.StatusCode,
+		Body:        k.Body,
+		CreatedAt:   k.CreatedAt,
+	}
+}
+
+func (k *IdempotencyKey) FromObject(o model.Object) (Model, error) {
+	key, ok := o.(*model.IdempotencyKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not idempotency key", o.GetType())
+	}
+	return &IdempotencyKey{
+		UUID:        key.UUID,
+		CreatedAt:   key.CreatedAt,
+		Key:         key.Key,
+		MerchantID:  key.MerchantID,
+		RequestHash: key.RequestHash,
+		StatusCode:  key.StatusCode,
+		Body:        key.Body,
+	}, nil
+}
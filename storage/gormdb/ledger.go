@@ -0,0 +1,60 @@
+package gormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/model"
+)
+
+type LedgerEntry struct {
+	UUID      string `gorm:"primary_key"`
+	CreatedAt time.Time
+	PostingID string `gorm:"not null;index"`
+	// TransactionID is not a foreign key, as the entries are kept after old transactions are cleaned
+	TransactionID string `gorm:"not null;index"`
+	MerchantID    string `gorm:"not null;index"`
+	Account       string `gorm:"type:varchar(32);not null"`
+	Direction     string `gorm:"type:varchar(8);not null"`
+	Amount        int64  `gorm:"not null"`
+	Currency      string `gorm:"type:varchar(3);not null"`
+}
+
+func (e *LedgerEntry) InitSQL(db *gorm.DB) error {
+	return db.Model(e).
+		AddForeignKey("merchant_id", "merchants(uuid)", "RESTRICT", "RESTRICT").
+		Error
+}
+
+func (e *LedgerEntry) ToObject() model.Object {
+	return &model.LedgerEntry{
+		UUID:          e.UUID,
+		PostingID:     e.PostingID,
+		TransactionID: e.TransactionID,
+		MerchantID:    e.MerchantID,
+		Account:       model.Account(e.Account),
+		Direction:     model.EntryDirection(e.Direction),
+		Amount:        e.Amount,
+		Currency:      model.Currency(e.Currency),
+		CreatedAt:     e.CreatedAt,
+	}
+}
+
+func (e *LedgerEntry) FromObject(o model.Object) (Model, error) {
+	entry, ok := o.(*model.LedgerEntry)
+	if !ok {
+		return nil, fmt.Errorf("%s is not ledger entry", o.GetType())
+	}
+	return &LedgerEntry{
+		UUID:          entry.UUID,
+		CreatedAt:     entry.CreatedAt,
+		PostingID:     entry.PostingID,
+		TransactionID: entry.TransactionID,
+		MerchantID:    entry.MerchantID,
+		Account:       string(entry.Account),
+		Direction:     string(entry.Direction),
+		Amount:        entry.Amount,
+		Currency:      string(entry.Currency),
+	}, nil
+}
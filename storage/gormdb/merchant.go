@@ -1,6 +1,8 @@
 package gormdb
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -8,6 +10,36 @@ import (
 	"github.com/pankrator/payment/model"
 )
 
+// Balance is stored as a JSON object of sums per currency code
+type Balance map[string]int64
+
+func (b *Balance) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*b = Balance{}
+		return nil
+	default:
+		return fmt.Errorf("could not scan %T into balance", value)
+	}
+	return json.Unmarshal(data, b)
+}
+
+func (b Balance) Value() (driver.Value, error) {
+	if b == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
 type Merchant struct {
 	UUID                string `gorm:"primary_key"`
 	CreatedAt           time.Time
@@ -16,7 +48,9 @@ type Merchant struct {
 	Description         string
 	Email               string `gorm:"type:varchar(300);unique;not null"`
 	Status              bool
-	TotalTransactionSum int64
+	TotalTransactionSum Balance `gorm:"type:text;not null;default:'{}'"`
+	// AuthorizationValidity is kept in nanoseconds
+	AuthorizationValidity int64 `gorm:"not null;default:0"`
 }
 
 func (m *Merchant) InitSQL(*gorm.DB) error {
@@ -25,12 +59,13 @@ func (m *Merchant) InitSQL(*gorm.DB) error {
 
 func (m *Merchant) ToObject() model.Object {
 	return &model.Merchant{
-		UUID:                m.UUID,
-		Name:                m.Name,
-		Description:         m.Description,
-		Email:               m.Email,
-		Status:              m.Status,
-		TotalTransactionSum: m.TotalTransactionSum,
+		UUID:                  m.UUID,
+		Name:                  m.Name,
+		Description:           m.Description,
+		Email:                 m.Email,
+		Status:                m.Status,
+		TotalTransactionSum:   balanceToObject(m.TotalTransactionSum),
+		AuthorizationValidity: model.Duration(m.AuthorizationValidity),
 	}
 }
 
@@ -40,11 +75,28 @@ func (m *Merchant) FromObject(o model.Object) (Model, error) {
 		return nil, fmt.Errorf("%s is not merchant", o.GetType())
 	}
 	return &Merchant{
-		UUID:                merchant.UUID,
-		Name:                merchant.Name,
-		Description:         merchant.Description,
-		Email:               merchant.Email,
-		Status:              merchant.Status,
-		TotalTransactionSum: merchant.TotalTransactionSum,
+		UUID:                  merchant.UUID,
+		Name:                  merchant.Name,
+		Description:           merchant.Description,
+		Email:                 merchant.Email,
+		Status:                merchant.Status,
+		TotalTransactionSum:   balanceFromObject(merchant.TotalTransactionSum),
+		AuthorizationValidity: int64(merchant.AuthorizationValidity),
 	}, nil
 }
+
+func balanceToObject(b Balance) model.Balance {
+	result := model.Balance{}
+	for currency, sum := range b {
+		result[model.Currency(currency)] = sum
+	}
+	return result
+}
+
+func balanceFromObject(b model.Balance) Balance {
+	result := Balance{}
+	for currency, sum := range b {
+		result[string(currency)] = sum
+	}
+	return result
+}
@@ -0,0 +1,2 @@
+-- Values cannot be removed from an enum type, 'captured' stays in transaction_status
+SELECT 1;
@@ -0,0 +1,2 @@
+-- Renaming a value of the enum renames it in the existing rows too, so the errored transactions are stored as error again
+ALTER TYPE transaction_status RENAME VALUE 'errored' TO 'error';
@@ -0,0 +1,2 @@
+-- The storage names the status errored like the model. The existing rows are renamed with the value.
+ALTER TYPE transaction_status RENAME VALUE 'error' TO 'errored';
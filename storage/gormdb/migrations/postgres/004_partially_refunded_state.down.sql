@@ -0,0 +1,2 @@
+-- Values cannot be removed from an enum type, 'partially_refunded' stays in transaction_status
+SELECT 1;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Only the EUR sums are kept, the sums in other currencies are lost
+ALTER TABLE IF EXISTS merchants ALTER COLUMN total_transaction_sum DROP DEFAULT;
+ALTER TABLE IF EXISTS merchants ALTER COLUMN total_transaction_sum TYPE bigint
+    USING COALESCE((total_transaction_sum::json->>'EUR')::bigint, 0);
+
+ALTER TABLE IF EXISTS transactions DROP COLUMN IF EXISTS currency;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Amounts created before currencies were introduced are in EUR
+ALTER TABLE IF EXISTS transactions ADD COLUMN IF NOT EXISTS currency varchar(3) NOT NULL DEFAULT 'EUR';
+ALTER TABLE IF EXISTS transactions ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE IF EXISTS merchants ALTER COLUMN total_transaction_sum TYPE text
+    USING json_build_object('EUR', COALESCE(total_transaction_sum, 0))::text;
+ALTER TABLE IF EXISTS merchants ALTER COLUMN total_transaction_sum SET DEFAULT '{}';
+ALTER TABLE IF EXISTS merchants ALTER COLUMN total_transaction_sum SET NOT NULL;
+
+COMMIT;
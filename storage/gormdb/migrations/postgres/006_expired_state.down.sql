@@ -0,0 +1,2 @@
+-- Values cannot be removed from an enum type, 'expired' stays in transaction_status
+SELECT 1;
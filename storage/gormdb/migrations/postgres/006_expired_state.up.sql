@@ -0,0 +1 @@
+ALTER TYPE transaction_status ADD VALUE IF NOT EXISTS 'expired';
@@ -0,0 +1,74 @@
+-- SQLite has no enum types, so the schema is created at once and the states and types are stored as text
+
+CREATE TABLE merchants (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    name varchar(300) NOT NULL UNIQUE,
+    description varchar(255),
+    email varchar(300) NOT NULL UNIQUE,
+    status boolean,
+    total_transaction_sum text NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE transactions (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    type varchar(32),
+    amount integer,
+    currency varchar(3) NOT NULL,
+    customer_email varchar(255),
+    customer_phone varchar(255),
+    status varchar(32),
+    captured_amount integer NOT NULL DEFAULT 0,
+    final_capture boolean,
+    refunded_amount integer NOT NULL DEFAULT 0,
+    merchant_id varchar(255) REFERENCES merchants (uuid) ON DELETE RESTRICT ON UPDATE RESTRICT,
+    transaction_id varchar(255) REFERENCES transactions (uuid) ON DELETE SET NULL ON UPDATE RESTRICT
+);
+
+-- Authorizations and charges may have many partial charges and refunds depending on them, but only one reversal
+CREATE UNIQUE INDEX idx_transactions_single_reversal ON transactions (transaction_id) WHERE type = 'reversal';
+
+CREATE TABLE idempotency_keys (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    idempotency_key varchar(255) NOT NULL,
+    merchant_id varchar(255) NOT NULL,
+    request_hash varchar(64) NOT NULL,
+    status_code integer,
+    body blob
+);
+
+CREATE UNIQUE INDEX idx_idempotency_keys_merchant_key ON idempotency_keys (idempotency_key, merchant_id);
+
+CREATE TABLE webhook_endpoints (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    merchant_id varchar(255) NOT NULL REFERENCES merchants (uuid) ON DELETE CASCADE ON UPDATE RESTRICT,
+    url varchar(2048) NOT NULL,
+    secret varchar(128) NOT NULL
+);
+
+CREATE INDEX idx_webhook_endpoints_merchant_id ON webhook_endpoints (merchant_id);
+
+CREATE TABLE webhook_events (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    endpoint_id varchar(255) NOT NULL,
+    merchant_id varchar(255) NOT NULL,
+    event_type varchar(64) NOT NULL,
+    payload blob,
+    status varchar(16) NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at datetime,
+    last_error varchar(255)
+);
+
+CREATE INDEX idx_webhook_events_endpoint_id ON webhook_events (endpoint_id);
+CREATE INDEX idx_webhook_events_merchant_id ON webhook_events (merchant_id);
+CREATE INDEX idx_webhook_events_due ON webhook_events (status, next_attempt_at);
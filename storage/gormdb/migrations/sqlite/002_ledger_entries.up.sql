@@ -0,0 +1,16 @@
+CREATE TABLE ledger_entries (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    posting_id varchar(255) NOT NULL,
+    -- Not a foreign key, as the entries are kept after old transactions are cleaned
+    transaction_id varchar(255) NOT NULL,
+    merchant_id varchar(255) NOT NULL REFERENCES merchants (uuid) ON DELETE RESTRICT ON UPDATE RESTRICT,
+    account varchar(32) NOT NULL,
+    direction varchar(8) NOT NULL,
+    amount integer NOT NULL,
+    currency varchar(3) NOT NULL
+);
+
+CREATE INDEX idx_ledger_entries_posting_id ON ledger_entries (posting_id);
+CREATE INDEX idx_ledger_entries_transaction_id ON ledger_entries (transaction_id);
+CREATE INDEX idx_ledger_entries_merchant_id ON ledger_entries (merchant_id);
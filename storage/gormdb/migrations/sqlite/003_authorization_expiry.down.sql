@@ -0,0 +1,2 @@
+-- The SQLite version in use cannot drop columns, expires_at and authorization_validity stay
+SELECT 1;
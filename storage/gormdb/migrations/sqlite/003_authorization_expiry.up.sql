@@ -0,0 +1,2 @@
+ALTER TABLE transactions ADD COLUMN expires_at datetime;
+ALTER TABLE merchants ADD COLUMN authorization_validity integer NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS transaction_status_history;
+CREATE TABLE transaction_status_history (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    transaction_id varchar(255) NOT NULL REFERENCES transactions (uuid) ON DELETE CASCADE ON UPDATE RESTRICT,
+    from_status varchar(32) NOT NULL,
+    to_status varchar(32) NOT NULL,
+    "trigger" varchar(32) NOT NULL,
+    cause_id varchar(255) NOT NULL,
+    actor varchar(255) NOT NULL
+);
+
+CREATE INDEX idx_transaction_status_history_transaction_id ON transaction_status_history (transaction_id);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS archived_transactions;
+CREATE TABLE archived_transactions (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    root_uuid varchar(255) NOT NULL,
+    merchant_id varchar(255) NOT NULL REFERENCES merchants (uuid) ON DELETE RESTRICT ON UPDATE RESTRICT,
+    data text NOT NULL,
+    archived_at datetime NOT NULL
+);
+
+CREATE INDEX idx_archived_transactions_root_uuid ON archived_transactions (root_uuid);
+CREATE INDEX idx_archived_transactions_merchant_id ON archived_transactions (merchant_id);
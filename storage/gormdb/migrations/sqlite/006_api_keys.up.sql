@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS api_keys;
+CREATE TABLE api_keys (
+    uuid varchar(255) NOT NULL PRIMARY KEY,
+    created_at datetime,
+    merchant_id varchar(255) NOT NULL REFERENCES merchants (uuid) ON DELETE CASCADE ON UPDATE RESTRICT,
+    name varchar(255) NOT NULL,
+    mode varchar(8) NOT NULL,
+    prefix varchar(32) NOT NULL UNIQUE,
+    scopes varchar(255) NOT NULL,
+    hash varchar(64) NOT NULL,
+    revoked_at datetime
+);
+
+CREATE INDEX idx_api_keys_merchant_id ON api_keys (merchant_id);
@@ -0,0 +1,138 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+)
+
+const defaultOrderColumn = "created_at"
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errCursorOrder   = errors.New("the cursor is of a page in a different order")
+)
+
+func (s *Storage) ListPage(ctx context.Context, typee string, page query.Page, c criteria.Criterion) ([]model.Object, string, error) {
+	dbModelBlueprint, found := s.models[typee]
+	if !found {
+		return nil, "", fmt.Errorf("no such model found %s", typee)
+	}
+	db, cancel := s.db(ctx)
+	defer cancel()
+	scope := db.NewScope(dbModelBlueprint.singleModel())
+
+	orderBy := page.OrderBy
+	if orderBy == "" {
+		orderBy = defaultOrderColumn
+	}
+	// The order is not passed as a query parameter, so only columns of the model are accepted
+	orderBy, err := column(scope, orderBy)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot order by %s", page.OrderBy)
+	}
+
+	db, err = filter(db.Table(scope.TableName()), scope, c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The order is kept in the cursor, which continues only the listing in the same order
+	order, direction, comparison := orderBy, "ASC", ">"
+	if page.Descending {
+		order, direction, comparison = "-"+orderBy, "DESC", "<"
+	}
+	if page.Cursor != "" {
+		orderField, _ := scope.FieldByName(orderBy)
+		value, uuid, err := decodeCursor(page.Cursor, order, orderField.Struct.Type)
+		if err != nil {
+			return nil, "", err
+		}
+		// The uuid makes the order unique when several rows have the same value. Rows with null values would not be
+		// compared, so the order column should not be nullable.
+		db = db.Where(fmt.Sprintf("(%s, uuid) %s (?, ?)", orderBy, comparison), value, uuid)
+	}
+	db = db.Order(fmt.Sprintf("%s %s, uuid %s", orderBy, direction, direction))
+	if page.Limit > 0 {
+		// One more row is selected to find out whether there is a next page
+		db = db.Limit(page.Limit + 1)
+	}
+
+	rows, err := db.Select("*").Rows()
+	if err != nil {
+		return nil, "", err
+	}
+	models, err := s.rowsToModels(rows, dbModelBlueprint.singleModel)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if page.Limit > 0 && len(models) > page.Limit {
+		models = models[:page.Limit]
+		next, err = s.encodeCursor(models[len(models)-1], orderBy, order)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	result := make([]model.Object, 0, len(models))
+	for _, m := range models {
+		result = append(result, m.ToObject())
+	}
+	return result, next, nil
+}
+
+// encodeCursor encodes the order of the page, the order value and the uuid of the last row of the page
+func (s *Storage) encodeCursor(last Model, orderBy, order string) (string, error) {
+	scope := s.DB.NewScope(last)
+	orderField, _ := scope.FieldByName(orderBy)
+	uuidField, ok := scope.FieldByName("uuid")
+	if !ok {
+		return "", fmt.Errorf("model %s has no uuid", scope.TableName())
+	}
+
+	data, err := json.Marshal([]interface{}{order, orderField.Field.Interface(), uuidField.Field.Interface()})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes the order value into the type of the order field, so that it is bound like the stored values.
+// SQLite compares times as text, which they would not match if they were bound as JSON strings.
+// Cursors of pages in another order than the given one are not accepted.
+func decodeCursor(cursor, order string, valueType reflect.Type) (interface{}, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", errInvalidCursor
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil || len(values) != 3 {
+		return nil, "", errInvalidCursor
+	}
+	var cursorOrder string
+	if err := json.Unmarshal(values[0], &cursorOrder); err != nil {
+		return nil, "", errInvalidCursor
+	}
+	if cursorOrder != order {
+		return nil, "", errCursorOrder
+	}
+	value := reflect.New(valueType)
+	if err := json.Unmarshal(values[1], value.Interface()); err != nil {
+		return nil, "", errInvalidCursor
+	}
+	var uuid string
+	if err := json.Unmarshal(values[2], &uuid); err != nil {
+		return nil, "", errInvalidCursor
+	}
+	return value.Elem().Interface(), uuid, nil
+}
@@ -0,0 +1,42 @@
+package gormdb_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/gormdb"
+	"github.com/pankrator/payment/storage/storagetest"
+)
+
+var sqliteDir string
+var sqliteRepository *gormdb.Storage
+
+var _ = BeforeSuite(func() {
+	var err error
+	sqliteDir, err = ioutil.TempDir("", "payment-sqlite")
+	Expect(err).ShouldNot(HaveOccurred())
+
+	settings := storage.DefaultSettings()
+	settings.Driver = storage.SQLiteDriver
+	settings.Database = filepath.Join(sqliteDir, "payment.db")
+	sqliteRepository = gormdb.New(settings)
+	Expect(sqliteRepository.Open(func(driver, url string) (*sql.DB, error) {
+		return sql.Open(driver, url)
+	})).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if sqliteRepository != nil && sqliteRepository.DB != nil {
+		sqliteRepository.Close()
+	}
+	os.RemoveAll(sqliteDir)
+})
+
+var _ = storagetest.DescribeConformance("SQLite", func() storage.Storage {
+	return sqliteRepository
+})
@@ -0,0 +1,61 @@
+package gormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/model"
+)
+
+type TransactionStatusChange struct {
+	UUID          string `gorm:"primary_key"`
+	CreatedAt     time.Time
+	TransactionID string `gorm:"not null;index"`
+	FromStatus    string `gorm:"type:varchar(32);not null"`
+	ToStatus      string `gorm:"type:varchar(32);not null"`
+	Trigger       string `gorm:"type:varchar(32);not null"`
+	CauseID       string `gorm:"not null"`
+	Actor         string `gorm:"not null"`
+}
+
+func (*TransactionStatusChange) TableName() string {
+	return "transaction_status_history"
+}
+
+// InitSQL cascades the deletion of transactions, so that the history of old transactions is cleaned with them
+func (c *TransactionStatusChange) InitSQL(db *gorm.DB) error {
+	return db.Model(c).
+		AddForeignKey("transaction_id", "transactions(uuid)", "CASCADE", "RESTRICT").
+		Error
+}
+
+func (c *TransactionStatusChange) ToObject() model.Object {
+	return &model.TransactionStatusChange{
+		UUID:          c.UUID,
+		TransactionID: c.TransactionID,
+		FromStatus:    model.TransactionState(c.FromStatus),
+		ToStatus:      model.TransactionState(c.ToStatus),
+		Trigger:       model.Trigger(c.Trigger),
+		CauseID:       c.CauseID,
+		Actor:         c.Actor,
+		CreatedAt:     c.CreatedAt,
+	}
+}
+
+func (c *TransactionStatusChange) FromObject(o model.Object) (Model, error) {
+	change, ok := o.(*model.TransactionStatusChange)
+	if !ok {
+		return nil, fmt.Errorf("%s is not transaction status change", o.GetType())
+	}
+	return &TransactionStatusChange{
+		UUID:          change.UUID,
+		CreatedAt:     change.CreatedAt,
+		TransactionID: change.TransactionID,
+		FromStatus:    string(change.FromStatus),
+		ToStatus:      string(change.ToStatus),
+		Trigger:       string(change.Trigger),
+		CauseID:       change.CauseID,
+		Actor:         change.Actor,
+	}, nil
+}
@@ -12,15 +12,19 @@ import (
 type TransactionState string
 
 const (
-	Approved TransactionState = "approved"
-	Reversed TransactionState = "reversed"
-	Refunded TransactionState = "refunded"
-	Errored  TransactionState = "error"
+	Approved          TransactionState = "approved"
+	Captured          TransactionState = "captured"
+	Reversed          TransactionState = "reversed"
+	Refunded          TransactionState = "refunded"
+	PartiallyRefunded TransactionState = "partially_refunded"
+	Errored           TransactionState = "errored"
+	Expired           TransactionState = "expired"
 )
 
 func (s *TransactionState) Scan(value interface{}) error {
-	*s = TransactionState(value.([]byte))
-	return nil
+	text, err := scanText(value)
+	*s = TransactionState(text)
+	return err
 }
 
 func (p TransactionState) Value() (driver.Value, error) {
@@ -37,14 +41,29 @@ const (
 )
 
 func (s *TransactionType) Scan(value interface{}) error {
-	*s = TransactionType(value.([]byte))
-	return nil
+	text, err := scanText(value)
+	*s = TransactionType(text)
+	return err
 }
 
 func (p TransactionType) Value() (driver.Value, error) {
 	return string(p), nil
 }
 
+// scanText scans enum values, which Postgres returns as bytes and SQLite as strings
+func scanText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("could not scan %T into text", value)
+	}
+}
+
 type Transaction struct {
 	UUID      string `gorm:"primary_key"`
 	CreatedAt time.Time
@@ -52,10 +71,17 @@ type Transaction struct {
 
 	Type          TransactionType `gorm:"type:transaction_type"`
 	Amount        int
+	Currency      string `gorm:"type:varchar(3);not null"`
 	CustomerEmail string
 	CustomerPhone string
 	Status        TransactionState `gorm:"type:transaction_status"`
 
+	CapturedAmount int `gorm:"not null;default:0"`
+	FinalCapture   bool
+	RefundedAmount int `gorm:"not null;default:0"`
+	// ExpiresAt is null for the transactions which are not authorizations
+	ExpiresAt *time.Time
+
 	Merchant   *Merchant `gorm:"foreignKey:MerchantID"`
 	MerchantID string
 
@@ -68,14 +94,26 @@ func (t *Transaction) InitSQL(db *gorm.DB) error {
 		AddForeignKey("transaction_id", "transactions(uuid)", "SET NULL", "RESTRICT").
 		AddForeignKey("merchant_id", "merchants(uuid)", "RESTRICT", "RESTRICT").
 		Error
+	if err != nil {
+		return err
+	}
 
-	return err
+	// Authorizations and charges may have many partial charges and refunds depending on them, but only one reversal
+	return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_single_reversal
+		ON transactions (transaction_id) WHERE type = 'reversal'`).Error
+}
+
+func (t *Transaction) FieldColumns() map[string]string {
+	return map[string]string{
+		"depends_on_uuid": "transaction_id",
+	}
 }
 
 func (t *Transaction) ToObject() model.Object {
 	result := &model.Transaction{
 		UUID:          t.UUID,
 		Amount:        t.Amount,
+		Currency:      model.Currency(t.Currency),
 		CustomerEmail: t.CustomerEmail,
 		CustomerPhone: t.CustomerPhone,
 		Type:          model.TransactionType(t.Type),
@@ -83,6 +121,10 @@ func (t *Transaction) ToObject() model.Object {
 		MerchantID:    t.MerchantID,
 		CreatedAt:     t.CreatedAt,
 		UpdatedAt:     t.UpdatedAt,
+
+		CapturedAmount: t.CapturedAmount,
+		FinalCapture:   t.FinalCapture,
+		RefundedAmount: t.RefundedAmount,
 	}
 
 	if t.TransactionID != nil {
@@ -101,14 +143,24 @@ func (t *Transaction) FromObject(o model.Object) (Model, error) {
 		UUID:          transaction.UUID,
 		MerchantID:    transaction.MerchantID,
 		Amount:        transaction.Amount,
+		Currency:      string(transaction.Currency),
 		CustomerEmail: transaction.CustomerEmail,
 		CustomerPhone: transaction.CustomerPhone,
 		Type:          TransactionType(transaction.Type),
 		Status:        TransactionState(transaction.Status),
+		CreatedAt:     transaction.CreatedAt,
+		UpdatedAt:     transaction.UpdatedAt,
+
+		CapturedAmount: transaction.CapturedAmount,
+		FinalCapture:   transaction.FinalCapture,
+		RefundedAmount: transaction.RefundedAmount,
 	}
 
 	if transaction.DependsOnUUID != "" {
 		result.TransactionID = &transaction.DependsOnUUID
 	}
+	if !transaction.ExpiresAt.IsZero() {
+		result.ExpiresAt = &transaction.ExpiresAt
+	}
 	return result, nil
 }
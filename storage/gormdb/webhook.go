@@ -0,0 +1,100 @@
+package gormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pankrator/payment/model"
+)
+
+type WebhookEndpoint struct {
+	UUID       string `gorm:"primary_key"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	MerchantID string `gorm:"not null;index"`
+	URL        string `gorm:"type:varchar(2048);not null"`
+	Secret     string `gorm:"type:varchar(128);not null"`
+}
+
+func (e *WebhookEndpoint) InitSQL(db *gorm.DB) error {
+	return db.Model(e).
+		AddForeignKey("merchant_id", "merchants(uuid)", "CASCADE", "RESTRICT").
+		Error
+}
+
+func (e *WebhookEndpoint) ToObject() model.Object {
+	return &model.WebhookEndpoint{
+		UUID:       e.UUID,
+		MerchantID: e.MerchantID,
+		URL:        e.URL,
+		Secret:     e.Secret,
+		CreatedAt:  e.CreatedAt,
+	}
+}
+
+func (e *WebhookEndpoint) FromObject(o model.Object) (Model, error) {
+	endpoint, ok := o.(*model.WebhookEndpoint)
+	if !ok {
+		return nil, fmt.Errorf("%s is not webhook endpoint", o.GetType())
+	}
+	return &WebhookEndpoint{
+		UUID:       endpoint.UUID,
+		CreatedAt:  endpoint.CreatedAt,
+		MerchantID: endpoint.MerchantID,
+		URL:        endpoint.URL,
+		Secret:     endpoint.Secret,
+	}, nil
+}
+
+type WebhookEvent struct {
+	UUID          string `gorm:"primary_key"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	EndpointID    string `gorm:"not null;index"`
+	MerchantID    string `gorm:"not null;index"`
+	EventType     string `gorm:"type:varchar(64);not null"`
+	Payload       []byte
+	Status        string    `gorm:"type:varchar(16);not null;index:idx_webhook_events_due"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index:idx_webhook_events_due"`
+	LastError     string
+}
+
+func (e *WebhookEvent) InitSQL(*gorm.DB) error {
+	return nil
+}
+
+func (e *WebhookEvent) ToObject() model.Object {
+	return &model.WebhookEvent{
+		UUID:          e.UUID,
+		EndpointID:    e.EndpointID,
+		MerchantID:    e.MerchantID,
+		EventType:     e.EventType,
+		Payload:       e.Payload,
+		Status:        model.WebhookEventState(e.Status),
+		Attempts:      e.Attempts,
+		NextAttemptAt: e.NextAttemptAt,
+		LastError:     e.LastError,
+		CreatedAt:     e.CreatedAt,
+	}
+}
+
+func (e *WebhookEvent) FromObject(o model.Object) (Model, error) {
+	event, ok := o.(*model.WebhookEvent)
+	if !ok {
+		return nil, fmt.Errorf("%s is not webhook event", o.GetType())
+	}
+	return &WebhookEvent{
+		UUID:          event.UUID,
+		CreatedAt:     event.CreatedAt,
+		EndpointID:    event.EndpointID,
+		MerchantID:    event.MerchantID,
+		EventType:     event.EventType,
+		Payload:       event.Payload,
+		Status:        string(event.Status),
+		Attempts:      event.Attempts,
+		NextAttemptAt: event.NextAttemptAt,
+		LastError:     event.LastError,
+	}, nil
+}
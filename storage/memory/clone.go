@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"reflect"
+
+	"github.com/pankrator/payment/model"
+)
+
+// clone copies the object deeply, so that changes of the copy do not affect the stored objects
+func clone(object model.Object) model.Object {
+	return cloneValue(reflect.ValueOf(object)).Interface().(model.Object)
+}
+
+func cloneAll(objects []model.Object) []model.Object {
+	result := make([]model.Object, 0, len(objects))
+	for _, object := range objects {
+		result = append(result, clone(object))
+	}
+	return result
+}
+
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.New(v.Type().Elem())
+		result.Elem().Set(cloneValue(v.Elem()))
+		return result
+	case reflect.Struct:
+		result := reflect.New(v.Type()).Elem()
+		result.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			// Unexported fields, e.g. the ones of time.Time, are kept as they are
+			if v.Type().Field(i).PkgPath == "" {
+				result.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+		return result
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			result.SetMapIndex(iter.Key(), cloneValue(iter.Value()))
+		}
+		return result
+	default:
+		return v
+	}
+}
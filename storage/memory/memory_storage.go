@@ -0,0 +1,431 @@
+// Package memory keeps the objects in memory, so that the services can run without a database.
+// Writes are serialized and transactions work on a snapshot which replaces the stored objects when they succeed.
+// Foreign keys are not enforced.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+)
+
+type entry struct {
+	object model.Object
+	// sequence keeps the order in which the objects were created
+	sequence int64
+}
+
+type table struct {
+	// objectType is the struct type of the objects
+	objectType reflect.Type
+	// unique lists the sets of fields which no two objects have the same values of
+	unique  [][]string
+	entries map[string]entry
+}
+
+type state struct {
+	tables   map[string]*table
+	sequence int64
+}
+
+func (st *state) copy() *state {
+	result := &state{
+		tables:   make(map[string]*table, len(st.tables)),
+		sequence: st.sequence,
+	}
+	for typee, t := range st.tables {
+		entries := make(map[string]entry, len(t.entries))
+		for id, e := range t.entries {
+			entries[id] = e
+		}
+		result.tables[typee] = &table{
+			objectType: t.objectType,
+			unique:     t.unique,
+			entries:    entries,
+		}
+	}
+	return result
+}
+
+func (st *state) table(typee string) (*table, error) {
+	t, found := st.tables[typee]
+	if !found {
+		return nil, fmt.Errorf("no such model found %s", typee)
+	}
+	return t, nil
+}
+
+type database struct {
+	// writeMutex serializes the writes and the transactions
+	writeMutex sync.Mutex
+	// mutex guards the committed state
+	mutex sync.RWMutex
+	state *state
+}
+
+type Storage struct {
+	db *database
+	// state is the snapshot of a transaction. It is nil outside transactions.
+	state *state
+}
+
+func New() *Storage {
+	s := &Storage{
+		db: &database{
+			state: &state{
+				tables: make(map[string]*table),
+			},
+		},
+	}
+
+	s.registerModel(&model.Transaction{})
+	s.registerModel(&model.Merchant{}, []string{"name"}, []string{"email"})
+	s.registerModel(&model.IdempotencyKey{}, []string{"merchant_id", "key"})
+	s.registerModel(&model.WebhookEndpoint{})
+	s.registerModel(&model.WebhookEvent{})
+	s.registerModel(&model.LedgerEntry{})
+	s.registerModel(&model.TransactionStatusChange{})
+	s.registerModel(&model.ArchivedTransaction{})
+	s.registerModel(&model.APIKey{}, []string{"prefix"})
+	return s
+}
+
+func (s *Storage) registerModel(blueprint model.Object, unique ...[]string) {
+	s.db.state.tables[blueprint.GetType()] = &table{
+		objectType: reflect.TypeOf(blueprint).Elem(),
+		unique:     unique,
+		entries:    make(map[string]entry),
+	}
+}
+
+func (s *Storage) Open(func(string, string) (*sql.DB, error)) error {
+	return nil
+}
+
+func (s *Storage) Close() {
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// read runs f on the objects unless the context is done
+func (s *Storage) read(ctx context.Context, f func(st *state) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.state != nil {
+		return f(s.state)
+	}
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+	return f(s.db.state)
+}
+
+// write runs f on the objects unless the context is done
+func (s *Storage) write(ctx context.Context, f func(st *state) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.state != nil {
+		return f(s.state)
+	}
+	s.db.writeMutex.Lock()
+	defer s.db.writeMutex.Unlock()
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+	return f(s.db.state)
+}
+
+func (s *Storage) Create(ctx context.Context, object model.Object) (model.Object, error) {
+	var result model.Object
+	err := s.write(ctx, func(st *state) error {
+		t, err := st.table(object.GetType())
+		if err != nil {
+			return err
+		}
+		id := uuidOf(object)
+		if _, found := t.entries[id]; found {
+			return fmt.Errorf("%s with uuid %s already exists", object.GetType(), id)
+		}
+
+		stored := clone(object)
+		now := time.Now()
+		setTimeIfZero(stored, "CreatedAt", now)
+		setTimeIfZero(stored, "UpdatedAt", now)
+		if err := t.checkUnique(stored); err != nil {
+			return err
+		}
+
+		st.sequence++
+		t.entries[id] = entry{
+			object:   stored,
+			sequence: st.sequence,
+		}
+		result = clone(stored)
+		return nil
+	})
+	return result, err
+}
+
+// Save replaces the stored object with the same uuid or creates it when there is none
+func (s *Storage) Save(ctx context.Context, object model.Object) error {
+	return s.write(ctx, func(st *state) error {
+		t, err := st.table(object.GetType())
+		if err != nil {
+			return err
+		}
+		id := uuidOf(object)
+		existing, found := t.entries[id]
+
+		stored := clone(object)
+		if found {
+			setTimeIfZero(stored, "CreatedAt", timeOf(existing.object, "CreatedAt"))
+		} else {
+			st.sequence++
+			existing.sequence = st.sequence
+			setTimeIfZero(stored, "CreatedAt", time.Now())
+		}
+		setTime(stored, "UpdatedAt", time.Now())
+		if err := t.checkUnique(stored); err != nil {
+			return err
+		}
+
+		t.entries[id] = entry{
+			object:   stored,
+			sequence: existing.sequence,
+		}
+		return nil
+	})
+}
+
+func (s *Storage) DeleteAll(ctx context.Context, typee string) error {
+	return s.write(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		t.entries = make(map[string]entry)
+		return nil
+	})
+}
+
+func (s *Storage) Delete(ctx context.Context, typee string, c criteria.Criterion) error {
+	// DeleteAll is used for deleting all objects, so that it is never done by mistake
+	if c == nil {
+		return fmt.Errorf("no criterion given for deleting %s", typee)
+	}
+	return s.write(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		matched, err := t.list(c)
+		if err != nil {
+			return err
+		}
+		for _, object := range matched {
+			delete(t.entries, uuidOf(object))
+		}
+		return nil
+	})
+}
+
+func (s *Storage) Get(ctx context.Context, typee string, id string) (model.Object, error) {
+	var result model.Object
+	err := s.read(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		e, found := t.entries[id]
+		if !found {
+			return storage.ErrNotFound
+		}
+		result = clone(e.object)
+		return nil
+	})
+	return result, err
+}
+
+// GetForUpdate gets the object. Writes are serialized, so there is no need to lock it.
+func (s *Storage) GetForUpdate(ctx context.Context, typee string, id string) (model.Object, error) {
+	return s.Get(ctx, typee, id)
+}
+
+// GetBy gets the object with the least uuid of the ones matching the criterion
+func (s *Storage) GetBy(ctx context.Context, typee string, c criteria.Criterion) (model.Object, error) {
+	var result model.Object
+	err := s.read(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		matched, err := t.list(c)
+		if err != nil {
+			return err
+		}
+		if len(matched) == 0 {
+			return storage.ErrNotFound
+		}
+		sort.Slice(matched, func(i, j int) bool {
+			return uuidOf(matched[i]) < uuidOf(matched[j])
+		})
+		result = clone(matched[0])
+		return nil
+	})
+	return result, err
+}
+
+// List lists the objects matching the criterion in the order of their creation
+func (s *Storage) List(ctx context.Context, typee string, c criteria.Criterion) ([]model.Object, error) {
+	var result []model.Object
+	err := s.read(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		matched, err := t.list(c)
+		if err != nil {
+			return err
+		}
+		result = cloneAll(matched)
+		return nil
+	})
+	return result, err
+}
+
+func (s *Storage) Count(ctx context.Context, typee string, c criteria.Criterion) (int, error) {
+	var count int
+	err := s.read(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		matched, err := t.list(c)
+		count = len(matched)
+		return err
+	})
+	return count, err
+}
+
+// Transaction runs f on a snapshot of the objects. The snapshot replaces the stored objects when f succeeds.
+// Other transactions and writes wait for the transaction to finish, while reads see the objects before it.
+// The snapshot is dropped when the context is done before f returns.
+func (s *Storage) Transaction(ctx context.Context, f func(s storage.Storage) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.state != nil {
+		tx := &Storage{db: s.db, state: s.state.copy()}
+		if err := f(tx); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		*s.state = *tx.state
+		return nil
+	}
+
+	s.db.writeMutex.Lock()
+	defer s.db.writeMutex.Unlock()
+
+	s.db.mutex.RLock()
+	tx := &Storage{db: s.db, state: s.db.state.copy()}
+	s.db.mutex.RUnlock()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mutex.Lock()
+	s.db.state = tx.state
+	s.db.mutex.Unlock()
+	return nil
+}
+
+// list returns the stored objects matching the criterion in the order of their creation
+func (t *table) list(c criteria.Criterion) ([]model.Object, error) {
+	entries := make([]entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sequence < entries[j].sequence
+	})
+
+	objects := make([]model.Object, 0, len(entries))
+	for _, e := range entries {
+		objects = append(objects, e.object)
+	}
+	return criteria.Filter(objects, c)
+}
+
+func (t *table) checkUnique(object model.Object) error {
+	id := uuidOf(object)
+	for _, fields := range t.unique {
+		for otherID, e := range t.entries {
+			if otherID == id {
+				continue
+			}
+			same := true
+			for _, field := range fields {
+				if !reflect.DeepEqual(fieldValue(object, field).Interface(), fieldValue(e.object, field).Interface()) {
+					same = false
+					break
+				}
+			}
+			if same {
+				return fmt.Errorf("%s with the same %s already exists", object.GetType(), strings.Join(fields, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func fieldValue(object model.Object, name string) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(object))
+	field, ok := criteria.FieldByName(v.Type(), name)
+	if !ok {
+		panic(fmt.Sprintf("%s has no field %s", object.GetType(), name))
+	}
+	return v.FieldByIndex(field.Index)
+}
+
+func uuidOf(object model.Object) string {
+	return fieldValue(object, "uuid").String()
+}
+
+func timeOf(object model.Object, fieldName string) time.Time {
+	field := reflect.Indirect(reflect.ValueOf(object)).FieldByName(fieldName)
+	if !field.IsValid() {
+		return time.Time{}
+	}
+	return field.Interface().(time.Time)
+}
+
+func setTime(object model.Object, fieldName string, t time.Time) {
+	field := reflect.Indirect(reflect.ValueOf(object)).FieldByName(fieldName)
+	if field.IsValid() && field.Type() == reflect.TypeOf(t) {
+		field.Set(reflect.ValueOf(t))
+	}
+}
+
+func setTimeIfZero(object model.Object, fieldName string, t time.Time) {
+	if timeOf(object, fieldName).IsZero() {
+		setTime(object, fieldName, t)
+	}
+}
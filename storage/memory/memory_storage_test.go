@@ -0,0 +1,68 @@
+package memory_test
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/memory"
+	"github.com/pankrator/payment/storage/storagetest"
+)
+
+var _ = storagetest.DescribeConformance("Memory", func() storage.Storage {
+	return memory.New()
+})
+
+var _ = Describe("Memory storage", func() {
+	var repository *memory.Storage
+
+	BeforeEach(func() {
+		repository = memory.New()
+		_, err := repository.Create(context.Background(), &model.Merchant{
+			UUID:                "1",
+			Name:                "merchant",
+			Email:               "merchant@mail.com",
+			TotalTransactionSum: model.Balance{model.EUR: 10},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should not share objects with the callers", func() {
+		object, err := repository.Get(context.Background(), model.MerchantType, "1")
+		Expect(err).ShouldNot(HaveOccurred())
+		object.(*model.Merchant).TotalTransactionSum.Add(model.EUR, 5)
+
+		object, err = repository.Get(context.Background(), model.MerchantType, "1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Merchant).TotalTransactionSum[model.EUR]).To(Equal(int64(10)))
+	})
+
+	It("should serialize transactions", func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				err := repository.Transaction(context.Background(), func(tx storage.Storage) error {
+					object, err := tx.GetForUpdate(context.Background(), model.MerchantType, "1")
+					if err != nil {
+						return err
+					}
+					merchant := object.(*model.Merchant)
+					merchant.TotalTransactionSum.Add(model.EUR, 1)
+					return tx.Save(context.Background(), merchant)
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+
+		object, err := repository.Get(context.Background(), model.MerchantType, "1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(object.(*model.Merchant).TotalTransactionSum[model.EUR]).To(Equal(int64(30)))
+	})
+})
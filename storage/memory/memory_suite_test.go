@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memory Storage Suite")
+}
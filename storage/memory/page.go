@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+)
+
+const defaultOrderField = "created_at"
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errCursorOrder   = errors.New("the cursor is of a page in a different order")
+)
+
+func (s *Storage) ListPage(ctx context.Context, typee string, page query.Page, c criteria.Criterion) ([]model.Object, string, error) {
+	var result []model.Object
+	var next string
+	err := s.read(ctx, func(st *state) error {
+		t, err := st.table(typee)
+		if err != nil {
+			return err
+		}
+		matched, err := t.list(c)
+		if err != nil {
+			return err
+		}
+		result, next, err = listPage(t.objectType, matched, page)
+		return err
+	})
+	return result, next, err
+}
+
+func listPage(objectType reflect.Type, objects []model.Object, page query.Page) ([]model.Object, string, error) {
+	orderBy := page.OrderBy
+	if orderBy == "" {
+		orderBy = defaultOrderField
+	}
+	orderField, ok := criteria.FieldByName(objectType, orderBy)
+	if !ok {
+		return nil, "", fmt.Errorf("cannot order by %s", orderBy)
+	}
+	// The order is kept in the cursor, which continues only the listing in the same order
+	order := orderBy
+	if page.Descending {
+		order = "-" + orderBy
+	}
+	var cursorValue interface{}
+	var cursorID string
+	if page.Cursor != "" {
+		var err error
+		if cursorValue, cursorID, err = decodeCursor(page.Cursor, order, orderField.Type); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// compare orders the objects by the field and then by the uuid, which makes the order unique
+	compare := func(value interface{}, id string, object model.Object) (int, error) {
+		result, err := criteria.Compare(value, fieldValue(object, orderBy).Interface())
+		if err != nil || result != 0 {
+			return result, err
+		}
+		switch other := uuidOf(object); {
+		case id < other:
+			return -1, nil
+		case id > other:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	direction := 1
+	if page.Descending {
+		direction = -1
+	}
+
+	var sortErr error
+	sort.SliceStable(objects, func(i, j int) bool {
+		result, err := compare(fieldValue(objects[i], orderBy).Interface(), uuidOf(objects[i]), objects[j])
+		if err != nil {
+			sortErr = err
+		}
+		return result*direction < 0
+	})
+	if sortErr != nil {
+		return nil, "", sortErr
+	}
+
+	if page.Cursor != "" {
+		after := make([]model.Object, 0, len(objects))
+		for _, object := range objects {
+			result, err := compare(cursorValue, cursorID, object)
+			if err != nil {
+				return nil, "", errInvalidCursor
+			}
+			if result*direction < 0 {
+				after = append(after, object)
+			}
+		}
+		objects = after
+	}
+
+	next := ""
+	if page.Limit > 0 && len(objects) > page.Limit {
+		objects = objects[:page.Limit]
+		last := objects[len(objects)-1]
+		data, err := json.Marshal([]interface{}{order, fieldValue(last, orderBy).Interface(), uuidOf(last)})
+		if err != nil {
+			return nil, "", err
+		}
+		next = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return cloneAll(objects), next, nil
+}
+
+// decodeCursor decodes the order value of the last object of the previous page into the type of the order field.
+// Cursors of pages in another order than the given one are not accepted.
+func decodeCursor(cursor, order string, valueType reflect.Type) (interface{}, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", errInvalidCursor
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil || len(values) != 3 {
+		return nil, "", errInvalidCursor
+	}
+	var cursorOrder string
+	if err := json.Unmarshal(values[0], &cursorOrder); err != nil {
+		return nil, "", errInvalidCursor
+	}
+	if cursorOrder != order {
+		return nil, "", errCursorOrder
+	}
+	value := reflect.New(valueType)
+	if err := json.Unmarshal(values[1], value.Interface()); err != nil {
+		return nil, "", errInvalidCursor
+	}
+	var id string
+	if err := json.Unmarshal(values[2], &id); err != nil {
+		return nil, "", errInvalidCursor
+	}
+	return value.Elem().Interface(), id, nil
+}
@@ -1,59 +1,91 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/query"
 )
 
 var ErrNotFound error = errors.New("not found in storage")
 
+// Storage keeps the objects of the application. Its operations stop when their context is done.
+//
 //go:generate counterfeiter . Storage
 type Storage interface {
 	Open(func(string, string) (*sql.DB, error)) error
 	Close()
+	// Ping checks that the storage is reachable
+	Ping(ctx context.Context) error
 
-	Create(object model.Object) (model.Object, error)
-	Save(object model.Object) error
-	DeleteAll(typee string) error
-	Delete(typee string, condition string, args ...interface{}) error
-	Get(typee string, id string) (model.Object, error)
-	GetBy(typee string, condition string, args ...interface{}) (model.Object, error)
-	List(typee string, q ...query.Query) ([]model.Object, error)
-	Count(typee string, condition string, args ...interface{}) (int, error)
+	Create(ctx context.Context, object model.Object) (model.Object, error)
+	Save(ctx context.Context, object model.Object) error
+	DeleteAll(ctx context.Context, typee string) error
+	// Delete deletes the objects matching the criterion
+	Delete(ctx context.Context, typee string, c criteria.Criterion) error
+	Get(ctx context.Context, typee string, id string) (model.Object, error)
+	// GetForUpdate gets the object and locks it until the end of the surrounding transaction
+	GetForUpdate(ctx context.Context, typee string, id string) (model.Object, error)
+	// GetBy gets the first object matching the criterion
+	GetBy(ctx context.Context, typee string, c criteria.Criterion) (model.Object, error)
+	// List lists the objects matching the criterion. A nil criterion matches all objects.
+	List(ctx context.Context, typee string, c criteria.Criterion) ([]model.Object, error)
+	// ListPage lists a page of the objects ordered by page.OrderBy and returns the cursor of the next page.
+	// The cursor is empty when there are no more objects.
+	ListPage(ctx context.Context, typee string, page query.Page, c criteria.Criterion) ([]model.Object, string, error)
+	Count(ctx context.Context, typee string, c criteria.Criterion) (int, error)
 
-	Transaction(f func(s Storage) error) error
+	// Transaction runs f in a transaction, which is rolled back when f fails or the context is done
+	Transaction(ctx context.Context, f func(s Storage) error) error
 }
 
+// Drivers of the storage
+const (
+	PostgresDriver = "postgres"
+	// SQLiteDriver keeps the objects in a single file, whose path is the database setting
+	SQLiteDriver = "sqlite"
+	// MemoryDriver keeps the objects in memory. They are lost when the application stops.
+	MemoryDriver = "memory"
+)
+
 type Settings struct {
+	Driver            string `mapstructure:"driver"`
 	Host              string `mapstructure:"host"`
 	Port              string `mapstructure:"port"`
 	Database          string `mapstructure:"database"`
 	Username          string `mapstructure:"username"`
 	Password          string `mapstructure:"password"`
 	SkipSSLValidation bool   `mapstructure:"skip_ssl_validation"`
+	// StatementTimeout limits the duration of each statement. Zero means no limit.
+	StatementTimeout time.Duration `mapstructure:"statement_timeout"`
 }
 
 func DefaultSettings() *Settings {
 	return &Settings{
+		Driver:            PostgresDriver,
 		Host:              "127.0.0.1",
 		Port:              "5432",
 		Database:          "payment",
 		Username:          "payment",
 		Password:          "payment",
 		SkipSSLValidation: true,
+		StatementTimeout:  30 * time.Second,
 	}
 }
 
 func (s *Settings) Keys() []string {
 	return []string{
+		"driver",
 		"host",
 		"port",
 		"database",
 		"username",
 		"password",
 		"skip_ssl_validation",
+		"statement_timeout",
 	}
 }
@@ -2,9 +2,11 @@
 package storagefakes
 
 import (
+	"context"
 	"database/sql"
 	"sync"
 
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/query"
 	"github.com/pankrator/payment/storage"
@@ -15,12 +17,12 @@ type FakeStorage struct {
 	closeMutex       sync.RWMutex
 	closeArgsForCall []struct {
 	}
-	CountStub        func(string, string, ...interface{}) (int, error)
+	CountStub        func(context.Context, string, criteria.Criterion) (int, error)
 	countMutex       sync.RWMutex
 	countArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []interface{}
+		arg3 criteria.Criterion
 	}
 	countReturns struct {
 		result1 int
@@ -30,10 +32,11 @@ type FakeStorage struct {
 		result1 int
 		result2 error
 	}
-	CreateStub        func(model.Object) (model.Object, error)
+	CreateStub        func(context.Context, model.Object) (model.Object, error)
 	createMutex       sync.RWMutex
 	createArgsForCall []struct {
-		arg1 model.Object
+		arg1 context.Context
+		arg2 model.Object
 	}
 	createReturns struct {
 		result1 model.Object
@@ -43,12 +46,12 @@ type FakeStorage struct {
 		result1 model.Object
 		result2 error
 	}
-	DeleteStub        func(string, string, ...interface{}) error
+	DeleteStub        func(context.Context, string, criteria.Criterion) error
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []interface{}
+		arg3 criteria.Criterion
 	}
 	deleteReturns struct {
 		result1 error
@@ -56,10 +59,11 @@ type FakeStorage struct {
 	deleteReturnsOnCall map[int]struct {
 		result1 error
 	}
-	DeleteAllStub        func(string) error
+	DeleteAllStub        func(context.Context, string) error
 	deleteAllMutex       sync.RWMutex
 	deleteAllArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	deleteAllReturns struct {
 		result1 error
@@ -67,11 +71,12 @@ type FakeStorage struct {
 	deleteAllReturnsOnCall map[int]struct {
 		result1 error
 	}
-	GetStub        func(string, string) (model.Object, error)
+	GetStub        func(context.Context, string, string) (model.Object, error)
 	getMutex       sync.RWMutex
 	getArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
+		arg3 string
 	}
 	getReturns struct {
 		result1 model.Object
@@ -81,12 +86,12 @@ type FakeStorage struct {
 		result1 model.Object
 		result2 error
 	}
-	GetByStub        func(string, string, ...interface{}) (model.Object, error)
+	GetByStub        func(context.Context, string, criteria.Criterion) (model.Object, error)
 	getByMutex       sync.RWMutex
 	getByArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []interface{}
+		arg3 criteria.Criterion
 	}
 	getByReturns struct {
 		result1 model.Object
@@ -96,11 +101,27 @@ type FakeStorage struct {
 		result1 model.Object
 		result2 error
 	}
-	ListStub        func(string, ...query.Query) ([]model.Object, error)
+	GetForUpdateStub        func(context.Context, string, string) (model.Object, error)
+	getForUpdateMutex       sync.RWMutex
+	getForUpdateArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	getForUpdateReturns struct {
+		result1 model.Object
+		result2 error
+	}
+	getForUpdateReturnsOnCall map[int]struct {
+		result1 model.Object
+		result2 error
+	}
+	ListStub        func(context.Context, string, criteria.Criterion) ([]model.Object, error)
 	listMutex       sync.RWMutex
 	listArgsForCall []struct {
-		arg1 string
-		arg2 []query.Query
+		arg1 context.Context
+		arg2 string
+		arg3 criteria.Criterion
 	}
 	listReturns struct {
 		result1 []model.Object
@@ -110,6 +131,24 @@ type FakeStorage struct {
 		result1 []model.Object
 		result2 error
 	}
+	ListPageStub        func(context.Context, string, query.Page, criteria.Criterion) ([]model.Object, string, error)
+	listPageMutex       sync.RWMutex
+	listPageArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 query.Page
+		arg4 criteria.Criterion
+	}
+	listPageReturns struct {
+		result1 []model.Object
+		result2 string
+		result3 error
+	}
+	listPageReturnsOnCall map[int]struct {
+		result1 []model.Object
+		result2 string
+		result3 error
+	}
 	OpenStub        func(func(string, string) (*sql.DB, error)) error
 	openMutex       sync.RWMutex
 	openArgsForCall []struct {
@@ -121,10 +160,22 @@ type FakeStorage struct {
 	openReturnsOnCall map[int]struct {
 		result1 error
 	}
-	SaveStub        func(model.Object) error
+	PingStub        func(context.Context) error
+	pingMutex       sync.RWMutex
+	pingArgsForCall []struct {
+		arg1 context.Context
+	}
+	pingReturns struct {
+		result1 error
+	}
+	pingReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SaveStub        func(context.Context, model.Object) error
 	saveMutex       sync.RWMutex
 	saveArgsForCall []struct {
-		arg1 model.Object
+		arg1 context.Context
+		arg2 model.Object
 	}
 	saveReturns struct {
 		result1 error
@@ -132,10 +183,11 @@ type FakeStorage struct {
 	saveReturnsOnCall map[int]struct {
 		result1 error
 	}
-	TransactionStub        func(func(s storage.Storage) error) error
+	TransactionStub        func(context.Context, func(s storage.Storage) error) error
 	transactionMutex       sync.RWMutex
 	transactionArgsForCall []struct {
-		arg1 func(s storage.Storage) error
+		arg1 context.Context
+		arg2 func(s storage.Storage) error
 	}
 	transactionReturns struct {
 		result1 error
@@ -151,9 +203,10 @@ func (fake *FakeStorage) Close() {
 	fake.closeMutex.Lock()
 	fake.closeArgsForCall = append(fake.closeArgsForCall, struct {
 	}{})
+	stub := fake.CloseStub
 	fake.recordInvocation("Close", []interface{}{})
 	fake.closeMutex.Unlock()
-	if fake.CloseStub != nil {
+	if stub != nil {
 		fake.CloseStub()
 	}
 }
@@ -170,23 +223,24 @@ func (fake *FakeStorage) CloseCalls(stub func()) {
 	fake.CloseStub = stub
 }
 
-func (fake *FakeStorage) Count(arg1 string, arg2 string, arg3 ...interface{}) (int, error) {
+func (fake *FakeStorage) Count(arg1 context.Context, arg2 string, arg3 criteria.Criterion) (int, error) {
 	fake.countMutex.Lock()
 	ret, specificReturn := fake.countReturnsOnCall[len(fake.countArgsForCall)]
 	fake.countArgsForCall = append(fake.countArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []interface{}
+		arg3 criteria.Criterion
 	}{arg1, arg2, arg3})
+	stub := fake.CountStub
+	fakeReturns := fake.countReturns
 	fake.recordInvocation("Count", []interface{}{arg1, arg2, arg3})
 	fake.countMutex.Unlock()
-	if fake.CountStub != nil {
-		return fake.CountStub(arg1, arg2, arg3...)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	fakeReturns := fake.countReturns
 	return fakeReturns.result1, fakeReturns.result2
 }
 
@@ -196,13 +250,13 @@ func (fake *FakeStorage) CountCallCount() int {
 	return len(fake.countArgsForCall)
 }
 
-func (fake *FakeStorage) CountCalls(stub func(string, string, ...interface{}) (int, error)) {
+func (fake *FakeStorage) CountCalls(stub func(context.Context, string, criteria.Criterion) (int, error)) {
 	fake.countMutex.Lock()
 	defer fake.countMutex.Unlock()
 	fake.CountStub = stub
 }
 
-func (fake *FakeStorage) CountArgsForCall(i int) (string, string, []interface{}) {
+func (fake *FakeStorage) CountArgsForCall(i int) (context.Context, string, criteria.Criterion) {
 	fake.countMutex.RLock()
 	defer fake.countMutex.RUnlock()
 	argsForCall := fake.countArgsForCall[i]
@@ -235,21 +289,23 @@ func (fake *FakeStorage) CountReturnsOnCall(i int, result1 int, result2 error) {
 	}{result1, result2}
 }
 
-func (fake *FakeStorage) Create(arg1 model.Object) (model.Object, error) {
+func (fake *FakeStorage) Create(arg1 context.Context, arg2 model.Object) (model.Object, error) {
 	fake.createMutex.Lock()
 	ret, specificReturn := fake.createReturnsOnCall[len(fake.createArgsForCall)]
 	fake.createArgsForCall = append(fake.createArgsForCall, struct {
-		arg1 model.Object
-	}{arg1})
-	fake.recordInvocation("Create", []interface{}{arg1})
+		arg1 context.Context
+		arg2 model.Object
+	}{arg1, arg2})
+	stub := fake.CreateStub
+	fakeReturns := fake.createReturns
+	fake.recordInvocation("Create", []interface{}{arg1, arg2})
 	fake.createMutex.Unlock()
-	if fake.CreateStub != nil {
-		return fake.CreateStub(arg1)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	fakeReturns := fake.createReturns
 	return fakeReturns.result1, fakeReturns.result2
 }
 
@@ -259,17 +315,17 @@ func (fake *FakeStorage) CreateCallCount() int {
 	return len(fake.createArgsForCall)
 }
 
-func (fake *FakeStorage) CreateCalls(stub func(model.Object) (model.Object, error)) {
+func (fake *FakeStorage) CreateCalls(stub func(context.Context, model.Object) (model.Object, error)) {
 	fake.createMutex.Lock()
 	defer fake.createMutex.Unlock()
 	fake.CreateStub = stub
 }
 
-func (fake *FakeStorage) CreateArgsForCall(i int) model.Object {
+func (fake *FakeStorage) CreateArgsForCall(i int) (context.Context, model.Object) {
 	fake.createMutex.RLock()
 	defer fake.createMutex.RUnlock()
 	argsForCall := fake.createArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeStorage) CreateReturns(result1 model.Object, result2 error) {
@@ -298,23 +354,24 @@ func (fake *FakeStorage) CreateReturnsOnCall(i int, result1 model.Object, result
 	}{result1, result2}
 }
 
-func (fake *FakeStorage) Delete(arg1 string, arg2 string, arg3 ...interface{}) error {
+func (fake *FakeStorage) Delete(arg1 context.Context, arg2 string, arg3 criteria.Criterion) error {
 	fake.deleteMutex.Lock()
 	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
 	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []interface{}
+		arg3 criteria.Criterion
 	}{arg1, arg2, arg3})
+	stub := fake.DeleteStub
+	fakeReturns := fake.deleteReturns
 	fake.recordInvocation("Delete", []interface{}{arg1, arg2, arg3})
 	fake.deleteMutex.Unlock()
-	if fake.DeleteStub != nil {
-		return fake.DeleteStub(arg1, arg2, arg3...)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.deleteReturns
 	return fakeReturns.result1
 }
 
@@ -324,13 +381,13 @@ func (fake *FakeStorage) DeleteCallCount() int {
 	return len(fake.deleteArgsForCall)
 }
 
-func (fake *FakeStorage) DeleteCalls(stub func(string, string, ...interface{}) error) {
+func (fake *FakeStorage) DeleteCalls(stub func(context.Context, string, criteria.Criterion) error) {
 	fake.deleteMutex.Lock()
 	defer fake.deleteMutex.Unlock()
 	fake.DeleteStub = stub
 }
 
-func (fake *FakeStorage) DeleteArgsForCall(i int) (string, string, []interface{}) {
+func (fake *FakeStorage) DeleteArgsForCall(i int) (context.Context, string, criteria.Criterion) {
 	fake.deleteMutex.RLock()
 	defer fake.deleteMutex.RUnlock()
 	argsForCall := fake.deleteArgsForCall[i]
@@ -360,21 +417,23 @@ func (fake *FakeStorage) DeleteReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStorage) DeleteAll(arg1 string) error {
+func (fake *FakeStorage) DeleteAll(arg1 context.Context, arg2 string) error {
 	fake.deleteAllMutex.Lock()
 	ret, specificReturn := fake.deleteAllReturnsOnCall[len(fake.deleteAllArgsForCall)]
 	fake.deleteAllArgsForCall = append(fake.deleteAllArgsForCall, struct {
-		arg1 string
-	}{arg1})
-	fake.recordInvocation("DeleteAll", []interface{}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.DeleteAllStub
+	fakeReturns := fake.deleteAllReturns
+	fake.recordInvocation("DeleteAll", []interface{}{arg1, arg2})
 	fake.deleteAllMutex.Unlock()
-	if fake.DeleteAllStub != nil {
-		return fake.DeleteAllStub(arg1)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.deleteAllReturns
 	return fakeReturns.result1
 }
 
@@ -384,17 +443,17 @@ func (fake *FakeStorage) DeleteAllCallCount() int {
 	return len(fake.deleteAllArgsForCall)
 }
 
-func (fake *FakeStorage) DeleteAllCalls(stub func(string) error) {
+func (fake *FakeStorage) DeleteAllCalls(stub func(context.Context, string) error) {
 	fake.deleteAllMutex.Lock()
 	defer fake.deleteAllMutex.Unlock()
 	fake.DeleteAllStub = stub
 }
 
-func (fake *FakeStorage) DeleteAllArgsForCall(i int) string {
+func (fake *FakeStorage) DeleteAllArgsForCall(i int) (context.Context, string) {
 	fake.deleteAllMutex.RLock()
 	defer fake.deleteAllMutex.RUnlock()
 	argsForCall := fake.deleteAllArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeStorage) DeleteAllReturns(result1 error) {
@@ -420,22 +479,24 @@ func (fake *FakeStorage) DeleteAllReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStorage) Get(arg1 string, arg2 string) (model.Object, error) {
+func (fake *FakeStorage) Get(arg1 context.Context, arg2 string, arg3 string) (model.Object, error) {
 	fake.getMutex.Lock()
 	ret, specificReturn := fake.getReturnsOnCall[len(fake.getArgsForCall)]
 	fake.getArgsForCall = append(fake.getArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-	}{arg1, arg2})
-	fake.recordInvocation("Get", []interface{}{arg1, arg2})
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.GetStub
+	fakeReturns := fake.getReturns
+	fake.recordInvocation("Get", []interface{}{arg1, arg2, arg3})
 	fake.getMutex.Unlock()
-	if fake.GetStub != nil {
-		return fake.GetStub(arg1, arg2)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	fakeReturns := fake.getReturns
 	return fakeReturns.result1, fakeReturns.result2
 }
 
@@ -445,17 +506,17 @@ func (fake *FakeStorage) GetCallCount() int {
 	return len(fake.getArgsForCall)
 }
 
-func (fake *FakeStorage) GetCalls(stub func(string, string) (model.Object, error)) {
+func (fake *FakeStorage) GetCalls(stub func(context.Context, string, string) (model.Object, error)) {
 	fake.getMutex.Lock()
 	defer fake.getMutex.Unlock()
 	fake.GetStub = stub
 }
 
-func (fake *FakeStorage) GetArgsForCall(i int) (string, string) {
+func (fake *FakeStorage) GetArgsForCall(i int) (context.Context, string, string) {
 	fake.getMutex.RLock()
 	defer fake.getMutex.RUnlock()
 	argsForCall := fake.getArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeStorage) GetReturns(result1 model.Object, result2 error) {
@@ -484,23 +545,24 @@ func (fake *FakeStorage) GetReturnsOnCall(i int, result1 model.Object, result2 e
 	}{result1, result2}
 }
 
-func (fake *FakeStorage) GetBy(arg1 string, arg2 string, arg3 ...interface{}) (model.Object, error) {
+func (fake *FakeStorage) GetBy(arg1 context.Context, arg2 string, arg3 criteria.Criterion) (model.Object, error) {
 	fake.getByMutex.Lock()
 	ret, specificReturn := fake.getByReturnsOnCall[len(fake.getByArgsForCall)]
 	fake.getByArgsForCall = append(fake.getByArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-		arg3 []interface{}
+		arg3 criteria.Criterion
 	}{arg1, arg2, arg3})
+	stub := fake.GetByStub
+	fakeReturns := fake.getByReturns
 	fake.recordInvocation("GetBy", []interface{}{arg1, arg2, arg3})
 	fake.getByMutex.Unlock()
-	if fake.GetByStub != nil {
-		return fake.GetByStub(arg1, arg2, arg3...)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	fakeReturns := fake.getByReturns
 	return fakeReturns.result1, fakeReturns.result2
 }
 
@@ -510,13 +572,13 @@ func (fake *FakeStorage) GetByCallCount() int {
 	return len(fake.getByArgsForCall)
 }
 
-func (fake *FakeStorage) GetByCalls(stub func(string, string, ...interface{}) (model.Object, error)) {
+func (fake *FakeStorage) GetByCalls(stub func(context.Context, string, criteria.Criterion) (model.Object, error)) {
 	fake.getByMutex.Lock()
 	defer fake.getByMutex.Unlock()
 	fake.GetByStub = stub
 }
 
-func (fake *FakeStorage) GetByArgsForCall(i int) (string, string, []interface{}) {
+func (fake *FakeStorage) GetByArgsForCall(i int) (context.Context, string, criteria.Criterion) {
 	fake.getByMutex.RLock()
 	defer fake.getByMutex.RUnlock()
 	argsForCall := fake.getByArgsForCall[i]
@@ -549,22 +611,90 @@ func (fake *FakeStorage) GetByReturnsOnCall(i int, result1 model.Object, result2
 	}{result1, result2}
 }
 
-func (fake *FakeStorage) List(arg1 string, arg2 ...query.Query) ([]model.Object, error) {
+func (fake *FakeStorage) GetForUpdate(arg1 context.Context, arg2 string, arg3 string) (model.Object, error) {
+	fake.getForUpdateMutex.Lock()
+	ret, specificReturn := fake.getForUpdateReturnsOnCall[len(fake.getForUpdateArgsForCall)]
+	fake.getForUpdateArgsForCall = append(fake.getForUpdateArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.GetForUpdateStub
+	fakeReturns := fake.getForUpdateReturns
+	fake.recordInvocation("GetForUpdate", []interface{}{arg1, arg2, arg3})
+	fake.getForUpdateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeStorage) GetForUpdateCallCount() int {
+	fake.getForUpdateMutex.RLock()
+	defer fake.getForUpdateMutex.RUnlock()
+	return len(fake.getForUpdateArgsForCall)
+}
+
+func (fake *FakeStorage) GetForUpdateCalls(stub func(context.Context, string, string) (model.Object, error)) {
+	fake.getForUpdateMutex.Lock()
+	defer fake.getForUpdateMutex.Unlock()
+	fake.GetForUpdateStub = stub
+}
+
+func (fake *FakeStorage) GetForUpdateArgsForCall(i int) (context.Context, string, string) {
+	fake.getForUpdateMutex.RLock()
+	defer fake.getForUpdateMutex.RUnlock()
+	argsForCall := fake.getForUpdateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeStorage) GetForUpdateReturns(result1 model.Object, result2 error) {
+	fake.getForUpdateMutex.Lock()
+	defer fake.getForUpdateMutex.Unlock()
+	fake.GetForUpdateStub = nil
+	fake.getForUpdateReturns = struct {
+		result1 model.Object
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStorage) GetForUpdateReturnsOnCall(i int, result1 model.Object, result2 error) {
+	fake.getForUpdateMutex.Lock()
+	defer fake.getForUpdateMutex.Unlock()
+	fake.GetForUpdateStub = nil
+	if fake.getForUpdateReturnsOnCall == nil {
+		fake.getForUpdateReturnsOnCall = make(map[int]struct {
+			result1 model.Object
+			result2 error
+		})
+	}
+	fake.getForUpdateReturnsOnCall[i] = struct {
+		result1 model.Object
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStorage) List(arg1 context.Context, arg2 string, arg3 criteria.Criterion) ([]model.Object, error) {
 	fake.listMutex.Lock()
 	ret, specificReturn := fake.listReturnsOnCall[len(fake.listArgsForCall)]
 	fake.listArgsForCall = append(fake.listArgsForCall, struct {
-		arg1 string
-		arg2 []query.Query
-	}{arg1, arg2})
-	fake.recordInvocation("List", []interface{}{arg1, arg2})
+		arg1 context.Context
+		arg2 string
+		arg3 criteria.Criterion
+	}{arg1, arg2, arg3})
+	stub := fake.ListStub
+	fakeReturns := fake.listReturns
+	fake.recordInvocation("List", []interface{}{arg1, arg2, arg3})
 	fake.listMutex.Unlock()
-	if fake.ListStub != nil {
-		return fake.ListStub(arg1, arg2...)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	fakeReturns := fake.listReturns
 	return fakeReturns.result1, fakeReturns.result2
 }
 
@@ -574,17 +704,17 @@ func (fake *FakeStorage) ListCallCount() int {
 	return len(fake.listArgsForCall)
 }
 
-func (fake *FakeStorage) ListCalls(stub func(string, ...query.Query) ([]model.Object, error)) {
+func (fake *FakeStorage) ListCalls(stub func(context.Context, string, criteria.Criterion) ([]model.Object, error)) {
 	fake.listMutex.Lock()
 	defer fake.listMutex.Unlock()
 	fake.ListStub = stub
 }
 
-func (fake *FakeStorage) ListArgsForCall(i int) (string, []query.Query) {
+func (fake *FakeStorage) ListArgsForCall(i int) (context.Context, string, criteria.Criterion) {
 	fake.listMutex.RLock()
 	defer fake.listMutex.RUnlock()
 	argsForCall := fake.listArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *FakeStorage) ListReturns(result1 []model.Object, result2 error) {
@@ -613,21 +743,92 @@ func (fake *FakeStorage) ListReturnsOnCall(i int, result1 []model.Object, result
 	}{result1, result2}
 }
 
+func (fake *FakeStorage) ListPage(arg1 context.Context, arg2 string, arg3 query.Page, arg4 criteria.Criterion) ([]model.Object, string, error) {
+	fake.listPageMutex.Lock()
+	ret, specificReturn := fake.listPageReturnsOnCall[len(fake.listPageArgsForCall)]
+	fake.listPageArgsForCall = append(fake.listPageArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 query.Page
+		arg4 criteria.Criterion
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.ListPageStub
+	fakeReturns := fake.listPageReturns
+	fake.recordInvocation("ListPage", []interface{}{arg1, arg2, arg3, arg4})
+	fake.listPageMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeStorage) ListPageCallCount() int {
+	fake.listPageMutex.RLock()
+	defer fake.listPageMutex.RUnlock()
+	return len(fake.listPageArgsForCall)
+}
+
+func (fake *FakeStorage) ListPageCalls(stub func(context.Context, string, query.Page, criteria.Criterion) ([]model.Object, string, error)) {
+	fake.listPageMutex.Lock()
+	defer fake.listPageMutex.Unlock()
+	fake.ListPageStub = stub
+}
+
+func (fake *FakeStorage) ListPageArgsForCall(i int) (context.Context, string, query.Page, criteria.Criterion) {
+	fake.listPageMutex.RLock()
+	defer fake.listPageMutex.RUnlock()
+	argsForCall := fake.listPageArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeStorage) ListPageReturns(result1 []model.Object, result2 string, result3 error) {
+	fake.listPageMutex.Lock()
+	defer fake.listPageMutex.Unlock()
+	fake.ListPageStub = nil
+	fake.listPageReturns = struct {
+		result1 []model.Object
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeStorage) ListPageReturnsOnCall(i int, result1 []model.Object, result2 string, result3 error) {
+	fake.listPageMutex.Lock()
+	defer fake.listPageMutex.Unlock()
+	fake.ListPageStub = nil
+	if fake.listPageReturnsOnCall == nil {
+		fake.listPageReturnsOnCall = make(map[int]struct {
+			result1 []model.Object
+			result2 string
+			result3 error
+		})
+	}
+	fake.listPageReturnsOnCall[i] = struct {
+		result1 []model.Object
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
 func (fake *FakeStorage) Open(arg1 func(string, string) (*sql.DB, error)) error {
 	fake.openMutex.Lock()
 	ret, specificReturn := fake.openReturnsOnCall[len(fake.openArgsForCall)]
 	fake.openArgsForCall = append(fake.openArgsForCall, struct {
 		arg1 func(string, string) (*sql.DB, error)
 	}{arg1})
+	stub := fake.OpenStub
+	fakeReturns := fake.openReturns
 	fake.recordInvocation("Open", []interface{}{arg1})
 	fake.openMutex.Unlock()
-	if fake.OpenStub != nil {
-		return fake.OpenStub(arg1)
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.openReturns
 	return fakeReturns.result1
 }
 
@@ -673,21 +874,84 @@ func (fake *FakeStorage) OpenReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStorage) Save(arg1 model.Object) error {
+func (fake *FakeStorage) Ping(arg1 context.Context) error {
+	fake.pingMutex.Lock()
+	ret, specificReturn := fake.pingReturnsOnCall[len(fake.pingArgsForCall)]
+	fake.pingArgsForCall = append(fake.pingArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.PingStub
+	fakeReturns := fake.pingReturns
+	fake.recordInvocation("Ping", []interface{}{arg1})
+	fake.pingMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeStorage) PingCallCount() int {
+	fake.pingMutex.RLock()
+	defer fake.pingMutex.RUnlock()
+	return len(fake.pingArgsForCall)
+}
+
+func (fake *FakeStorage) PingCalls(stub func(context.Context) error) {
+	fake.pingMutex.Lock()
+	defer fake.pingMutex.Unlock()
+	fake.PingStub = stub
+}
+
+func (fake *FakeStorage) PingArgsForCall(i int) context.Context {
+	fake.pingMutex.RLock()
+	defer fake.pingMutex.RUnlock()
+	argsForCall := fake.pingArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeStorage) PingReturns(result1 error) {
+	fake.pingMutex.Lock()
+	defer fake.pingMutex.Unlock()
+	fake.PingStub = nil
+	fake.pingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStorage) PingReturnsOnCall(i int, result1 error) {
+	fake.pingMutex.Lock()
+	defer fake.pingMutex.Unlock()
+	fake.PingStub = nil
+	if fake.pingReturnsOnCall == nil {
+		fake.pingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.pingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStorage) Save(arg1 context.Context, arg2 model.Object) error {
 	fake.saveMutex.Lock()
 	ret, specificReturn := fake.saveReturnsOnCall[len(fake.saveArgsForCall)]
 	fake.saveArgsForCall = append(fake.saveArgsForCall, struct {
-		arg1 model.Object
-	}{arg1})
-	fake.recordInvocation("Save", []interface{}{arg1})
+		arg1 context.Context
+		arg2 model.Object
+	}{arg1, arg2})
+	stub := fake.SaveStub
+	fakeReturns := fake.saveReturns
+	fake.recordInvocation("Save", []interface{}{arg1, arg2})
 	fake.saveMutex.Unlock()
-	if fake.SaveStub != nil {
-		return fake.SaveStub(arg1)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.saveReturns
 	return fakeReturns.result1
 }
 
@@ -697,17 +961,17 @@ func (fake *FakeStorage) SaveCallCount() int {
 	return len(fake.saveArgsForCall)
 }
 
-func (fake *FakeStorage) SaveCalls(stub func(model.Object) error) {
+func (fake *FakeStorage) SaveCalls(stub func(context.Context, model.Object) error) {
 	fake.saveMutex.Lock()
 	defer fake.saveMutex.Unlock()
 	fake.SaveStub = stub
 }
 
-func (fake *FakeStorage) SaveArgsForCall(i int) model.Object {
+func (fake *FakeStorage) SaveArgsForCall(i int) (context.Context, model.Object) {
 	fake.saveMutex.RLock()
 	defer fake.saveMutex.RUnlock()
 	argsForCall := fake.saveArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeStorage) SaveReturns(result1 error) {
@@ -733,21 +997,23 @@ func (fake *FakeStorage) SaveReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeStorage) Transaction(arg1 func(s storage.Storage) error) error {
+func (fake *FakeStorage) Transaction(arg1 context.Context, arg2 func(s storage.Storage) error) error {
 	fake.transactionMutex.Lock()
 	ret, specificReturn := fake.transactionReturnsOnCall[len(fake.transactionArgsForCall)]
 	fake.transactionArgsForCall = append(fake.transactionArgsForCall, struct {
-		arg1 func(s storage.Storage) error
-	}{arg1})
-	fake.recordInvocation("Transaction", []interface{}{arg1})
+		arg1 context.Context
+		arg2 func(s storage.Storage) error
+	}{arg1, arg2})
+	stub := fake.TransactionStub
+	fakeReturns := fake.transactionReturns
+	fake.recordInvocation("Transaction", []interface{}{arg1, arg2})
 	fake.transactionMutex.Unlock()
-	if fake.TransactionStub != nil {
-		return fake.TransactionStub(arg1)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.transactionReturns
 	return fakeReturns.result1
 }
 
@@ -757,17 +1023,17 @@ func (fake *FakeStorage) TransactionCallCount() int {
 	return len(fake.transactionArgsForCall)
 }
 
-func (fake *FakeStorage) TransactionCalls(stub func(func(s storage.Storage) error) error) {
+func (fake *FakeStorage) TransactionCalls(stub func(context.Context, func(s storage.Storage) error) error) {
 	fake.transactionMutex.Lock()
 	defer fake.transactionMutex.Unlock()
 	fake.TransactionStub = stub
 }
 
-func (fake *FakeStorage) TransactionArgsForCall(i int) func(s storage.Storage) error {
+func (fake *FakeStorage) TransactionArgsForCall(i int) (context.Context, func(s storage.Storage) error) {
 	fake.transactionMutex.RLock()
 	defer fake.transactionMutex.RUnlock()
 	argsForCall := fake.transactionArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeStorage) TransactionReturns(result1 error) {
@@ -810,10 +1076,16 @@ func (fake *FakeStorage) Invocations() map[string][][]interface{} {
 	defer fake.getMutex.RUnlock()
 	fake.getByMutex.RLock()
 	defer fake.getByMutex.RUnlock()
+	fake.getForUpdateMutex.RLock()
+	defer fake.getForUpdateMutex.RUnlock()
 	fake.listMutex.RLock()
 	defer fake.listMutex.RUnlock()
+	fake.listPageMutex.RLock()
+	defer fake.listPageMutex.RUnlock()
 	fake.openMutex.RLock()
 	defer fake.openMutex.RUnlock()
+	fake.pingMutex.RLock()
+	defer fake.pingMutex.RUnlock()
 	fake.saveMutex.RLock()
 	defer fake.saveMutex.RUnlock()
 	fake.transactionMutex.RLock()
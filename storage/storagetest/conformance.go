@@ -0,0 +1,346 @@
+// Package storagetest contains the tests which every implementation of storage.Storage should pass
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/storage"
+)
+
+// DescribeConformance describes the behaviour expected from a storage.
+// The storage returned by newStorage should be open. Its objects are deleted before each test.
+func DescribeConformance(name string, newStorage func() storage.Storage) bool {
+	return Describe(fmt.Sprintf("%s storage conformance", name), func() {
+		var repository storage.Storage
+		ctx := context.Background()
+		var merchant *model.Merchant
+
+		newTransaction := func(id string, amount int) *model.Transaction {
+			return &model.Transaction{
+				UUID:          id,
+				Type:          model.Authorize,
+				Status:        model.Approved,
+				Amount:        amount,
+				Currency:      model.EUR,
+				CustomerEmail: "user@customer.com",
+				MerchantID:    merchant.UUID,
+			}
+		}
+
+		createTransactions := func(transactions ...*model.Transaction) {
+			for _, t := range transactions {
+				_, err := repository.Create(ctx, t)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+		}
+
+		uuids := func(objects []model.Object) []string {
+			result := make([]string, 0, len(objects))
+			for _, object := range objects {
+				result = append(result, object.(*model.Transaction).UUID)
+			}
+			return result
+		}
+
+		BeforeEach(func() {
+			repository = newStorage()
+			for _, typee := range []string{
+				model.LedgerEntryType,
+				model.TransactionStatusChangeType,
+				model.ArchivedTransactionType,
+				model.APIKeyType,
+				model.WebhookEventType,
+				model.WebhookEndpointType,
+				model.IdempotencyKeyType,
+				model.TransactionObjectType,
+				model.MerchantType,
+			} {
+				Expect(repository.DeleteAll(ctx, typee)).To(Succeed())
+			}
+
+			object, err := repository.Create(ctx, &model.Merchant{
+				UUID:   "00000000-0000-0000-0000-000000000001",
+				Name:   "merchant",
+				Email:  "merchant@mail.com",
+				Status: true,
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			merchant = object.(*model.Merchant)
+		})
+
+		Describe("Create and Get", func() {
+			It("should store the object", func() {
+				object, err := repository.Create(ctx, newTransaction("00000000-0000-0000-0000-00000000000a", 10))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(object.(*model.Transaction).CreatedAt).ToNot(BeZero())
+
+				object, err = repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).ShouldNot(HaveOccurred())
+				transaction := object.(*model.Transaction)
+				Expect(transaction.Amount).To(Equal(10))
+				Expect(transaction.Currency).To(Equal(model.EUR))
+				Expect(transaction.Status).To(Equal(model.Approved))
+				Expect(transaction.MerchantID).To(Equal(merchant.UUID))
+				Expect(transaction.DependsOnUUID).To(BeEmpty())
+			})
+
+			It("should not find missing objects", func() {
+				_, err := repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-0000000000ff")
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+
+			It("should not create objects with the same unique fields", func() {
+				_, err := repository.Create(ctx, &model.Merchant{
+					UUID:  "00000000-0000-0000-0000-000000000002",
+					Name:  "other merchant",
+					Email: merchant.Email,
+				})
+				Expect(err).Should(HaveOccurred())
+			})
+
+			It("should not accept unknown types", func() {
+				_, err := repository.Get(ctx, "unknown", "id")
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+
+		Describe("Save", func() {
+			It("should update the object", func() {
+				createTransactions(newTransaction("00000000-0000-0000-0000-00000000000a", 10))
+				object, err := repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).ShouldNot(HaveOccurred())
+				transaction := object.(*model.Transaction)
+				transaction.Status = model.Captured
+				transaction.CapturedAmount = 10
+				Expect(repository.Save(ctx, transaction)).To(Succeed())
+
+				object, err = repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(object.(*model.Transaction).Status).To(Equal(model.Captured))
+				Expect(object.(*model.Transaction).CapturedAmount).To(Equal(10))
+			})
+		})
+
+		Describe("querying", func() {
+			BeforeEach(func() {
+				charge := newTransaction("00000000-0000-0000-0000-00000000000c", 5)
+				charge.Type = model.Charge
+				charge.DependsOnUUID = "00000000-0000-0000-0000-00000000000a"
+				createTransactions(
+					newTransaction("00000000-0000-0000-0000-00000000000a", 10),
+					newTransaction("00000000-0000-0000-0000-00000000000b", 20),
+					charge,
+				)
+			})
+
+			It("should list the objects matching the criterion", func() {
+				result, err := repository.List(ctx, model.TransactionObjectType, criteria.And(
+					criteria.Eq("merchant_id", merchant.UUID),
+					criteria.Or(
+						criteria.Gt("amount", 15),
+						criteria.NotNull("depends_on_uuid"),
+					),
+				))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(uuids(result)).To(ConsistOf("00000000-0000-0000-0000-00000000000b", "00000000-0000-0000-0000-00000000000c"))
+
+				result, err = repository.List(ctx, model.TransactionObjectType, criteria.OneOf("type", model.Authorize))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(result).To(HaveLen(2))
+
+				result, err = repository.List(ctx, model.TransactionObjectType, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(result).To(HaveLen(3))
+			})
+
+			It("should treat zero times as null", func() {
+				authorization := newTransaction("00000000-0000-0000-0000-00000000000d", 30)
+				authorization.ExpiresAt = time.Now().Add(time.Hour)
+				createTransactions(authorization)
+
+				result, err := repository.List(ctx, model.TransactionObjectType, criteria.NotNull("expires_at"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(uuids(result)).To(ConsistOf("00000000-0000-0000-0000-00000000000d"))
+
+				count, err := repository.Count(ctx, model.TransactionObjectType, criteria.Or(
+					criteria.Null("expires_at"),
+					criteria.Gt("expires_at", time.Now()),
+				))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(count).To(Equal(4))
+			})
+
+			It("should not list by unknown fields", func() {
+				_, err := repository.List(ctx, model.TransactionObjectType, criteria.Eq("transaction_id", "00000000-0000-0000-0000-00000000000a"))
+				Expect(err).Should(HaveOccurred())
+			})
+
+			It("should get the object matching the criterion", func() {
+				object, err := repository.GetBy(ctx, model.TransactionObjectType, criteria.Eq("depends_on_uuid", "00000000-0000-0000-0000-00000000000a"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(object.(*model.Transaction).UUID).To(Equal("00000000-0000-0000-0000-00000000000c"))
+
+				_, err = repository.GetBy(ctx, model.TransactionObjectType, criteria.Eq("amount", 1000))
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+
+			It("should count the objects matching the criterion", func() {
+				count, err := repository.Count(ctx, model.TransactionObjectType, criteria.InRange("amount", 5, 10))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(count).To(Equal(2))
+			})
+
+			It("should delete the objects matching the criterion", func() {
+				Expect(repository.Delete(ctx, model.TransactionObjectType, criteria.Eq("type", model.Charge))).To(Succeed())
+				count, err := repository.Count(ctx, model.TransactionObjectType, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(count).To(Equal(2))
+			})
+
+			It("should list pages in order", func() {
+				page := query.Page{Limit: 2, OrderBy: "amount", Descending: true}
+				result, next, err := repository.ListPage(ctx, model.TransactionObjectType, page, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(uuids(result)).To(Equal([]string{"00000000-0000-0000-0000-00000000000b", "00000000-0000-0000-0000-00000000000a"}))
+				Expect(next).ToNot(BeEmpty())
+
+				page.Cursor = next
+				result, next, err = repository.ListPage(ctx, model.TransactionObjectType, page, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(uuids(result)).To(Equal([]string{"00000000-0000-0000-0000-00000000000c"}))
+				Expect(next).To(BeEmpty())
+			})
+
+			It("should list pages of the objects matching the criterion", func() {
+				result, next, err := repository.ListPage(ctx, model.TransactionObjectType, query.Page{Limit: 5, OrderBy: "amount"}, criteria.Eq("type", model.Authorize))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(uuids(result)).To(Equal([]string{"00000000-0000-0000-0000-00000000000a", "00000000-0000-0000-0000-00000000000b"}))
+				Expect(next).To(BeEmpty())
+			})
+
+			It("should not order by unknown fields or accept invalid cursors", func() {
+				_, _, err := repository.ListPage(ctx, model.TransactionObjectType, query.Page{Limit: 1, OrderBy: "unknown"}, nil)
+				Expect(err).Should(HaveOccurred())
+
+				_, _, err = repository.ListPage(ctx, model.TransactionObjectType, query.Page{Limit: 1, Cursor: "invalid"}, nil)
+				Expect(err).Should(HaveOccurred())
+			})
+
+			It("should not continue a listing in another order", func() {
+				page := query.Page{Limit: 1, OrderBy: "amount"}
+				_, next, err := repository.ListPage(ctx, model.TransactionObjectType, page, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(next).ToNot(BeEmpty())
+
+				_, _, err = repository.ListPage(ctx, model.TransactionObjectType, query.Page{Limit: 1, OrderBy: "created_at", Cursor: next}, nil)
+				Expect(err).Should(HaveOccurred())
+				_, _, err = repository.ListPage(ctx, model.TransactionObjectType, query.Page{Limit: 1, OrderBy: "amount", Descending: true, Cursor: next}, nil)
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+
+		Describe("Ping", func() {
+			It("should reach the storage and the transactions", func() {
+				Expect(repository.Ping(ctx)).To(Succeed())
+				Expect(repository.Transaction(ctx, func(tx storage.Storage) error {
+					return tx.Ping(ctx)
+				})).To(Succeed())
+			})
+		})
+
+		Describe("Transaction", func() {
+			It("should keep the changes when it succeeds", func() {
+				err := repository.Transaction(ctx, func(tx storage.Storage) error {
+					_, err := tx.Create(ctx, newTransaction("00000000-0000-0000-0000-00000000000a", 10))
+					return err
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+
+				_, err = repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("should roll back the changes when it fails", func() {
+				failure := errors.New("failure")
+				err := repository.Transaction(ctx, func(tx storage.Storage) error {
+					if _, err := tx.Create(ctx, newTransaction("00000000-0000-0000-0000-00000000000a", 10)); err != nil {
+						return err
+					}
+					m, err := tx.GetForUpdate(ctx, model.MerchantType, merchant.UUID)
+					if err != nil {
+						return err
+					}
+					m.(*model.Merchant).Status = false
+					if err := tx.Save(ctx, m); err != nil {
+						return err
+					}
+					return failure
+				})
+				Expect(err).To(Equal(failure))
+
+				_, err = repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).To(Equal(storage.ErrNotFound))
+				object, err := repository.Get(ctx, model.MerchantType, merchant.UUID)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(object.(*model.Merchant).Status).To(BeTrue())
+			})
+
+			It("should not expose the changes before it ends", func() {
+				err := repository.Transaction(ctx, func(tx storage.Storage) error {
+					if _, err := tx.Create(ctx, newTransaction("00000000-0000-0000-0000-00000000000a", 10)); err != nil {
+						return err
+					}
+					if _, err := tx.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a"); err != nil {
+						return err
+					}
+
+					// Reads outside of the transaction do not wait for it
+					_, err := repository.Get(ctx, model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+					Expect(err).To(Equal(storage.ErrNotFound))
+					return nil
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+
+			It("should roll back the changes when the context is done", func() {
+				ctx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				err := repository.Transaction(ctx, func(tx storage.Storage) error {
+					_, err := tx.Create(ctx, newTransaction("00000000-0000-0000-0000-00000000000a", 10))
+					cancel()
+					return err
+				})
+				Expect(err).Should(HaveOccurred())
+
+				_, err = repository.Get(context.Background(), model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+		})
+
+		Describe("context", func() {
+			It("should not run operations when the context is done", func() {
+				ctx, cancel := context.WithCancel(ctx)
+				cancel()
+
+				_, err := repository.Create(ctx, newTransaction("00000000-0000-0000-0000-00000000000a", 10))
+				Expect(err).Should(HaveOccurred())
+				_, err = repository.List(ctx, model.TransactionObjectType, nil)
+				Expect(err).Should(HaveOccurred())
+				Expect(repository.Transaction(ctx, func(tx storage.Storage) error {
+					return nil
+				})).ShouldNot(Succeed())
+
+				_, err = repository.Get(context.Background(), model.TransactionObjectType, "00000000-0000-0000-0000-00000000000a")
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+		})
+	})
+}
@@ -65,14 +65,20 @@ function init() {
     }, false);
 
     document.body.addEventListener("click", function(e) {
+        if (e.target && e.target.id == "next-page") {
+            let cursor = e.target.getAttribute("data-cursor");
+            loadView("/transactions?cursor=" + encodeURIComponent(cursor), body);
+        }
         if (e.target && e.target.id == "create") {
             let amount = document.getElementById("amount").value;
+            let currency = document.getElementById("currency").value;
             let type = document.getElementById("type").value;
             let customerEmail = document.getElementById("customer-email").value;
             let dependsOnUUID = document.getElementById("depends-on").value;
             let merchantUUID = document.getElementById("merchant").value;
+            let finalCapture = document.getElementById("final-capture").checked;
 
-            createTransaction(amount, type, merchantUUID, customerEmail, dependsOnUUID, (err, data) => {
+            createTransaction(amount, currency, type, merchantUUID, customerEmail, dependsOnUUID, finalCapture, (err, data) => {
                 if (err) {
                     let errBox = document.getElementById("transaction-error-box");
                     errBox.innerHTML = err.responseText;
@@ -1,13 +1,15 @@
-function createTransaction(amount, type, merchantUUID, customerEmail, dependsOnUUID, callback) {
+function createTransaction(amount, currency, type, merchantUUID, customerEmail, dependsOnUUID, finalCapture, callback) {
     $.ajax({
         url: "/payment",
         method: "POST",
         data: JSON.stringify({
             "amount": parseInt(amount),
+            "currency": currency,
             "type": type,
             "merchant_id" :merchantUUID,
             "customer_email": customerEmail,
             "depends_on_uuid": dependsOnUUID,
+            "final_capture": finalCapture,
         }),
         headers: {
             "X-CSRF-Token": loginData.csrfToken,
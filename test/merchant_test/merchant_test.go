@@ -0,0 +1,96 @@
+package merchant_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/test"
+)
+
+func TestMerchants(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Merchant integration tests")
+}
+
+var _ = Describe("Merchants", func() {
+	var testApp *test.TestApp
+
+	BeforeSuite(func() {
+		testApp = test.NewTestApp()
+	})
+
+	AfterEach(func() {
+		testApp.Repository.DeleteAll(context.Background(), model.LedgerEntryType)
+		testApp.Repository.DeleteAll(context.Background(), model.TransactionStatusChangeType)
+		testApp.Repository.DeleteAll(context.Background(), model.ArchivedTransactionType)
+		testApp.Repository.DeleteAll(context.Background(), model.TransactionObjectType)
+		testApp.Repository.DeleteAll(context.Background(), model.MerchantType)
+	})
+
+	When("merchant is created", func() {
+		var merchantID string
+		BeforeEach(func() {
+			merchantID = testApp.ExpectWithAuth.POST("/merchant").WithJSON(&model.Merchant{
+				Name:        "shop",
+				Description: "Test shop",
+				Email:       "shop@mail.com",
+				Status:      true,
+			}).Expect().Status(http.StatusCreated).JSON().Object().Value("uuid").String().Raw()
+		})
+
+		It("should be listed", func() {
+			testApp.ExpectWithAuth.GET("/merchant").Expect().Status(http.StatusOK).
+				JSON().Array().Path("$..uuid").Array().Contains(merchantID)
+		})
+
+		It("should be found by uuid", func() {
+			testApp.ExpectWithAuth.GET("/merchant/" + merchantID).Expect().Status(http.StatusOK).
+				JSON().Object().Value("name").Equal("shop")
+		})
+
+		It("should be updated", func() {
+			testApp.ExpectWithAuth.PUT("/merchant/" + merchantID).WithJSON(&model.Merchant{
+				Name:        "renamed",
+				Description: "Renamed shop",
+				Email:       "shop@mail.com",
+				Status:      true,
+			}).Expect().Status(http.StatusOK).JSON().Object().Value("name").Equal("renamed")
+		})
+
+		It("should be deactivated", func() {
+			testApp.ExpectWithAuth.POST("/merchant/" + merchantID + "/deactivate").Expect().Status(http.StatusOK).
+				JSON().Object().Value("status").Equal(false)
+		})
+
+		It("should be deleted", func() {
+			testApp.ExpectWithAuth.DELETE("/merchant/" + merchantID).Expect().Status(http.StatusNoContent)
+			testApp.ExpectWithAuth.GET("/merchant/" + merchantID).Expect().Status(http.StatusNotFound)
+		})
+
+		When("merchant has transactions", func() {
+			BeforeEach(func() {
+				testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+					Amount:        10,
+					Currency:      model.EUR,
+					CustomerEmail: "customer@mail.com",
+					CustomerPhone: "0000000",
+					MerchantID:    merchantID,
+					Type:          model.Authorize,
+				}).Expect().Status(http.StatusCreated)
+			})
+
+			It("should not be deleted", func() {
+				testApp.ExpectWithAuth.DELETE("/merchant/" + merchantID).Expect().Status(http.StatusConflict)
+				testApp.ExpectWithAuth.GET("/merchant/" + merchantID).Expect().Status(http.StatusOK)
+			})
+		})
+	})
+
+	It("should not find unknown merchant", func() {
+		testApp.ExpectWithAuth.GET("/merchant/unknown").Expect().Status(http.StatusNotFound)
+	})
+})
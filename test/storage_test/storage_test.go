@@ -0,0 +1,44 @@
+package storage_test
+
+import (
+	"database/sql"
+	"path"
+	"runtime"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/config"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/gormdb"
+	"github.com/pankrator/payment/storage/storagetest"
+	"github.com/spf13/afero"
+)
+
+func TestStorage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Storage integration tests")
+}
+
+var repository *gormdb.Storage
+
+var _ = BeforeSuite(func() {
+	_, b, _, _ := runtime.Caller(0)
+	cfg, err := config.New(path.Dir(path.Dir(b)), afero.NewOsFs())
+	Expect(err).ShouldNot(HaveOccurred())
+
+	repository = gormdb.New(config.Load(cfg).Storage)
+	Expect(repository.Open(func(driver, url string) (*sql.DB, error) {
+		return sql.Open(driver, url)
+	})).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if repository != nil && repository.DB != nil {
+		repository.Close()
+	}
+})
+
+var _ = storagetest.DescribeConformance("Postgres", func() storage.Storage {
+	return repository
+})
@@ -1,11 +1,15 @@
 package transaction_test
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pankrator/payment/criteria"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/storage"
 	"github.com/pankrator/payment/test"
@@ -25,20 +29,133 @@ var _ = Describe("Transactions", func() {
 		Name:                "merchant",
 		Email:               "merchant@mail.com",
 		Status:              true,
-		TotalTransactionSum: 0,
+		TotalTransactionSum: model.Balance{},
 	}
 	BeforeSuite(func() {
 		testApp = test.NewTestApp()
 	})
 
 	BeforeEach(func() {
-		_, err := testApp.Repository.Create(merchant)
+		_, err := testApp.Repository.Create(context.Background(), merchant)
 		Expect(err).ShouldNot(HaveOccurred())
 	})
 
 	AfterEach(func() {
-		testApp.Repository.DeleteAll(model.TransactionObjectType)
-		testApp.Repository.DeleteAll(model.MerchantType)
+		testApp.Repository.DeleteAll(context.Background(), model.IdempotencyKeyType)
+		testApp.Repository.DeleteAll(context.Background(), model.LedgerEntryType)
+		testApp.Repository.DeleteAll(context.Background(), model.TransactionStatusChangeType)
+		testApp.Repository.DeleteAll(context.Background(), model.ArchivedTransactionType)
+		testApp.Repository.DeleteAll(context.Background(), model.TransactionObjectType)
+		testApp.Repository.DeleteAll(context.Background(), model.MerchantType)
+	})
+
+	When("transaction is created with an idempotency key", func() {
+		var transaction *model.Transaction
+		var transactionID string
+		BeforeEach(func() {
+			transaction = &model.Transaction{
+				Amount:        10,
+				CustomerEmail: "email",
+				CustomerPhone: "0000000",
+				MerchantID:    merchant.UUID,
+				Type:          model.Authorize,
+				Currency:      model.EUR,
+			}
+			transactionID = testApp.ExpectWithAuth.POST("/payment").WithHeader("Idempotency-Key", "key").WithJSON(transaction).
+				Expect().Status(http.StatusCreated).JSON().Object().Value("uuid").String().Raw()
+		})
+
+		It("should replay the response on retry", func() {
+			response := testApp.ExpectWithAuth.POST("/payment").WithHeader("Idempotency-Key", "key").WithJSON(transaction).Expect()
+			response.Status(http.StatusCreated).Header("Idempotent-Replayed").Equal("true")
+			response.JSON().Object().Value("uuid").String().Equal(transactionID)
+
+			count, err := testApp.Repository.Count(context.Background(), model.TransactionObjectType, criteria.Eq("merchant_id", merchant.UUID))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		It("should not accept a different request with the same key", func() {
+			transaction.Amount = 20
+			testApp.ExpectWithAuth.POST("/payment").WithHeader("Idempotency-Key", "key").WithJSON(transaction).
+				Expect().Status(http.StatusUnprocessableEntity).JSON().Object().
+				Value("description").String().Contains("idempotency key is already used for a different request")
+		})
+
+		It("should replay a rejected transaction as well", func() {
+			charge := &model.Transaction{
+				Amount:        10,
+				CustomerEmail: "email",
+				CustomerPhone: "0000000",
+				MerchantID:    merchant.UUID,
+				Type:          model.Charge,
+				DependsOnUUID: "unknown",
+			}
+			testApp.ExpectWithAuth.POST("/payment").WithHeader("Idempotency-Key", "charge-key").WithJSON(charge).
+				Expect().Status(http.StatusBadRequest)
+			testApp.ExpectWithAuth.POST("/payment").WithHeader("Idempotency-Key", "charge-key").WithJSON(charge).
+				Expect().Status(http.StatusBadRequest).Header("Idempotent-Replayed").Equal("true")
+		})
+
+		It("should create a new transaction for another key", func() {
+			testApp.ExpectWithAuth.POST("/payment").WithHeader("Idempotency-Key", "other-key").WithJSON(transaction).
+				Expect().Status(http.StatusCreated).JSON().Object().Value("uuid").String().NotEqual(transactionID)
+		})
+	})
+
+	When("transactions are listed", func() {
+		BeforeEach(func() {
+			for _, amount := range []int{1, 2, 3} {
+				testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+					Amount:        amount,
+					Currency:      model.EUR,
+					CustomerEmail: "email",
+					CustomerPhone: "0000000",
+					MerchantID:    merchant.UUID,
+					Type:          model.Authorize,
+				}).Expect().Status(http.StatusCreated)
+			}
+		})
+
+		It("should return them page by page", func() {
+			response := testApp.ExpectWithAuth.GET("/payment").WithQuery("limit", 2).WithQuery("order_by", "-amount").Expect()
+			response.JSON().Array().Path("$..amount").Equal([]int{3, 2})
+			link := response.Header("Link").Raw()
+			Expect(link).To(HavePrefix("</payment?"))
+			Expect(link).To(HaveSuffix(`>; rel="next"`))
+
+			next := link[1:strings.Index(link, ">")]
+			response = testApp.ExpectWithAuth.GET(next).Expect()
+			response.JSON().Array().Path("$..amount").Equal([]int{1})
+			response.Header("Link").Empty()
+		})
+
+		It("should filter them", func() {
+			testApp.ExpectWithAuth.GET("/payment").
+				WithQuery("filter", "type eq authorize and amount between 2 and 3 and depends_on_uuid is null").
+				WithQuery("order_by", "amount").
+				Expect().Status(http.StatusCreated).JSON().Array().Path("$..amount").Equal([]int{2, 3})
+		})
+
+		It("should not filter by fields which are not whitelisted", func() {
+			testApp.ExpectWithAuth.GET("/payment").WithQuery("filter", "final_capture eq true").
+				Expect().Status(http.StatusBadRequest).JSON().Object().
+				Value("description").String().Contains("filtering by final_capture is not supported")
+		})
+
+		It("should not accept an invalid limit", func() {
+			testApp.ExpectWithAuth.GET("/payment").WithQuery("limit", 0).Expect().Status(http.StatusBadRequest)
+		})
+
+		It("should not order by unknown fields", func() {
+			testApp.ExpectWithAuth.GET("/payment").WithQuery("order_by", "unknown").Expect().Status(http.StatusBadRequest)
+		})
+
+		It("should not order by nullable fields", func() {
+			testApp.ExpectWithAuth.GET("/payment").WithQuery("order_by", "-depends_on_uuid").
+				Expect().Status(http.StatusBadRequest).JSON().Object().
+				Value("description").String().Contains("ordering by depends_on_uuid is not supported")
+		})
 	})
 
 	When("authorize transaction is created", func() {
@@ -50,6 +167,7 @@ var _ = Describe("Transactions", func() {
 				CustomerPhone: "0000000",
 				MerchantID:    merchant.UUID,
 				Type:          model.Authorize,
+				Currency:      model.EUR,
 			}
 			authorizeTransactionID = testApp.ExpectWithAuth.POST("/payment").WithJSON(transaction).Expect().
 				Status(http.StatusCreated).JSON().Object().Value("uuid").String().Raw()
@@ -62,12 +180,13 @@ var _ = Describe("Transactions", func() {
 				CustomerPhone: "0000000",
 				MerchantID:    "1",
 				Type:          model.Refund,
+				Currency:      model.EUR,
 				DependsOnUUID: authorizeTransactionID,
 			}).Expect().Status(http.StatusBadRequest).JSON().Object().Value("description").String().Contains("parent transaction should be of type charge")
 		})
 
 		It("should find authorize transaction in db", func() {
-			result, err := testApp.Repository.Get(model.TransactionObjectType, authorizeTransactionID)
+			result, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, authorizeTransactionID)
 			Expect(err).ShouldNot(HaveOccurred())
 			t := result.(*model.Transaction)
 			Expect(result).To(Equal(&model.Transaction{
@@ -78,11 +197,154 @@ var _ = Describe("Transactions", func() {
 				CustomerPhone: "0000000",
 				MerchantID:    merchant.UUID,
 				Type:          model.Authorize,
+				Currency:      model.EUR,
 				CreatedAt:     t.CreatedAt,
 				UpdatedAt:     t.UpdatedAt,
 			}))
 		})
 
+		It("should not be able to charge in another currency", func() {
+			testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+				Amount:        10,
+				Currency:      model.USD,
+				CustomerEmail: "email",
+				CustomerPhone: "0000000",
+				MerchantID:    "1",
+				Type:          model.Charge,
+				DependsOnUUID: authorizeTransactionID,
+			}).Expect().Status(http.StatusBadRequest).JSON().Object().
+				Value("description").String().Contains("currency USD does not match the parent transaction currency EUR")
+
+			assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 0)
+		})
+
+		It("should not capture more than the authorized amount on concurrent charges", func() {
+			statusCodes := sendConcurrently(testApp, 5, &model.Transaction{
+				Amount:        3,
+				CustomerEmail: "email",
+				CustomerPhone: "0000000",
+				MerchantID:    "1",
+				Type:          model.Charge,
+				Currency:      model.EUR,
+				DependsOnUUID: authorizeTransactionID,
+			})
+			Expect(countStatus(statusCodes, http.StatusCreated)).To(Equal(3))
+			Expect(countStatus(statusCodes, http.StatusBadRequest)).To(Equal(2))
+
+			object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, authorizeTransactionID)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(object.(*model.Transaction).CapturedAmount).To(Equal(9))
+			assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 9)
+		})
+
+		It("should accept only one of concurrent reversals", func() {
+			statusCodes := sendConcurrently(testApp, 5, &model.Transaction{
+				Amount:        10,
+				CustomerEmail: "email",
+				CustomerPhone: "0000000",
+				MerchantID:    "1",
+				Type:          model.Reversal,
+				Currency:      model.EUR,
+				DependsOnUUID: authorizeTransactionID,
+			})
+			Expect(countStatus(statusCodes, http.StatusCreated)).To(Equal(1))
+
+			count, err := testApp.Repository.Count(context.Background(), model.TransactionObjectType, criteria.Eq("depends_on_uuid", authorizeTransactionID))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		When("authorize transaction is charged partially", func() {
+			BeforeEach(func() {
+				testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+					Amount:        4,
+					CustomerEmail: "email",
+					CustomerPhone: "0000000",
+					MerchantID:    "1",
+					Type:          model.Charge,
+					Currency:      model.EUR,
+					DependsOnUUID: authorizeTransactionID,
+				}).Expect().Status(http.StatusCreated)
+			})
+
+			It("should track the remaining authorized amount", func() {
+				object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, authorizeTransactionID)
+				Expect(err).ShouldNot(HaveOccurred())
+				transaction := object.(*model.Transaction)
+				Expect(transaction.Status).To(Equal(model.Approved))
+				Expect(transaction.RemainingAmount()).To(Equal(6))
+				assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 4)
+			})
+
+			It("should return the authorization with its chain", func() {
+				chain := testApp.ExpectWithAuth.GET("/payment/" + authorizeTransactionID).
+					Expect().Status(http.StatusOK).JSON().Object()
+				chain.Value("transaction").Object().Value("uuid").Equal(authorizeTransactionID)
+				chain.Value("ancestors").Array().Empty()
+				chain.Value("descendants").Array().Length().Equal(1)
+				chain.Value("descendants").Array().Element(0).Object().Value("type").Equal(model.Charge)
+			})
+
+			It("should not find missing transactions", func() {
+				testApp.ExpectWithAuth.GET("/payment/00000000-0000-0000-0000-000000000000").
+					Expect().Status(http.StatusNotFound)
+			})
+
+			It("should not be able to charge more than the remaining amount", func() {
+				testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+					Amount:        7,
+					CustomerEmail: "email",
+					CustomerPhone: "0000000",
+					MerchantID:    "1",
+					Type:          model.Charge,
+					Currency:      model.EUR,
+					DependsOnUUID: authorizeTransactionID,
+				}).Expect().Status(http.StatusBadRequest).JSON().Object().
+					Value("description").String().Contains("amount exceeds the remaining authorized amount: 6")
+
+				assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 4)
+			})
+
+			It("should be able to charge the rest in several charges", func() {
+				for _, amount := range []int{2, 4} {
+					testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+						Amount:        amount,
+						CustomerEmail: "email",
+						CustomerPhone: "0000000",
+						MerchantID:    "1",
+						Type:          model.Charge,
+						Currency:      model.EUR,
+						DependsOnUUID: authorizeTransactionID,
+					}).Expect().Status(http.StatusCreated)
+				}
+
+				object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, authorizeTransactionID)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(object.(*model.Transaction).Status).To(Equal(model.Captured))
+				assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 10)
+			})
+
+			It("should release the rest on final capture", func() {
+				testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+					Amount:        1,
+					FinalCapture:  true,
+					CustomerEmail: "email",
+					CustomerPhone: "0000000",
+					MerchantID:    "1",
+					Type:          model.Charge,
+					Currency:      model.EUR,
+					DependsOnUUID: authorizeTransactionID,
+				}).Expect().Status(http.StatusCreated)
+
+				object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, authorizeTransactionID)
+				Expect(err).ShouldNot(HaveOccurred())
+				transaction := object.(*model.Transaction)
+				Expect(transaction.Status).To(Equal(model.Captured))
+				Expect(transaction.CapturedAmount).To(Equal(5))
+				assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 5)
+			})
+		})
+
 		When("charge transaction is created for the authorize one", func() {
 			var chargeTransactionID string
 			BeforeEach(func() {
@@ -92,12 +354,13 @@ var _ = Describe("Transactions", func() {
 					CustomerPhone: "0000000",
 					MerchantID:    "1",
 					Type:          model.Charge,
+					Currency:      model.EUR,
 					DependsOnUUID: authorizeTransactionID,
 				}).Expect().Status(http.StatusCreated).JSON().Object().Value("uuid").String().Raw()
 			})
 
 			It("should be found in database", func() {
-				object, err := testApp.Repository.Get(model.TransactionObjectType, chargeTransactionID)
+				object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, chargeTransactionID)
 				Expect(err).ShouldNot(HaveOccurred())
 				transaction := object.(*model.Transaction)
 				Expect(transaction.Type).To(Equal(model.Charge))
@@ -109,16 +372,25 @@ var _ = Describe("Transactions", func() {
 				assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 10)
 			})
 
-			It("should not be able to create another charge for the same parent", func() {
+			It("should capture the authorize transaction", func() {
+				object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, authorizeTransactionID)
+				Expect(err).ShouldNot(HaveOccurred())
+				transaction := object.(*model.Transaction)
+				Expect(transaction.Status).To(Equal(model.Captured))
+				Expect(transaction.CapturedAmount).To(Equal(10))
+			})
+
+			It("should record another charge for the same parent as errored", func() {
 				testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
 					Amount:        10,
 					CustomerEmail: "email",
 					CustomerPhone: "0000000",
 					MerchantID:    "1",
 					Type:          model.Charge,
+					Currency:      model.EUR,
 					DependsOnUUID: authorizeTransactionID,
-				}).Expect().Status(http.StatusBadRequest).JSON().Object().
-					Value("description").String().Contains("the parent transaction is already followed")
+				}).Expect().Status(http.StatusCreated).JSON().Object().
+					Value("status").String().Equal(string(model.Errored))
 
 				assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 10)
 			})
@@ -130,6 +402,7 @@ var _ = Describe("Transactions", func() {
 					CustomerPhone: "0000000",
 					MerchantID:    "1",
 					Type:          model.Authorize,
+					Currency:      model.EUR,
 					DependsOnUUID: chargeTransactionID,
 				}).Expect().Status(http.StatusBadRequest).JSON().Object().
 					Value("description").String().Contains("transaction of type authorize cannot depend on another transaction")
@@ -142,6 +415,7 @@ var _ = Describe("Transactions", func() {
 					CustomerPhone: "0000000",
 					MerchantID:    "1",
 					Type:          model.Reversal,
+					Currency:      model.EUR,
 					DependsOnUUID: chargeTransactionID,
 				}).Expect().Status(http.StatusBadRequest).JSON().Object().
 					Value("description").String().Contains("parent transaction should be of type authorize")
@@ -156,12 +430,13 @@ var _ = Describe("Transactions", func() {
 						CustomerPhone: "0000000",
 						MerchantID:    "1",
 						Type:          model.Refund,
+						Currency:      model.EUR,
 						DependsOnUUID: chargeTransactionID,
 					}).Expect().Status(http.StatusCreated).JSON().Object().Value("uuid").String().Raw()
 				})
 
 				It("should be found in database", func() {
-					object, err := testApp.Repository.Get(model.TransactionObjectType, refundTransactionID)
+					object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, refundTransactionID)
 					Expect(err).ShouldNot(HaveOccurred())
 					transaction := object.(*model.Transaction)
 					Expect(transaction.Type).To(Equal(model.Refund))
@@ -170,7 +445,7 @@ var _ = Describe("Transactions", func() {
 				})
 
 				It("should change the charge to refunded state", func() {
-					object, err := testApp.Repository.Get(model.TransactionObjectType, chargeTransactionID)
+					object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, chargeTransactionID)
 					Expect(err).ShouldNot(HaveOccurred())
 					transaction := object.(*model.Transaction)
 					Expect(transaction.Type).To(Equal(model.Charge))
@@ -182,15 +457,79 @@ var _ = Describe("Transactions", func() {
 					assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 0)
 				})
 
-				It("should not be able to refund twice", func() {
+				It("should record another refund of the same charge as errored", func() {
 					testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
 						Amount:        10,
 						CustomerEmail: "email",
 						CustomerPhone: "0000000",
 						MerchantID:    "1",
 						Type:          model.Refund,
+						Currency:      model.EUR,
 						DependsOnUUID: chargeTransactionID,
-					}).Expect().Status(http.StatusBadRequest).JSON().Object().Value("description").String().Contains("the parent transaction is already followed")
+					}).Expect().Status(http.StatusCreated).JSON().Object().
+						Value("status").String().Equal(string(model.Errored))
+
+					assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 0)
+				})
+			})
+
+			When("charge transaction is refunded partially", func() {
+				BeforeEach(func() {
+					testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+						Amount:        3,
+						CustomerEmail: "email",
+						CustomerPhone: "0000000",
+						MerchantID:    "1",
+						Type:          model.Refund,
+						Currency:      model.EUR,
+						DependsOnUUID: chargeTransactionID,
+					}).Expect().Status(http.StatusCreated)
+				})
+
+				It("should change the charge to partially refunded state", func() {
+					object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, chargeTransactionID)
+					Expect(err).ShouldNot(HaveOccurred())
+					transaction := object.(*model.Transaction)
+					Expect(transaction.Status).To(Equal(model.PartiallyRefunded))
+					Expect(transaction.RefundedAmount).To(Equal(3))
+				})
+
+				It("should have taken only the refunded amount from the merchant", func() {
+					assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 7)
+				})
+
+				It("should be able to refund the rest in several refunds", func() {
+					for _, amount := range []int{3, 4} {
+						testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+							Amount:        amount,
+							CustomerEmail: "email",
+							CustomerPhone: "0000000",
+							MerchantID:    "1",
+							Type:          model.Refund,
+							Currency:      model.EUR,
+							DependsOnUUID: chargeTransactionID,
+						}).Expect().Status(http.StatusCreated)
+					}
+
+					object, err := testApp.Repository.Get(context.Background(), model.TransactionObjectType, chargeTransactionID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(object.(*model.Transaction).Status).To(Equal(model.Refunded))
+					assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 0)
+				})
+
+				It("should not be able to refund more than the charged amount", func() {
+					testApp.ExpectWithAuth.POST("/payment").WithJSON(&model.Transaction{
+						Amount:        8,
+						CustomerEmail: "email",
+						CustomerPhone: "0000000",
+						MerchantID:    "1",
+						Type:          model.Refund,
+						Currency:      model.EUR,
+						DependsOnUUID: chargeTransactionID,
+					}).Expect().Status(http.StatusBadRequest).JSON().Object().
+						Value("description").String().Contains("amount exceeds the refundable amount: 7")
+
+					assertMerchantTotalAmount(testApp.Repository, merchant.UUID, 7)
 				})
 			})
 		})
@@ -198,8 +537,33 @@ var _ = Describe("Transactions", func() {
 })
 
 func assertMerchantTotalAmount(repository storage.Storage, id string, amount int) {
-	object, err := repository.Get(model.MerchantType, id)
+	object, err := repository.Get(context.Background(), model.MerchantType, id)
 	Expect(err).ShouldNot(HaveOccurred())
 	merchant := object.(*model.Merchant)
-	Expect(merchant.TotalTransactionSum).To(Equal(int64(amount)))
+	Expect(merchant.TotalTransactionSum[model.EUR]).To(Equal(int64(amount)))
+}
+
+func sendConcurrently(testApp *test.TestApp, requests int, transaction *model.Transaction) []int {
+	statusCodes := make([]int, requests)
+	wg := sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer GinkgoRecover()
+			defer wg.Done()
+			statusCodes[i] = testApp.ExpectWithAuth.POST("/payment").WithJSON(transaction).Expect().Raw().StatusCode
+		}(i)
+	}
+	wg.Wait()
+	return statusCodes
+}
+
+func countStatus(statusCodes []int, status int) int {
+	count := 0
+	for _, statusCode := range statusCodes {
+		if statusCode == status {
+			count++
+		}
+	}
+	return count
 }
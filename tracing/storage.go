@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pankrator/payment/criteria"
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/query"
+	"github.com/pankrator/payment/storage"
+)
+
+// Storage starts a span for every operation of the decorated storage. Opening, closing and pinging it are not traced.
+type Storage struct {
+	storage.Storage
+}
+
+func NewStorage(repository storage.Storage) storage.Storage {
+	return &Storage{Storage: repository}
+}
+
+func (s *Storage) Create(ctx context.Context, object model.Object) (model.Object, error) {
+	ctx, span := start(ctx, "create", object.GetType())
+	result, err := s.Storage.Create(ctx, object)
+	end(span, err)
+	return result, err
+}
+
+func (s *Storage) Save(ctx context.Context, object model.Object) error {
+	ctx, span := start(ctx, "save", object.GetType())
+	err := s.Storage.Save(ctx, object)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) DeleteAll(ctx context.Context, typee string) error {
+	ctx, span := start(ctx, "delete_all", typee)
+	err := s.Storage.DeleteAll(ctx, typee)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) Delete(ctx context.Context, typee string, c criteria.Criterion) error {
+	ctx, span := start(ctx, "delete", typee)
+	err := s.Storage.Delete(ctx, typee, c)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) Get(ctx context.Context, typee string, id string) (model.Object, error) {
+	ctx, span := start(ctx, "get", typee)
+	result, err := s.Storage.Get(ctx, typee, id)
+	end(span, err)
+	return result, err
+}
+
+func (s *Storage) GetForUpdate(ctx context.Context, typee string, id string) (model.Object, error) {
+	ctx, span := start(ctx, "get_for_update", typee)
+	result, err := s.Storage.GetForUpdate(ctx, typee, id)
+	end(span, err)
+	return result, err
+}
+
+func (s *Storage) GetBy(ctx context.Context, typee string, c criteria.Criterion) (model.Object, error) {
+	ctx, span := start(ctx, "get_by", typee)
+	result, err := s.Storage.GetBy(ctx, typee, c)
+	end(span, err)
+	return result, err
+}
+
+func (s *Storage) List(ctx context.Context, typee string, c criteria.Criterion) ([]model.Object, error) {
+	ctx, span := start(ctx, "list", typee)
+	result, err := s.Storage.List(ctx, typee, c)
+	span.SetAttributes(attribute.Int("storage.results", len(result)))
+	end(span, err)
+	return result, err
+}
+
+func (s *Storage) ListPage(ctx context.Context, typee string, page query.Page, c criteria.Criterion) ([]model.Object, string, error) {
+	ctx, span := start(ctx, "list_page", typee)
+	result, next, err := s.Storage.ListPage(ctx, typee, page, c)
+	span.SetAttributes(attribute.Int("storage.results", len(result)))
+	end(span, err)
+	return result, next, err
+}
+
+func (s *Storage) Count(ctx context.Context, typee string, c criteria.Criterion) (int, error) {
+	ctx, span := start(ctx, "count", typee)
+	result, err := s.Storage.Count(ctx, typee, c)
+	end(span, err)
+	return result, err
+}
+
+// Transaction starts a span for the whole transaction. The operations in it are traced as well, with the span in the
+// context they are given as their parent.
+func (s *Storage) Transaction(ctx context.Context, f func(s storage.Storage) error) error {
+	ctx, span := start(ctx, "transaction", "")
+	err := s.Storage.Transaction(ctx, func(tx storage.Storage) error {
+		return f(NewStorage(tx))
+	})
+	end(span, err)
+	return err
+}
+
+func start(ctx context.Context, operation, typee string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attribute.String("storage.operation", operation)}
+	if typee != "" {
+		attributes = append(attributes, attribute.String("storage.type", typee))
+	}
+	return Start(ctx, "storage."+operation, attributes...)
+}
+
+// end does not record objects which are not found as errors, as the services expect them
+func end(span trace.Span, err error) {
+	if err == storage.ErrNotFound {
+		err = nil
+	}
+	End(span, err)
+}
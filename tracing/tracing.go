@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pankrator/payment/log"
+	"github.com/pankrator/payment/web"
+)
+
+// Exporters of the spans
+const (
+	NoExporter     = "none"
+	StdoutExporter = "stdout"
+	OTLPExporter   = "otlp"
+)
+
+const tracerName = "github.com/pankrator/payment"
+
+type Settings struct {
+	// Exporter is where the spans are sent: none, stdout or otlp. Without an exporter the trace context is still
+	// propagated to the outgoing requests.
+	Exporter string `mapstructure:"exporter"`
+	// OTLPEndpoint is the host and port of the collector receiving OTLP over http
+	OTLPEndpoint string `mapstructure:"otlp_endpoint"`
+	OTLPInsecure bool   `mapstructure:"otlp_insecure"`
+	ServiceName  string `mapstructure:"service_name"`
+	// SampleRatio is the part of the traces started here which are sampled. Traces of the callers are sampled
+	// as the callers decided.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+func DefaultSettings() *Settings {
+	return &Settings{
+		Exporter:     NoExporter,
+		OTLPEndpoint: "localhost:4318",
+		ServiceName:  "payment",
+		SampleRatio:  1,
+	}
+}
+
+func (s *Settings) Keys() []string {
+	return []string{
+		"exporter",
+		"otlp_endpoint",
+		"otlp_insecure",
+		"service_name",
+		"sample_ratio",
+	}
+}
+
+// Tracing exports the spans of the application. The methods of a nil Tracing do nothing.
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+}
+
+// New sets the global tracer provider and the W3C trace context propagation
+func New(ctx context.Context, settings *Settings) (*Tracing, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch settings.Exporter {
+	case NoExporter, "":
+		return nil, nil
+	case StdoutExporter:
+		exporter, err = stdouttrace.New()
+	case OTLPExporter:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(settings.OTLPEndpoint)}
+		if settings.OTLPInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", settings.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s trace exporter: %s", settings.Exporter, err)
+	}
+	return NewWithExporter(settings, exporter), nil
+}
+
+// NewWithExporter sets the global tracer provider to one sending the spans to the given exporter
+func NewWithExporter(settings *Settings, exporter sdktrace.SpanExporter) *Tracing {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", settings.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return &Tracing{provider: provider}
+}
+
+// Shutdown exports the remaining spans
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// WrapRoute starts a span for every request of the route, which continues the trace of the caller.
+// The span is named by the path template of the route, e.g. GET /payment/{uuid}.
+func WrapRoute(route web.Route, handler http.HandlerFunc) http.HandlerFunc {
+	method, path := route.Endpoint.Method, route.Endpoint.Path
+	traced := otelhttp.NewHandler(withTraceID(handler), method+" "+path,
+		otelhttp.WithSpanOptions(trace.WithAttributes(attribute.String("http.route", path))),
+	)
+	return traced.ServeHTTP
+}
+
+// withTraceID logs the id of the trace with the entries of the request
+func withTraceID(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		spanContext := trace.SpanContextFromContext(req.Context())
+		if spanContext.HasTraceID() {
+			ctx := log.ContextWithFields(req.Context(), logrus.Fields{"trace_id": spanContext.TraceID().String()})
+			req = req.WithContext(ctx)
+		}
+		handler(rw, req)
+	}
+}
+
+// Start starts a span, which is a child of the span in the context if there is one
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error, if any, and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport traces the outgoing requests and sends the trace context with them
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
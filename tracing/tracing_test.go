@@ -0,0 +1,149 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pankrator/payment/model"
+	"github.com/pankrator/payment/services"
+	"github.com/pankrator/payment/storage"
+	"github.com/pankrator/payment/storage/memory"
+	"github.com/pankrator/payment/tracing"
+	"github.com/pankrator/payment/web"
+)
+
+type passFilter struct{}
+
+func (f *passFilter) Execute(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	next(rw, req)
+}
+
+var _ = Describe("Tracing", func() {
+	var recorder *tracetest.SpanRecorder
+
+	spanNamed := func(name string) sdktrace.ReadOnlySpan {
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				return span
+			}
+		}
+		Fail("no span named " + name)
+		return nil
+	}
+
+	BeforeEach(func() {
+		_, err := tracing.New(context.Background(), tracing.DefaultSettings())
+		Expect(err).ShouldNot(HaveOccurred())
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+
+	It("should not accept unknown exporters", func() {
+		settings := tracing.DefaultSettings()
+		settings.Exporter = "unknown"
+		_, err := tracing.New(context.Background(), settings)
+		Expect(err).To(MatchError("unknown trace exporter unknown"))
+	})
+
+	It("should continue the trace of the caller in the span of the route", func() {
+		route := web.Route{Endpoint: web.Endpoint{Method: http.MethodGet, Path: "/payment/{uuid}"}}
+		var handlerSpan trace.SpanContext
+		handler := tracing.WrapRoute(route, func(rw http.ResponseWriter, req *http.Request) {
+			handlerSpan = trace.SpanContextFromContext(req.Context())
+		})
+		req := httptest.NewRequest(http.MethodGet, "/payment/1", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		handler(httptest.NewRecorder(), req)
+
+		span := spanNamed("GET /payment/{uuid}")
+		Expect(span.SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(span.Parent().SpanID().String()).To(Equal("00f067aa0ba902b7"))
+		Expect(handlerSpan.SpanID()).To(Equal(span.SpanContext().SpanID()))
+	})
+
+	It("should end the span of a filter when it passes the request on", func() {
+		var handlerSpan trace.SpanContext
+		handler := web.Chain(func(rw http.ResponseWriter, req *http.Request) {
+			handlerSpan = trace.SpanContextFromContext(req.Context())
+			Expect(recorder.Ended()).To(HaveLen(1))
+		}, []web.Filter{&passFilter{}})
+
+		ctx, root := tracing.Start(context.Background(), "root")
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		root.End()
+
+		span := spanNamed("filter.passFilter")
+		Expect(span.Parent().SpanID()).To(Equal(root.SpanContext().SpanID()))
+		Expect(handlerSpan.SpanID()).To(Equal(root.SpanContext().SpanID()))
+	})
+
+	It("should trace the storage operations of the services", func() {
+		repository := tracing.NewStorage(memory.New())
+		object, err := services.NewMerchantService(repository).Create(context.Background(), &model.Merchant{
+			Name:   "merchant",
+			Email:  "merchant@mail.com",
+			Status: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		recorder.Reset()
+
+		paymentService := services.NewPaymentService(repository, services.DefaultLedgerSettings(), services.DefaultAuthorizationSettings(), nil)
+		_, err = paymentService.Create(context.Background(), &model.Transaction{
+			Type:          model.Authorize,
+			Currency:      model.EUR,
+			Amount:        10,
+			CustomerEmail: "user@customer.com",
+			MerchantID:    object.(*model.Merchant).UUID,
+		}, "user")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		create := spanNamed("PaymentService.Create")
+		transaction := spanNamed("storage.transaction")
+		Expect(transaction.Parent().SpanID()).To(Equal(create.SpanContext().SpanID()))
+		get := spanNamed("storage.get_for_update")
+		Expect(get.Parent().SpanID()).To(Equal(create.SpanContext().SpanID()))
+		Expect(get.StartTime()).To(BeTemporally(">=", transaction.StartTime()))
+		Expect(get.EndTime()).To(BeTemporally("<=", transaction.EndTime()))
+	})
+
+	It("should record the errors of the storage but not the objects which are not found", func() {
+		repository := tracing.NewStorage(memory.New())
+		_, err := repository.Get(context.Background(), model.MerchantType, "unknown")
+		Expect(err).To(Equal(storage.ErrNotFound))
+		Expect(spanNamed("storage.get").Status().Code).To(Equal(codes.Unset))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = repository.Count(ctx, model.MerchantType, nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(spanNamed("storage.count").Status().Code).To(Equal(codes.Error))
+	})
+
+	It("should send the trace context with the outgoing requests", func() {
+		var traceparent string
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			traceparent = req.Header.Get("traceparent")
+		}))
+		defer server.Close()
+
+		ctx, span := tracing.Start(context.Background(), "root")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		client := &http.Client{Transport: tracing.Transport(http.DefaultTransport)}
+		resp, err := client.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		resp.Body.Close()
+		span.End()
+
+		Expect(traceparent).To(ContainSubstring(span.SpanContext().TraceID().String()))
+	})
+})
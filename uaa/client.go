@@ -5,10 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/pankrator/payment/auth"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/web"
 )
 
@@ -70,8 +70,8 @@ func (uc *UAAClient) CreateUser(ctx context.Context, username, email, password s
 	}
 	reader := bytes.NewReader(createUserBytes)
 
-	log.Printf("Requesting UAA to endpoint %s", uc.config.URL+"/Users")
-	req, err := http.NewRequest(http.MethodPost, uc.config.URL+"/Users", reader)
+	log.C(ctx).Infof("Requesting UAA to endpoint %s", uc.config.URL+"/Users")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uc.config.URL+"/Users", reader)
 	req.Header.Set("Content-Type", "application/json")
 	if err != nil {
 		return "", err
@@ -89,7 +89,7 @@ func (uc *UAAClient) CreateUser(ctx context.Context, username, email, password s
 
 	switch resp.StatusCode {
 	case http.StatusConflict:
-		log.Printf("User with name %s already exists, skipping it", username)
+		log.C(ctx).Infof("User with name %s already exists, skipping it", username)
 		return "", nil
 	case http.StatusCreated:
 		return result["id"].(string), nil
@@ -99,7 +99,7 @@ func (uc *UAAClient) CreateUser(ctx context.Context, username, email, password s
 }
 
 func (uc *UAAClient) GetGroup(ctx context.Context, displayName string) (*Group, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/Groups", uc.config.URL), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/Groups", uc.config.URL), nil)
 	query := req.URL.Query()
 	query.Add("filter", fmt.Sprintf(`displayName eq "%s"`, displayName))
 	if err != nil {
@@ -121,12 +121,12 @@ func (uc *UAAClient) GetGroup(ctx context.Context, displayName string) (*Group,
 	if err = web.BodyToObject(resp.Body, groups); err != nil {
 		return nil, err
 	}
-	log.Printf("Found group with name: %s", groups.Resources[0].DisplayName)
+	log.C(ctx).Infof("Found group with name: %s", groups.Resources[0].DisplayName)
 	return groups.Resources[0], nil
 }
 
 func (uc *UAAClient) AddUserToGroup(ctx context.Context, userID string, group *Group) (bool, error) {
-	log.Printf("Adding user %s to group %s", userID, group.DisplayName)
+	log.C(ctx).Infof("Adding user %s to group %s", userID, group.DisplayName)
 	body := map[string]interface{}{}
 	body["members"] = []map[string]interface{}{
 		{
@@ -143,7 +143,7 @@ func (uc *UAAClient) AddUserToGroup(ctx context.Context, userID string, group *G
 	}
 
 	reader := bytes.NewReader(bodyBytes)
-	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/Groups/%s", uc.config.URL, group.ID), reader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, fmt.Sprintf("%s/Groups/%s", uc.config.URL, group.ID), reader)
 	if err != nil {
 		return false, err
 	}
@@ -161,7 +161,7 @@ func (uc *UAAClient) AddUserToGroup(ctx context.Context, userID string, group *G
 	}
 	switch resp.StatusCode {
 	case http.StatusConflict:
-		log.Printf("User is already a member of the group")
+		log.C(ctx).Infof("User is already a member of the group")
 		return false, nil
 	case http.StatusOK:
 		return true, nil
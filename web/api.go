@@ -1,6 +1,10 @@
 package web
 
-import "github.com/pankrator/payment/model"
+import (
+	"net/http"
+
+	"github.com/pankrator/payment/model"
+)
 
 // Controller is the interface that should be implemented by structures to be controllers
 type Controller interface {
@@ -18,8 +22,18 @@ type Route struct {
 	// ModelBlueprint returns an instance of the model for which the endpoint handler is responsible for
 	ModelBlueprint func() model.Object
 
-	// Scopes returns the scopes this endpoint needs in order to be accessible
+	// Scopes returns the scopes this endpoint needs in order to be accessible. They can be declared only for
+	// routes which require authentication.
 	Scopes func() []string
+
+	// Public routes are accessible without authentication. Every route is either Public or requires Auth.
+	Public bool
+
+	// Auth routes are accessible only to authenticated users
+	Auth bool
+
+	// MerchantScoped routes see only the data of the merchant of the authenticated user
+	MerchantScoped bool
 }
 
 // Endpoint is the pair of the http method and path
@@ -30,6 +44,14 @@ type Endpoint struct {
 
 // Api contains all the controllers for the instantiated Api and can be attached to the http server
 type Api struct {
-	Filters     []Filter
+	// AuthFilter authenticates the users of the routes which require authentication
+	AuthFilter Filter
+	// MerchantFilter limits the merchant scoped routes to the data of the merchant of the user
+	MerchantFilter Filter
+	// Wrappers wrap the handlers of all routes, outside of their filters
+	Wrappers    []RouteWrapper
 	Controllers []Controller
 }
+
+// RouteWrapper wraps the handler of the route, e.g. to observe its requests
+type RouteWrapper func(route Route, handler http.HandlerFunc) http.HandlerFunc
@@ -1,27 +1,52 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
-	"strings"
+	"reflect"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName is the name of the tracer of the application, which the tracing package configures
+const tracerName = "github.com/pankrator/payment"
+
 type Filter interface {
 	Execute(http.ResponseWriter, *http.Request, http.HandlerFunc)
-	Matchers() []Endpoint
 }
 
-func MatchFilters(endpoint Endpoint, filters []Filter) []Filter {
-	matchingFilters := make([]Filter, 0)
-	for _, f := range filters {
-		fEndpoints := f.Matchers()
-		for _, fEndpoint := range fEndpoints {
-			if strings.HasPrefix(endpoint.Path, fEndpoint.Path) && endpoint.Method == fEndpoint.Method {
-				matchingFilters = append(matchingFilters, f)
-				break
-			}
+// RouteFilters returns the filters which the route declares it needs
+func RouteFilters(route Route, api *Api) ([]Filter, error) {
+	var scopes []string
+	if route.Scopes != nil {
+		scopes = route.Scopes()
+	}
+	switch {
+	case route.Public && route.Auth:
+		return nil, fmt.Errorf("route %s %s is both public and authenticated", route.Endpoint.Method, route.Endpoint.Path)
+	case !route.Public && !route.Auth:
+		return nil, fmt.Errorf("route %s %s must be either public or authenticated", route.Endpoint.Method, route.Endpoint.Path)
+	case len(scopes) > 0 && !route.Auth:
+		return nil, fmt.Errorf("route %s %s declares scopes but no authentication", route.Endpoint.Method, route.Endpoint.Path)
+	case route.MerchantScoped && !route.Auth:
+		return nil, fmt.Errorf("route %s %s is merchant scoped but not authenticated", route.Endpoint.Method, route.Endpoint.Path)
+	}
+
+	filters := make([]Filter, 0)
+	if route.Auth {
+		if api.AuthFilter == nil {
+			return nil, fmt.Errorf("no auth filter for route %s %s", route.Endpoint.Method, route.Endpoint.Path)
 		}
+		filters = append(filters, api.AuthFilter)
 	}
-	return matchingFilters
+	if route.MerchantScoped {
+		if api.MerchantFilter == nil {
+			return nil, fmt.Errorf("no merchant filter for route %s %s", route.Endpoint.Method, route.Endpoint.Path)
+		}
+		filters = append(filters, api.MerchantFilter)
+	}
+	return filters, nil
 }
 
 func Chain(handler http.HandlerFunc, filters []Filter) http.HandlerFunc {
@@ -30,10 +55,28 @@ func Chain(handler http.HandlerFunc, filters []Filter) http.HandlerFunc {
 
 	for i := len(filters) - 1; i >= 0; i-- {
 		i := i
+		name := "filter." + filterName(filters[i])
 		handlers[i] = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			filters[i].Execute(rw, req, handlers[i+1])
+			parent := trace.SpanFromContext(req.Context())
+			ctx, span := otel.Tracer(tracerName).Start(req.Context(), name)
+			defer span.End()
+			filters[i].Execute(rw, req.WithContext(ctx), func(rw http.ResponseWriter, req *http.Request) {
+				// The span of the filter ends when it passes the request on, so that it does not contain the next
+				// filters and the handler. The values which the filter added to the context are kept.
+				span.End()
+				handlers[i+1](rw, req.WithContext(trace.ContextWithSpan(req.Context(), parent)))
+			})
 		})
 	}
 
 	return handlers[0]
 }
+
+// filterName is the name of the type of the filter, e.g. AuthFilter
+func filterName(filter Filter) string {
+	t := reflect.TypeOf(filter)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
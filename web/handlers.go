@@ -1,16 +1,18 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"runtime/debug"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/log"
 	"github.com/pankrator/payment/model"
 )
 
@@ -35,10 +37,10 @@ func HandlerWrapper(handler HandlerFunc, modelBlueprint func() model.Object) htt
 		webRequest := &Request{
 			Request: req,
 		}
-		if req.Method == http.MethodPost && modelBlueprint != nil {
+		if (req.Method == http.MethodPost || req.Method == http.MethodPut) && modelBlueprint != nil {
 			data, err := ReadBody(req.Body)
 			if err != nil {
-				WriteError(rw, err)
+				WriteError(req.Context(), rw, err)
 				return
 			}
 
@@ -51,12 +53,12 @@ func HandlerWrapper(handler HandlerFunc, modelBlueprint func() model.Object) htt
 			object := modelBlueprint()
 			object, err = parseModel(contentType, data, object)
 			if err != nil {
-				WriteError(rw, err)
+				WriteError(req.Context(), rw, err)
 				return
 			}
 
 			if err := object.Validate(); err != nil {
-				WriteError(rw, &HTTPError{
+				WriteError(req.Context(), rw, &HTTPError{
 					StatusCode:  http.StatusBadRequest,
 					Description: fmt.Sprintf("Validation of model failed: %s", err),
 				})
@@ -121,12 +123,11 @@ func recoveryMiddleware() mux.MiddlewareFunc {
 		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					WriteError(rw, &HTTPError{
+					WriteError(req.Context(), rw, &HTTPError{
 						StatusCode:  http.StatusInternalServerError,
 						Description: "Unexpected error occured",
 					})
-					log.Printf("Unexpected error occured %s", err)
-					debug.PrintStack()
+					log.C(req.Context()).Errorf("Unexpected error occured %s\n%s", err, debug.Stack())
 				}
 			}()
 			handler.ServeHTTP(rw, req)
@@ -134,6 +135,22 @@ func recoveryMiddleware() mux.MiddlewareFunc {
 	}
 }
 
+// timeoutMiddleware ends the context of the requests after the request timeout, so that the storage queries and
+// other work done for them stop
+func timeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if timeout <= 0 {
+				handler.ServeHTTP(rw, req)
+				return
+			}
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+			handler.ServeHTTP(rw, req.WithContext(ctx))
+		})
+	}
+}
+
 func WriteJSON(rw http.ResponseWriter, status int, v interface{}) {
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(status)
@@ -146,14 +163,25 @@ func WriteJSON(rw http.ResponseWriter, status int, v interface{}) {
 	}
 }
 
-func WriteError(rw http.ResponseWriter, err error) {
-	log.Printf("error occured %s", err)
+// WriteBytes writes an already marshalled response body
+func WriteBytes(rw http.ResponseWriter, status int, contentType string, data []byte) {
+	rw.Header().Set("Content-Type", contentType)
+	rw.WriteHeader(status)
+	if _, errWrite := rw.Write(data); errWrite != nil {
+		panic(errWrite)
+	}
+}
+
+// WriteError writes the error to the response and logs it with the logger of the request context
+func WriteError(ctx context.Context, rw http.ResponseWriter, err error) {
 	rw.Header().Set("Content-Type", "application/json")
 	var httpError *HTTPError
 	switch v := err.(type) {
 	case *HTTPError:
+		log.C(ctx).Infof("Request failed with status %d: %s", v.StatusCode, err)
 		httpError = v
 	default:
+		log.C(ctx).Errorf("Request failed: %s", err)
 		httpError = &HTTPError{
 			StatusCode:  http.StatusInternalServerError,
 			Description: "Internal Server Error",
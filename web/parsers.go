@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
-	"log"
 	"strings"
+
+	"github.com/pankrator/payment/log"
 )
 
 type Parser interface {
@@ -36,7 +37,7 @@ type XMLParser struct{}
 func (xp *XMLParser) Unmarshal(data []byte, object interface{}) error {
 	err := xml.Unmarshal(data, object)
 	if err != nil {
-		log.Printf("Could not parse XML: %s", err)
+		log.D().Debugf("Could not parse XML: %s", err)
 		return errors.New("Could not parse XML")
 	}
 	return nil
@@ -47,7 +48,7 @@ type JSONParser struct{}
 func (jp *JSONParser) Unmarshal(data []byte, object interface{}) error {
 	err := json.Unmarshal(data, object)
 	if err != nil {
-		log.Printf("Could not parse JSON: %s", err)
+		log.D().Debugf("Could not parse JSON: %s", err)
 		return errors.New("Could not parse JSON")
 	}
 	return nil
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/log"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the id of the request, which is returned in the response and logged with the request
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the ids taken from the clients, so that they cannot inject arbitrary text in the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the id of the request
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, found := ctx.Value(requestIDKey{}).(string)
+	return id, found
+}
+
+// requestIDMiddleware takes the id of the request from the header or generates one. The logger of the request
+// context logs it together with the method and the path.
+func requestIDMiddleware() mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(id) {
+				UUID, err := uuid.NewV4()
+				if err != nil {
+					log.C(req.Context()).Errorf("Could not generate request id: %s", err)
+				}
+				id = UUID.String()
+			}
+			rw.Header().Set(RequestIDHeader, id)
+
+			ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+			ctx = log.ContextWithFields(ctx, logrus.Fields{
+				"request_id": id,
+				"method":     req.Method,
+				"path":       req.URL.Path,
+			})
+			handler.ServeHTTP(rw, req.WithContext(ctx))
+		})
+	}
+}
@@ -18,7 +18,7 @@ func ScopeWrapper(handler HandlerFunc, scopesFunc func() []string) HandlerFunc {
 		}
 		user, found := UserFromContext(req.Request.Context())
 		if !found {
-			WriteError(rw, &HTTPError{
+			WriteError(req.Request.Context(), rw, &HTTPError{
 				StatusCode:  http.StatusUnauthorized,
 				Description: fmt.Sprintf("User not found"),
 			})
@@ -27,7 +27,7 @@ func ScopeWrapper(handler HandlerFunc, scopesFunc func() []string) HandlerFunc {
 
 		matched, scope := HasScopes(user.Scopes, requiredScopes)
 		if !matched {
-			WriteError(rw, &HTTPError{
+			WriteError(req.Request.Context(), rw, &HTTPError{
 				StatusCode:  http.StatusForbidden,
 				Description: fmt.Sprintf("User scopes [%s] does not contain required scope %s", strings.Join(user.Scopes, ","), scope),
 			})
@@ -2,13 +2,17 @@ package web
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
+	"github.com/pankrator/payment/log"
 )
 
 type Settings struct {
@@ -19,6 +23,14 @@ type Settings struct {
 	WriteTimeout      time.Duration `mapstructure:"write_timeout"`
 	UseCSRFProtection bool          `mapstructure:"use_csrf_protection"`
 	CSRFTokenKey      string        `mapstructure:"csrf_key"`
+	// TLSCertFile and TLSKeyFile make the server listen with TLS
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+	// ClientCAFile verifies the client certificates, which requests may be authenticated with
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	// ShutdownDelay is how long the server keeps serving after it reported that it is shutting down,
+	// so that no more traffic is routed to it before it drains the requests
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
 }
 
 func DefaultSettings() *Settings {
@@ -42,39 +54,63 @@ func (s *Settings) Keys() []string {
 		"write_timeout",
 		"use_csrf_protection",
 		"csrf_key",
+		"tls_cert_file",
+		"tls_key_file",
+		"client_ca_file",
+		"shutdown_delay",
 	}
 }
 
 type Server struct {
 	Router   *mux.Router
 	settings *Settings
+
+	shutdownHooks []func()
+}
+
+// OnShutdown registers a function which is called when the server starts shutting down, before it drains the requests
+func (s *Server) OnShutdown(hook func()) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
 }
 
-func NewServer(s *Settings, api *Api) *Server {
+// NewServer registers the routes of the api. It fails when a route does not declare its authentication consistently.
+func NewServer(s *Settings, api *Api) (*Server, error) {
 	router := mux.NewRouter()
 	router.StrictSlash(true)
+	router.Use(requestIDMiddleware())
 	router.Use(recoveryMiddleware())
+	router.Use(timeoutMiddleware(s.RequestTimeout))
 	if s.UseCSRFProtection {
 		router.Use(csrf.Protect([]byte(s.CSRFTokenKey), csrf.Secure(false)))
 	}
-	registerControllers(api, router)
+	if err := registerControllers(api, router); err != nil {
+		return nil, err
+	}
 
 	return &Server{
 		Router:   router,
 		settings: s,
-	}
+	}, nil
 }
 
-func registerControllers(api *Api, router *mux.Router) {
+func registerControllers(api *Api, router *mux.Router) error {
 	for _, ctrl := range api.Controllers {
 		for _, route := range ctrl.Routes() {
-			log.Printf("Registering endpoint: %s %s", route.Endpoint.Method, route.Endpoint.Path)
+			filters, err := RouteFilters(route, api)
+			if err != nil {
+				return err
+			}
+			log.D().Debugf("Registering endpoint: %s %s", route.Endpoint.Method, route.Endpoint.Path)
 			securedHandler := ScopeWrapper(route.Handler, route.Scopes)
 			wrappedHandler := HandlerWrapper(securedHandler, route.ModelBlueprint)
-			chainedHandler := Chain(wrappedHandler, MatchFilters(route.Endpoint, api.Filters))
+			chainedHandler := Chain(wrappedHandler, filters)
+			for _, wrap := range api.Wrappers {
+				chainedHandler = wrap(route, chainedHandler)
+			}
 			router.Handle(route.Endpoint.Path, chainedHandler).Methods(route.Endpoint.Method)
 		}
 	}
+	return nil
 }
 
 func (s *Server) Run(ctx context.Context, wg *sync.WaitGroup) {
@@ -85,23 +121,60 @@ func (s *Server) Run(ctx context.Context, wg *sync.WaitGroup) {
 		WriteTimeout:      s.settings.WriteTimeout,
 		ReadHeaderTimeout: s.settings.HeaderTimeout,
 	}
-	go shutdownServer(ctx, wg, server)
+	if s.settings.ClientCAFile != "" {
+		tlsConfig, err := clientAuthConfig(s.settings.ClientCAFile)
+		if err != nil {
+			log.D().Panicf("could not load client CA: %s", err)
+		}
+		server.TLSConfig = tlsConfig
+	}
+	go s.shutdown(ctx, wg, server)
 
 	wg.Add(1)
-	log.Printf("Server listening on %s:%s...", s.settings.Host, s.settings.Port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Panicf("http server failed: %s", err)
+	log.D().Infof("Server listening on %s:%s...", s.settings.Host, s.settings.Port)
+	var err error
+	if s.settings.TLSCertFile != "" {
+		err = server.ListenAndServeTLS(s.settings.TLSCertFile, s.settings.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.D().Panicf("http server failed: %s", err)
 	}
 }
 
-func shutdownServer(ctx context.Context, wg *sync.WaitGroup, server *http.Server) {
+// clientAuthConfig verifies the client certificates given with the CA. Requests without a certificate are still
+// accepted to be authenticated otherwise.
+func clientAuthConfig(caFile string) (*tls.Config, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+func (s *Server) shutdown(ctx context.Context, wg *sync.WaitGroup, server *http.Server) {
 	<-ctx.Done()
 	defer wg.Done()
-	log.Printf("Server shutting down. Will wait for 5 seconds...")
+	for _, hook := range s.shutdownHooks {
+		hook()
+	}
+	if s.settings.ShutdownDelay > 0 {
+		log.D().Infof("Server reported shutting down. Will keep serving for %s...", s.settings.ShutdownDelay)
+		time.Sleep(s.settings.ShutdownDelay)
+	}
+	log.D().Infof("Server shutting down. Will wait for 5 seconds...")
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 	err := server.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Printf("Server errored while shutting down: %s", err)
+		log.D().Errorf("Server errored while shutting down: %s", err)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/gavv/httpexpect"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 	"github.com/pankrator/payment/model"
 	"github.com/pankrator/payment/web"
 )
@@ -18,9 +19,12 @@ var _ = Describe("Server", func() {
 
 	settings := web.DefaultSettings()
 	settings.UseCSRFProtection = false
-	server := web.NewServer(settings, &web.Api{
+	server, err := web.NewServer(settings, &web.Api{
 		Controllers: []web.Controller{&testCtrl{}},
 	})
+	if err != nil {
+		panic(err)
+	}
 	testServer := httptest.NewServer(server.Router)
 	psExpect := httpexpect.New(GinkgoT(), testServer.URL)
 
@@ -86,6 +90,15 @@ var _ = Describe("Server", func() {
 				})
 			})
 		})
+
+		Context("PUT", func() {
+			It("should parse and validate the model", func() {
+				psExpect.PUT("/test_put").WithJSON(&model.Transaction{Amount: 10}).Expect().
+					Status(http.StatusBadRequest).
+					JSON().Object().Value("description").String().
+					Contains("Validation of model failed: merchant id is required for transaction")
+			})
+		})
 	})
 
 	Context("unknown path", func() {
@@ -94,6 +107,59 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	Context("route declarations", func() {
+		It("should fail for routes with scopes but no authentication", func() {
+			_, err := web.NewServer(settings, &web.Api{
+				Controllers: []web.Controller{&scopedCtrl{auth: false}},
+			})
+			Expect(err).To(MatchError(ContainSubstring("declares scopes but no authentication")))
+		})
+
+		It("should fail for routes which are neither public nor authenticated", func() {
+			_, err := web.NewServer(settings, &web.Api{
+				Controllers: []web.Controller{&testCtrl{}, &undeclaredCtrl{}},
+			})
+			Expect(err).To(MatchError(ContainSubstring("must be either public or authenticated")))
+		})
+
+		It("should authenticate only the routes which require it", func() {
+			authServer, err := web.NewServer(settings, &web.Api{
+				AuthFilter:  &rejectFilter{},
+				Controllers: []web.Controller{&testCtrl{}, &scopedCtrl{auth: true}},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			authTestServer := httptest.NewServer(authServer.Router)
+			defer authTestServer.Close()
+			authExpect := httpexpect.New(GinkgoT(), authTestServer.URL)
+
+			authExpect.GET("/test_get").Expect().Status(http.StatusOK)
+			authExpect.GET("/scoped").Expect().Status(http.StatusUnauthorized)
+		})
+	})
+
+	Context("request id", func() {
+		It("should return the id given by the client", func() {
+			psExpect.GET("/test_get").WithHeader(web.RequestIDHeader, "client-id.1").Expect().
+				Status(http.StatusOK).
+				Header(web.RequestIDHeader).Equal("client-id.1")
+		})
+
+		It("should generate ids for requests without a valid one", func() {
+			psExpect.GET("/test_get").Expect().
+				Status(http.StatusOK).
+				Header(web.RequestIDHeader).Match("^[0-9a-f-]{36}$")
+			psExpect.GET("/test_get").WithHeader(web.RequestIDHeader, "with spaces").Expect().
+				Status(http.StatusOK).
+				Header(web.RequestIDHeader).NotEqual("with spaces")
+		})
+	})
+
+	It("should end the context of the requests after the request timeout", func() {
+		psExpect.GET("/deadline").Expect().
+			Status(http.StatusOK).
+			JSON().Object().ValueEqual("deadline", true)
+	})
+
 	When("controller panics", func() {
 		It("should recover", func() {
 			psExpect.GET("/panic").Expect().Status(http.StatusInternalServerError).
@@ -112,6 +178,7 @@ func (t *testCtrl) Routes() []web.Route {
 				Method: http.MethodGet,
 				Path:   "/test_get",
 			},
+			Public: true,
 			Handler: func(rw http.ResponseWriter, req *web.Request) {
 				web.WriteJSON(rw, http.StatusOK, map[string]interface{}{
 					"result": "OK",
@@ -123,6 +190,7 @@ func (t *testCtrl) Routes() []web.Route {
 				Method: http.MethodPost,
 				Path:   "/test_post",
 			},
+			Public: true,
 			Handler: func(rw http.ResponseWriter, req *web.Request) {
 				web.WriteJSON(rw, http.StatusCreated, req.Model)
 			},
@@ -130,14 +198,89 @@ func (t *testCtrl) Routes() []web.Route {
 				return &model.Transaction{}
 			},
 		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodPut,
+				Path:   "/test_put",
+			},
+			Public: true,
+			Handler: func(rw http.ResponseWriter, req *web.Request) {
+				web.WriteJSON(rw, http.StatusOK, req.Model)
+			},
+			ModelBlueprint: func() model.Object {
+				return &model.Transaction{}
+			},
+		},
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/deadline",
+			},
+			Public: true,
+			Handler: func(rw http.ResponseWriter, req *web.Request) {
+				_, found := req.Request.Context().Deadline()
+				web.WriteJSON(rw, http.StatusOK, map[string]interface{}{
+					"deadline": found,
+				})
+			},
+		},
 		{
 			Endpoint: web.Endpoint{
 				Method: http.MethodGet,
 				Path:   "/panic",
 			},
+			Public: true,
 			Handler: func(rw http.ResponseWriter, req *web.Request) {
 				panic("unexpected")
 			},
 		},
 	}
 }
+
+type scopedCtrl struct {
+	auth bool
+}
+
+func (t *scopedCtrl) Routes() []web.Route {
+	return []web.Route{
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/scoped",
+			},
+			Public: !t.auth,
+			Auth:   t.auth,
+			Scopes: func() []string {
+				return []string{"test.read"}
+			},
+			Handler: func(rw http.ResponseWriter, req *web.Request) {
+				web.WriteJSON(rw, http.StatusOK, map[string]interface{}{})
+			},
+		},
+	}
+}
+
+type undeclaredCtrl struct{}
+
+func (t *undeclaredCtrl) Routes() []web.Route {
+	return []web.Route{
+		{
+			Endpoint: web.Endpoint{
+				Method: http.MethodGet,
+				Path:   "/undeclared",
+			},
+			Handler: func(rw http.ResponseWriter, req *web.Request) {
+				web.WriteJSON(rw, http.StatusOK, map[string]interface{}{})
+			},
+		},
+	}
+}
+
+type rejectFilter struct{}
+
+func (f *rejectFilter) Execute(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	web.WriteError(req.Context(), rw, &web.HTTPError{
+		StatusCode:  http.StatusUnauthorized,
+		Description: "rejected",
+	})
+}